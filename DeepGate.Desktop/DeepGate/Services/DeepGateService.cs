@@ -103,7 +103,8 @@ public class DeepGateService: IDeepGateService
             using var reader = new StreamReader(stream);
 
             string messageBuilder = "";
-            bool thinkTag = false;
+            string eventType = "";
+            bool failed = false;
 
             // Run streaming in a background task
             await Task.Run(async () =>
@@ -112,38 +113,45 @@ public class DeepGateService: IDeepGateService
                 {
                     var line = await reader.ReadLineAsync();
 
-                    if (string.IsNullOrWhiteSpace(line)) continue; // Skip empty lines
+                    if (string.IsNullOrWhiteSpace(line)) continue; // Skip frame separators
 
-                    Console.WriteLine($"Received line: {line}");
-
-                    if (line.StartsWith("data:", StringComparison.OrdinalIgnoreCase))
+                    if (line.StartsWith("event:", StringComparison.OrdinalIgnoreCase))
                     {
-                        var data = line.Substring(5);
-                        if (data.Equals("[DONE]", StringComparison.OrdinalIgnoreCase))
-                            break;
+                        eventType = line.Substring(6).Trim();
+                        continue;
+                    }
 
-                        if (data.Equals("<think>"))
-                        {
-                            thinkTag = true;
-                        }
+                    if (!line.StartsWith("data:", StringComparison.OrdinalIgnoreCase)) continue;
 
-                        if (!thinkTag)
-                        {
-                            messageBuilder += data;
-                            MainThread.BeginInvokeOnMainThread(() =>
-                            {
-                                answer(messageBuilder); // Update UI without freezing
-                            });
-                        }
-
-                        if (data.Equals("</think>"))
+                    // Every frame carries a stream event as JSON, see Pkgs/DataBinding/stream.go
+                    using var streamEvent = JsonDocument.Parse(line.Substring(5));
+                    var root = streamEvent.RootElement;
+                    var type = eventType != "" ? eventType : root.GetProperty("type").GetString();
+                    eventType = "";
+
+                    if (type == "token" && root.TryGetProperty("content", out var content))
+                    {
+                        messageBuilder += content.GetString();
+                        var message = messageBuilder;
+                        MainThread.BeginInvokeOnMainThread(() =>
                         {
-                            thinkTag = false;
-                        }
+                            answer(message); // Update UI without freezing
+                        });
+                    }
+                    else if (type == "error")
+                    {
+                        var error = root.TryGetProperty("error", out var errorMessage) ? errorMessage.GetString() : "unknown error";
+                        System.Console.WriteLine("Chat completion failed: " + error);
+                        failed = true;
+                        break;
+                    }
+                    else if (type == "done")
+                    {
+                        break;
                     }
                 }
             });
-            return true;
+            return !failed;
         }
         catch (Exception ex)
         {
@@ -2,44 +2,45 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
+
+	databinding "Pkgs/DataBinding"
 )
 
 // APIClient struct to manage API calls
 type APIClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
-	Logger     *log.Logger
+	Logger     *slog.Logger
 }
 
 // NewAPIClient initializes a new API client
-func NewAPIClient(baseURL string) *APIClient {
+func NewAPIClient(baseURL string, timeout time.Duration) *APIClient {
 	return &APIClient{
 		BaseURL: baseURL,
 		HTTPClient: &http.Client{
-			Timeout: 10 * time.Second, // Set timeout for API calls
+			Timeout: timeout, // Set timeout for API calls
 		},
 	}
 }
 
-func MakeTemporaryAPIClient(ipAddress, hostPort string) *APIClient {
-	return NewAPIClient(fmt.Sprintf("http://%s:%s", ipAddress, hostPort))
+func MakeTemporaryAPIClient(ipAddress, hostPort string, timeout time.Duration) *APIClient {
+	return NewAPIClient(fmt.Sprintf("http://%s:%s", ipAddress, hostPort), timeout)
 }
 
 // MakeRequest is a generic method to send API requests
-func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, headers map[string]string) ([]byte, error) {
+func (c *APIClient) MakeRequest(ctx context.Context, method, endpoint string, body interface{}, headers map[string]string) ([]byte, error) {
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 
 	var reqBody io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
-		var ok = string(jsonBody)
-		fmt.Println(ok)
 		if err != nil {
 			return nil, err
 		}
@@ -47,13 +48,15 @@ func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, heade
 	}
 
 	// Create a new request
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
+	databinding.InjectTraceContext(ctx, req.Header)
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
@@ -78,20 +81,29 @@ func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, heade
 	return respBody, nil
 }
 
-// In clients/api_client.go
-
-func (c *APIClient) MakeStreamingRequest(method, endpoint string, body io.Reader) (*http.Response, error) {
+// MakeStreamingRequest sends a request whose response is read as a stream.
+// Cancelling ctx aborts the stream.
+func (c *APIClient) MakeStreamingRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
 	url := c.BaseURL + endpoint
-	c.HTTPClient = &http.Client{
+	streamClient := &http.Client{
 		Timeout: 0, // No timeout for streaming
 	}
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Connection", "keep-alive")
+	setRequestID(ctx, req)
+	databinding.InjectTraceContext(ctx, req.Header)
 
-	return c.HTTPClient.Do(req)
+	return streamClient.Do(req)
+}
+
+// setRequestID forwards the request ID carried by ctx
+func setRequestID(ctx context.Context, req *http.Request) {
+	if requestID := databinding.RequestID(ctx); requestID != "" {
+		req.Header.Set(databinding.RequestIDHeader, requestID)
+	}
 }
@@ -3,119 +3,232 @@ package clients
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
+	"host/metrics"
+
 	databinding "Pkgs/DataBinding"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // APIService struct for interacting with multiple APIs
 type OllamaClient struct {
 	api    *APIClient
-	logger *log.Logger
+	logger *slog.Logger
+
+	// loads has no fixed timeout, loading a large model can take minutes.
+	// Loads and unloads are bounded by the caller's context instead
+	loads *APIClient
+
+	// HoldLoadedModels keeps models in memory until they are explicitly
+	// unloaded, instead of Ollama's own keep alive expiry
+	HoldLoadedModels bool
+}
+
+// ollamaChatRequest is the /api/chat payload. Ollama is always streamed
+// from, non-streaming chats are aggregated by the caller.
+type ollamaChatRequest struct {
+	Model     string                   `json:"model"`
+	Messages  []databinding.Message    `json:"messages"`
+	Stream    bool                     `json:"stream"`
+	Options   *databinding.ChatOptions `json:"options,omitempty"`
+	Format    json.RawMessage          `json:"format,omitempty"`
+	KeepAlive interface{}              `json:"keep_alive,omitempty"`
+}
+
+// newOllamaChatRequest builds the Ollama payload of a chat, fields only the
+// Node uses such as the conversation ID are left out
+func (o *OllamaClient) newOllamaChatRequest(chat databinding.ChatCompletion) ollamaChatRequest {
+	return ollamaChatRequest{
+		Model:     chat.Model,
+		Messages:  chat.Messages,
+		Stream:    true,
+		Options:   chat.Options,
+		Format:    chat.Format,
+		KeepAlive: o.chatKeepAlive(chat),
+	}
+}
+
+// chatKeepAlive returns the keep_alive of a chat, falling back to the
+// client's own. Ollama reads strings as durations and numbers as seconds.
+func (o *OllamaClient) chatKeepAlive(chat databinding.ChatCompletion) interface{} {
+	if chat.KeepAlive == "" {
+		return o.keepAlive()
+	}
+	if seconds, err := strconv.Atoi(chat.KeepAlive); err == nil {
+		return seconds
+	}
+	return chat.KeepAlive
+}
+
+// keepAlive returns the keep_alive value to send with loads and chats
+func (o *OllamaClient) keepAlive() interface{} {
+	if o.HoldLoadedModels {
+		return -1
+	}
+	return nil
 }
 
 // NewAPIService initializes a new API service with logging
-func NewOllamaClient(ollama_port string, logger *log.Logger) *OllamaClient {
+func NewOllamaClient(ollama_port int, timeout time.Duration, logger *slog.Logger) *OllamaClient {
+	baseURL := fmt.Sprintf("http://localhost:%d", ollama_port)
 	return &OllamaClient{
-		api:    NewAPIClient(fmt.Sprintf("http://localhost:%s", ollama_port)),
+		api:    NewAPIClient(baseURL, timeout),
 		logger: logger,
+		loads:  NewAPIClient(baseURL, 0),
 	}
 }
 
 // FetchLocalModelList calls the local API to get available models
-func (o *OllamaClient) FetchLocalModelList() (map[string]interface{}, error) {
+func (o *OllamaClient) FetchLocalModelList(ctx context.Context) (data map[string]interface{}, err error) {
+	ctx, span := databinding.StartSpan(ctx, "FetchLocalModelList")
+	defer func() { databinding.EndSpan(span, err) }()
+
 	start := time.Now()
-	o.logger.Printf("Fetching local model list...")
+	o.logger.DebugContext(ctx, "Fetching local model list")
 
-	respBody, err := o.api.MakeRequest("GET", "/api/tags", nil, nil)
+	respBody, err := o.api.MakeRequest(ctx, "GET", "/api/tags", nil, nil)
+	metrics.OllamaRequest("tags", err)
 	if err != nil {
-		o.logger.Printf("Error fetching model list: %v", err)
+		o.logger.ErrorContext(ctx, "Error fetching model list", "error", err)
 		return nil, err
 	}
 
-	var data map[string]interface{}
 	err = json.Unmarshal(respBody, &data)
 	if err != nil {
-		o.logger.Printf("Error unmarshaling response: %v", err)
+		o.logger.ErrorContext(ctx, "Error unmarshaling model list", "error", err)
 		return nil, err
 	}
 
 	elapsed := time.Since(start)
-	o.logger.Printf("Successfully fetched model list in %v", elapsed)
+	o.logger.DebugContext(ctx, "Successfully fetched model list", "elapsed", elapsed)
 	return data, nil
 }
 
+// FetchRunningModels returns the names of the models currently loaded in memory
+func (o *OllamaClient) FetchRunningModels(ctx context.Context) (names []string, err error) {
+	ctx, span := databinding.StartSpan(ctx, "FetchRunningModels")
+	defer func() { databinding.EndSpan(span, err) }()
+
+	respBody, err := o.api.MakeRequest(ctx, "GET", "/api/ps", nil, nil)
+	metrics.OllamaRequest("ps", err)
+	if err != nil {
+		o.logger.ErrorContext(ctx, "Error fetching running models", "error", err)
+		return nil, err
+	}
+
+	var response struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		o.logger.ErrorContext(ctx, "Error unmarshaling running models", "error", err)
+		return nil, err
+	}
+
+	names = make([]string, len(response.Models))
+	for i, model := range response.Models {
+		names[i] = model.Name
+	}
+	return names, nil
+}
+
 // LoadLocalModel loads a specific model
-func (o *OllamaClient) LoadLocalModel(modelName string) error {
+func (o *OllamaClient) LoadLocalModel(ctx context.Context, modelName string) (err error) {
+	ctx, span := databinding.StartSpan(ctx, "LoadLocalModel", attribute.String("deepgate.model", modelName))
+	defer func() { databinding.EndSpan(span, err) }()
+
 	start := time.Now()
-	o.logger.Printf("Starting to load model: %s", modelName)
+	o.logger.InfoContext(ctx, "Starting to load model", "model", modelName)
 
 	jsonPayload := map[string]interface{}{
 		"model": modelName,
 	}
+	if keepAlive := o.keepAlive(); keepAlive != nil {
+		jsonPayload["keep_alive"] = keepAlive
+	}
 
-	respBody, err := o.api.MakeRequest("POST", "/api/generate", jsonPayload, nil)
+	respBody, err := o.loads.MakeRequest(ctx, "POST", "/api/generate", jsonPayload, nil)
+	metrics.OllamaRequest("load", err)
+	metrics.ObserveModelLoad(modelName, time.Since(start), err)
 	if err != nil {
-		o.logger.Printf("Error loading model %s: %v", modelName, err)
+		o.logger.ErrorContext(ctx, "Error loading model", "model", modelName, "error", err)
 		return err
 	}
 
 	var response map[string]interface{}
 	err = json.Unmarshal(respBody, &response)
 	if err != nil {
-		o.logger.Printf("Error parsing response: %v", err)
+		o.logger.ErrorContext(ctx, "Error parsing load response", "model", modelName, "error", err)
 		return err
 	}
 
 	elapsed := time.Since(start)
-	o.logger.Printf("Successfully loaded model %s in %v", modelName, elapsed)
+	o.logger.InfoContext(ctx, "Successfully loaded model", "model", modelName, "elapsed", elapsed)
 	return nil
 }
 
-// StopModel stops a running model instance
-func (o *OllamaClient) StopModel() error {
+// StopModel unloads a model from memory by asking Ollama to keep it alive for zero seconds
+func (o *OllamaClient) StopModel(ctx context.Context, modelName string) (err error) {
+	ctx, span := databinding.StartSpan(ctx, "StopModel", attribute.String("deepgate.model", modelName))
+	defer func() { databinding.EndSpan(span, err) }()
+
 	start := time.Now()
-	o.logger.Printf("Attempting to stop running model...")
+	o.logger.InfoContext(ctx, "Attempting to unload model", "model", modelName)
 
-	// Ollama's stop endpoint doesn't require a model name as it stops the currently running model
-	respBody, err := o.api.MakeRequest("POST", "/api/stop", nil, nil)
+	jsonPayload := map[string]interface{}{
+		"model":      modelName,
+		"keep_alive": 0,
+	}
+
+	respBody, err := o.loads.MakeRequest(ctx, "POST", "/api/generate", jsonPayload, nil)
+	metrics.OllamaRequest("unload", err)
 	if err != nil {
-		o.logger.Printf("Error stopping model: %v", err)
-		return fmt.Errorf("failed to stop model: %v", err)
+		o.logger.ErrorContext(ctx, "Error unloading model", "model", modelName, "error", err)
+		return fmt.Errorf("failed to unload model: %v", err)
 	}
 
 	// Check if we got a response
 	if len(respBody) > 0 {
 		var response map[string]interface{}
 		if err := json.Unmarshal(respBody, &response); err != nil {
-			o.logger.Printf("Warning: Could not parse stop response: %v", err)
-			// Don't return error as the stop might have still succeeded
+			o.logger.WarnContext(ctx, "Could not parse unload response", "model", modelName, "error", err)
+			// Don't return error as the unload might have still succeeded
 		}
 	}
 
 	elapsed := time.Since(start)
-	o.logger.Printf("Successfully stopped model in %v", elapsed)
+	o.logger.InfoContext(ctx, "Successfully unloaded model", "model", modelName, "elapsed", elapsed)
 	return nil
 }
 
 // CheckModelStatus checks if a model is loaded and ready
-func (o *OllamaClient) CheckModelStatus(modelName string) (bool, error) {
+func (o *OllamaClient) CheckModelStatus(ctx context.Context, modelName string) (loaded bool, err error) {
+	ctx, span := databinding.StartSpan(ctx, "CheckModelStatus", attribute.String("deepgate.model", modelName))
+	defer func() { databinding.EndSpan(span, err) }()
+
 	start := time.Now()
-	o.logger.Printf("Checking status for model: %s", modelName)
+	o.logger.DebugContext(ctx, "Checking model status", "model", modelName)
 
-	models, err := o.FetchLocalModelList()
+	models, err := o.FetchLocalModelList(ctx)
 	if err != nil {
-		o.logger.Printf("Error checking model status: %v", err)
+		o.logger.ErrorContext(ctx, "Error checking model status", "model", modelName, "error", err)
 		return false, err
 	}
 
 	// Check if model exists in the list
 	modelList, ok := models["models"].([]interface{})
 	if !ok {
-		o.logger.Printf("Invalid response format while checking model status")
+		o.logger.ErrorContext(ctx, "Invalid response format while checking model status", "model", modelName)
 		return false, fmt.Errorf("invalid response format")
 	}
 
@@ -123,70 +236,229 @@ func (o *OllamaClient) CheckModelStatus(modelName string) (bool, error) {
 		if modelMap, ok := model.(map[string]interface{}); ok {
 			if modelMap["name"] == modelName {
 				elapsed := time.Since(start)
-				o.logger.Printf("Model %s status check completed in %v", modelName, elapsed)
+				o.logger.DebugContext(ctx, "Model status check completed", "model", modelName, "elapsed", elapsed)
 				return true, nil
 			}
 		}
 	}
 
 	elapsed := time.Since(start)
-	o.logger.Printf("Model %s not found (checked in %v)", modelName, elapsed)
+	o.logger.DebugContext(ctx, "Model not found", "model", modelName, "elapsed", elapsed)
 	return false, nil
 }
 
-// StreamChatCompletion handles streaming chat completion requests
-func (o *OllamaClient) StreamChatCompletion(chat databinding.ChatCompletion, responseChan chan string, errorChan chan error) {
+// StreamChatCompletion streams a chat completion as typed stream events. The
+// event channel is closed after the done event; on failure a single error is
+// sent on errorChan instead. Cancelling ctx aborts the completion.
+func (o *OllamaClient) StreamChatCompletion(ctx context.Context, chat databinding.ChatCompletion, eventChan chan databinding.StreamEvent, errorChan chan error) {
+	ctx, span := databinding.StartSpan(ctx, "StreamChatCompletion", attribute.String("deepgate.model", chat.Model))
+	defer span.End()
+
 	start := time.Now()
-	o.logger.Printf("Starting chat completion streaming for model: %s", chat.Model)
+	o.logger.InfoContext(ctx, "Starting chat completion streaming", "model", chat.Model)
+
+	// fail reports err unless the caller already went away
+	fail := func(err error) {
+		databinding.FailSpan(span, err)
+		if ctx.Err() == nil {
+			metrics.OllamaRequest("chat", err)
+		}
+		select {
+		case errorChan <- err:
+		case <-ctx.Done():
+		}
+	}
 
 	// Prepare the request payload
-	jsonData, err := json.Marshal(chat)
+	jsonData, err := json.Marshal(o.newOllamaChatRequest(chat))
 	if err != nil {
-		o.logger.Printf("Error marshaling chat completion request: %v", err)
-		errorChan <- err
+		o.logger.ErrorContext(ctx, "Error marshaling chat completion request", "error", err)
+		fail(err)
 		return
 	}
 
 	// Make the streaming request
-	resp, err := o.api.MakeStreamingRequest("POST", "/api/chat", bytes.NewBuffer(jsonData))
+	resp, err := o.api.MakeStreamingRequest(ctx, "POST", "/api/chat", bytes.NewBuffer(jsonData))
 	if err != nil {
-		o.logger.Printf("Error initiating streaming request: %v", err)
-		errorChan <- err
+		o.logger.ErrorContext(ctx, "Error initiating streaming request", "model", chat.Model, "error", err)
+		fail(err)
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		o.logger.ErrorContext(ctx, "Ollama rejected chat request", "model", chat.Model, "status", resp.StatusCode, "response", string(respBody))
+		fail(fmt.Errorf("API error: %s", string(respBody)))
+		return
+	}
+
 	// Create a scanner to read the streaming response
 	scanner := bufio.NewScanner(resp.Body)
+	splitter := &thinkSplitter{}
+
+	// send forwards an event unless the caller already went away
+	firstEvent := true
+	send := func(event databinding.StreamEvent) bool {
+		if firstEvent {
+			firstEvent = false
+			metrics.ObserveTimeToFirstToken(chat.Model, time.Since(start))
+		}
+		select {
+		case eventChan <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
 	// Process each chunk of the streaming response
 	for scanner.Scan() {
-		line := scanner.Text()
-
-		fmt.Println("Received line:", line)
+		line := scanner.Bytes()
 
 		var streamResp databinding.StreamResponse
-		if err := json.Unmarshal([]byte(line), &streamResp); err != nil {
-			o.logger.Printf("Error parsing stream chunk: %v", err)
+		if err := json.Unmarshal(line, &streamResp); err != nil {
+			o.logger.WarnContext(ctx, "Error parsing stream chunk", "error", err)
 			continue
 		}
 
-		// Send the content through the channel
-		if streamResp.Message.Content != "" {
-			responseChan <- streamResp.Message.Content
+		var events []databinding.StreamEvent
+		if streamResp.Message.Thinking != "" {
+			events = append(events, databinding.StreamEvent{Type: databinding.EventThinking, Content: streamResp.Message.Thinking})
+		}
+		events = append(events, splitter.Split(streamResp.Message.Content)...)
+		if streamResp.Done {
+			events = append(events, splitter.Flush()...)
+		}
+		if len(streamResp.Message.ToolCalls) > 0 {
+			events = append(events, databinding.StreamEvent{Type: databinding.EventToolCall, ToolCalls: streamResp.Message.ToolCalls})
 		}
 
-		// If done, close the channel
+		// Send the events through the channel
+		for _, event := range events {
+			if !send(event) {
+				o.logger.InfoContext(ctx, "Chat completion cancelled by client", "model", chat.Model)
+				return
+			}
+		}
+
+		// If done, report usage and close the channel
 		if streamResp.Done {
+			usage := streamResp.Usage
+			span.SetAttributes(
+				attribute.Int("deepgate.prompt_tokens", usage.PromptEvalCount),
+				attribute.Int("deepgate.completion_tokens", usage.EvalCount),
+			)
+			metrics.OllamaRequest("chat", nil)
+			metrics.ObserveGeneration(chat.Model, usage.EvalCount, time.Duration(usage.EvalDuration))
+			if !send(databinding.StreamEvent{Type: databinding.EventUsage, Usage: &usage}) {
+				return
+			}
+			if !send(databinding.StreamEvent{Type: databinding.EventDone, DoneReason: streamResp.DoneReason}) {
+				return
+			}
 			elapsed := time.Since(start)
-			o.logger.Printf("Streaming completed in %v", elapsed)
-			close(responseChan)
+			o.logger.InfoContext(ctx, "Streaming completed", "model", chat.Model, "elapsed", elapsed)
+			close(eventChan)
 			return
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		o.logger.Printf("Error reading stream: %v", err)
-		errorChan <- err
+		o.logger.ErrorContext(ctx, "Error reading stream", "model", chat.Model, "error", err)
+		fail(err)
+		return
+	}
+
+	o.logger.ErrorContext(ctx, "Stream ended before completion", "model", chat.Model)
+	fail(fmt.Errorf("stream ended before completion"))
+}
+
+// thinkSplitter separates reasoning wrapped in <think> tags from the answer
+// for models that inline their reasoning in the content. Only a reply that
+// opens with <think> is split, a tag quoted later in the answer is left alone.
+// A tag may arrive split across chunks, so a trailing piece that could start
+// one is held back until the next chunk.
+type thinkSplitter struct {
+	state   int    // thinkPending, thinkOpen or thinkClosed
+	pending string // Content held back until it is known whether a tag starts there
+}
+
+const (
+	thinkPending = iota // Nothing but whitespace seen yet
+	thinkOpen           // Inside the reasoning
+	thinkClosed         // In the answer
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// Split turns a content chunk into token and thinking events
+func (t *thinkSplitter) Split(content string) []databinding.StreamEvent {
+	content = t.pending + content
+	t.pending = ""
+
+	var events []databinding.StreamEvent
+	emit := func(eventType, text string) {
+		if text != "" {
+			events = append(events, databinding.StreamEvent{Type: eventType, Content: text})
+		}
+	}
+
+	if t.state == thinkPending {
+		trimmed := strings.TrimLeft(content, " \t\r\n")
+		switch {
+		case strings.HasPrefix(trimmed, thinkOpenTag):
+			t.state = thinkOpen
+			content = strings.TrimPrefix(trimmed, thinkOpenTag)
+		case strings.HasPrefix(thinkOpenTag, trimmed):
+			// Whitespace or the start of the tag, wait for more
+			t.pending = content
+			return nil
+		default:
+			t.state = thinkClosed
+		}
+	}
+
+	if t.state == thinkOpen {
+		thinking, answer, found := strings.Cut(content, thinkCloseTag)
+		if !found {
+			held := partialTagSuffix(content, thinkCloseTag)
+			emit(databinding.EventThinking, content[:len(content)-held])
+			t.pending = content[len(content)-held:]
+			return events
+		}
+		emit(databinding.EventThinking, thinking)
+		t.state = thinkClosed
+		content = answer
+	}
+
+	emit(databinding.EventToken, content)
+	return events
+}
+
+// Flush returns the content still held back once the reply has ended
+func (t *thinkSplitter) Flush() []databinding.StreamEvent {
+	if t.pending == "" {
+		return nil
+	}
+	eventType := databinding.EventToken
+	if t.state == thinkOpen {
+		eventType = databinding.EventThinking
+	}
+	event := databinding.StreamEvent{Type: eventType, Content: t.pending}
+	t.pending = ""
+	return []databinding.StreamEvent{event}
+}
+
+// partialTagSuffix returns the length of the longest end of s that starts tag
+func partialTagSuffix(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
 	}
+	return 0
 }
@@ -0,0 +1,200 @@
+package clients
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	databinding "Pkgs/DataBinding"
+)
+
+func token(content string) databinding.StreamEvent {
+	return databinding.StreamEvent{Type: databinding.EventToken, Content: content}
+}
+
+func thinking(content string) databinding.StreamEvent {
+	return databinding.StreamEvent{Type: databinding.EventThinking, Content: content}
+}
+
+func TestThinkSplitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []databinding.StreamEvent
+	}{
+		{
+			name:   "no reasoning",
+			chunks: []string{"Hello", " world"},
+			want:   []databinding.StreamEvent{token("Hello"), token(" world")},
+		},
+		{
+			name:   "whole tags",
+			chunks: []string{"<think>", "plan", "</think>", "answer"},
+			want:   []databinding.StreamEvent{thinking("plan"), token("answer")},
+		},
+		{
+			name:   "tags within one chunk",
+			chunks: []string{"<think>plan</think>answer"},
+			want:   []databinding.StreamEvent{thinking("plan"), token("answer")},
+		},
+		{
+			name:   "leading whitespace",
+			chunks: []string{"\n", " <think>plan</think>answer"},
+			want:   []databinding.StreamEvent{thinking("plan"), token("answer")},
+		},
+		{
+			name:   "opening tag split across chunks",
+			chunks: []string{"<th", "ink>plan</think>answer"},
+			want:   []databinding.StreamEvent{thinking("plan"), token("answer")},
+		},
+		{
+			name:   "closing tag split across chunks",
+			chunks: []string{"<think>plan</", "thi", "nk>answer"},
+			want:   []databinding.StreamEvent{thinking("plan"), token("answer")},
+		},
+		{
+			name:   "reasoning mentioning a tag start",
+			chunks: []string{"<think>a <", "b> and </b> is html</think>yes"},
+			want:   []databinding.StreamEvent{thinking("a "), thinking("<b> and </b> is html"), token("yes")},
+		},
+		{
+			name:   "tag quoted in the answer",
+			chunks: []string{"Models wrap reasoning in ", "<think>", " tags"},
+			want:   []databinding.StreamEvent{token("Models wrap reasoning in "), token("<think>"), token(" tags")},
+		},
+		{
+			name:   "answer starting like a tag",
+			chunks: []string{"<th", "ree>"},
+			want:   []databinding.StreamEvent{token("<three>")},
+		},
+		{
+			name:   "second reasoning block is answer text",
+			chunks: []string{"<think>a</think>b<think>c"},
+			want:   []databinding.StreamEvent{thinking("a"), token("b<think>c")},
+		},
+		{
+			name:   "partial opening tag flushed at the end",
+			chunks: []string{"<thi"},
+			want:   []databinding.StreamEvent{token("<thi")},
+		},
+		{
+			name:   "partial closing tag flushed at the end",
+			chunks: []string{"<think>plan</thi"},
+			want:   []databinding.StreamEvent{thinking("plan"), thinking("</thi")},
+		},
+		{
+			name:   "empty chunks",
+			chunks: []string{"", "<think>", "", "</think>", ""},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			splitter := &thinkSplitter{}
+			var got []databinding.StreamEvent
+			for _, chunk := range tt.chunks {
+				got = append(got, splitter.Split(chunk)...)
+			}
+			got = append(got, splitter.Flush()...)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartialTagSuffix(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"plan", 0},
+		{"plan<", 1},
+		{"plan</thin", 6},
+		{"plan</think", 7},
+		{"plan</think>", 0},
+		{"a</b", 0},
+	}
+
+	for _, tt := range tests {
+		if got := partialTagSuffix(tt.s, "</think>"); got != tt.want {
+			t.Errorf("partialTagSuffix(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNewOllamaChatRequest(t *testing.T) {
+	messages := []databinding.Message{{Role: "user", Content: "Hi"}}
+	temperature := 0.5
+
+	tests := []struct {
+		name string
+		hold bool
+		chat databinding.ChatCompletion
+		want string
+	}{
+		{
+			name: "minimal",
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true}`,
+		},
+		{
+			name: "node fields are left out",
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages, ConversationID: "c1", Stream: new(bool)},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true}`,
+		},
+		{
+			name: "options and format",
+			chat: databinding.ChatCompletion{
+				Model:    "llama3",
+				Messages: messages,
+				Options:  &databinding.ChatOptions{Temperature: &temperature},
+				Format:   json.RawMessage(`{"type":"object"}`),
+			},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"options":{"temperature":0.5},"format":{"type":"object"}}`,
+		},
+		{
+			name: "keep_alive seconds",
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages, KeepAlive: "300"},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"keep_alive":300}`,
+		},
+		{
+			name: "keep_alive unload",
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages, KeepAlive: "0"},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"keep_alive":0}`,
+		},
+		{
+			name: "keep_alive duration",
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages, KeepAlive: "5m"},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"keep_alive":"5m"}`,
+		},
+		{
+			name: "held models",
+			hold: true,
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"keep_alive":-1}`,
+		},
+		{
+			name: "chat keep_alive wins over held models",
+			hold: true,
+			chat: databinding.ChatCompletion{Model: "llama3", Messages: messages, KeepAlive: "5m"},
+			want: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":true,"keep_alive":"5m"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &OllamaClient{HoldLoadedModels: tt.hold}
+			data, err := json.Marshal(client.newOllamaChatRequest(tt.chat))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("request = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
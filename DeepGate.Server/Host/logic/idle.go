@@ -0,0 +1,135 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"host/clients"
+)
+
+// unloadTimeout bounds an idle unload, Ollama calls for unloads have no
+// timeout of their own
+const unloadTimeout = time.Minute
+
+// IdleUnloader unloads models that have not been used for a while
+type IdleUnloader struct {
+	ollama   *clients.OllamaClient
+	logger   *slog.Logger
+	timeout  time.Duration
+	onUnload func(modelName string)
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+	inFlight map[string]int
+}
+
+// NewIdleUnloader creates an unloader; onUnload is called after every idle unload
+func NewIdleUnloader(ollama *clients.OllamaClient, logger *slog.Logger, timeout time.Duration, onUnload func(modelName string)) *IdleUnloader {
+	return &IdleUnloader{
+		ollama:   ollama,
+		logger:   logger,
+		timeout:  timeout,
+		onUnload: onUnload,
+		lastUsed: make(map[string]time.Time),
+		inFlight: make(map[string]int),
+	}
+}
+
+// Touch records that a model was loaded or used
+func (u *IdleUnloader) Touch(modelName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastUsed[modelName] = time.Now()
+}
+
+// Begin marks the start of a chat, a model is never unloaded mid-chat
+func (u *IdleUnloader) Begin(modelName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.inFlight[modelName]++
+	u.lastUsed[modelName] = time.Now()
+}
+
+// End marks the end of a chat started with Begin
+func (u *IdleUnloader) End(modelName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.inFlight[modelName] <= 1 {
+		delete(u.inFlight, modelName)
+	} else {
+		u.inFlight[modelName]--
+	}
+	u.lastUsed[modelName] = time.Now()
+}
+
+// Forget stops tracking a model that was unloaded by other means
+func (u *IdleUnloader) Forget(modelName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.lastUsed, modelName)
+}
+
+// Start checks for idle models in the background until ctx is cancelled.
+// A zero timeout disables idle unloading.
+func (u *IdleUnloader) Start(ctx context.Context) {
+	if u.timeout <= 0 {
+		return
+	}
+
+	// Check often enough that a model is unloaded close to its deadline
+	interval := u.timeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				u.unloadIdle()
+			}
+		}
+	}()
+}
+
+// unloadIdle unloads every model idle for longer than the timeout
+func (u *IdleUnloader) unloadIdle() {
+	deadline := time.Now().Add(-u.timeout)
+
+	u.mu.Lock()
+	idle := make(map[string]time.Time)
+	for modelName, lastUsed := range u.lastUsed {
+		if u.inFlight[modelName] == 0 && lastUsed.Before(deadline) {
+			idle[modelName] = lastUsed
+			delete(u.lastUsed, modelName)
+		}
+	}
+	u.mu.Unlock()
+
+	for modelName, lastUsed := range idle {
+		u.logger.Info("Model idle, unloading", "model", modelName, "idle_timeout", u.timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), unloadTimeout)
+		err := u.ollama.StopModel(ctx, modelName)
+		cancel()
+		if err != nil {
+			u.logger.Error("Failed to unload idle model", "model", modelName, "error", err)
+			// Keep tracking it so the next check retries, unless it was used meanwhile
+			u.mu.Lock()
+			if _, used := u.lastUsed[modelName]; !used {
+				u.lastUsed[modelName] = lastUsed
+			}
+			u.mu.Unlock()
+			continue
+		}
+		if u.onUnload != nil {
+			u.onUnload(modelName)
+		}
+	}
+}
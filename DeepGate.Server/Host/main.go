@@ -3,50 +3,217 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	config "Pkgs/Config"
 	databinding "Pkgs/DataBinding"
 
 	"host/clients"
+	"host/logic"
+	"host/metrics"
 	"host/routes"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
+// BrowseTimeout is how long a host listens for nodes advertised over mDNS
+const BrowseTimeout = 3 * time.Second
+
+// DiscoveryRetryInterval is how long a host waits before looking for a node
+// again when none accepted it
+const DiscoveryRetryInterval = 30 * time.Second
+
 type HostServer struct {
-	logger   *log.Logger
-	nodeIP   string
-	hostName string
-	ollama   *clients.OllamaClient
+	logger          *slog.Logger
+	hostName        string
+	ollama          *clients.OllamaClient
+	idle            *logic.IdleUnloader
+	shutdownTracing func(context.Context) error
+	heartbeatOnce   sync.Once
+
+	// heartbeatInterval is how often a registered host reports to the node
+	heartbeatInterval time.Duration
+
+	// port this host listens on and advertises, nodePort the node's when
+	// it is found by scanning the ARP table
+	port     int
+	nodePort int
+	capacity int
+
+	// staticNodeURL skips discovery, otherwise nodes advertised over mDNS
+	// are tried, and the ARP table is scanned once when arpFallback is set.
+	// Beacons of the host's cluster are followed meanwhile
+	staticNodeURL string
+	mdns          bool
+	arpFallback   bool
+	scanned       bool
+	beacon        config.BeaconConfig
+	clusterID     string
+
+	// draining is set on shutdown, the host then leaves the node and only
+	// finishes the requests it is serving
+	draining        atomic.Bool
+	shutdownTimeout time.Duration
+
+	// clusterSecret signs the registration with the node, which answers
+	// with the token it authenticates to this host with. nodeMu guards the
+	// token and the base URL of the node this host registered with.
+	// registerMu serializes registrations so that the host joins one node
+	clusterSecret string
+	registerMu    sync.Mutex
+	nodeMu        sync.Mutex
+	nodeURL       string
+	hostToken     string
+
+	// tls configures this host's server and the client it reaches the node
+	// with, nodeScheme is used for nodes found by scanning the ARP table
+	tls        databinding.TLSConfig
+	nodeScheme string
+	nodeClient *http.Client
 }
 
-func NewHostServer() *HostServer {
+func NewHostServer(cfg *config.Config) *HostServer {
 	hostName, _ := os.Hostname()
-	host_logger := databinding.ConfigureLogger()
-	ollamaClient := clients.NewOllamaClient("11434", host_logger)
-	return &HostServer{
-		logger:   host_logger,
-		hostName: hostName,
-		ollama:   ollamaClient,
+	host_logger := databinding.ConfigureLogger("host", cfg.LogLevel)
+
+	shutdownTracing, err := databinding.ConfigureTracing(context.Background(), "host", cfg.Tracing.Exporter, cfg.Tracing.OTLPEndpoint)
+	if err != nil {
+		host_logger.Error("Failed to configure tracing", "error", err)
+		os.Exit(1)
+	}
+
+	ollamaClient := clients.NewOllamaClient(cfg.Host.OllamaPort, cfg.RequestTimeout, host_logger)
+
+	// Models are unloaded after the idle timeout without use, 0 disables it.
+	// The idle timer owns model lifetime, so Ollama must not expire models itself
+	ollamaClient.HoldLoadedModels = cfg.Host.IdleTimeout > 0
+
+	if cfg.ClusterSecret == "" {
+		host_logger.Warn("No cluster secret is configured, /host routes accept any caller")
+	}
+
+	// The node is reached with the configured scheme, its certificate is
+	// verified against the system roots and the configured CA bundle
+	tlsConfig := databinding.TLSConfig{
+		CertFile:     cfg.TLS.Cert,
+		KeyFile:      cfg.TLS.Key,
+		ClientCAFile: cfg.TLS.ClientCA,
+		CAFile:       cfg.TLS.CA,
+	}
+	nodeTLS, err := tlsConfig.ClientConfig()
+	if err != nil {
+		host_logger.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+	nodeTransport := http.DefaultTransport.(*http.Transport).Clone()
+	nodeTransport.TLSClientConfig = nodeTLS
+
+	hs := &HostServer{
+		logger:            host_logger,
+		hostName:          hostName,
+		ollama:            ollamaClient,
+		shutdownTracing:   shutdownTracing,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		port:              cfg.Host.Port,
+		nodePort:          cfg.Node.Port,
+		capacity:          cfg.Host.Capacity,
+		staticNodeURL:     cfg.Host.NodeURL,
+		mdns:              cfg.MDNS,
+		arpFallback:       cfg.Host.ARPFallback,
+		beacon:            cfg.Beacon,
+		clusterID:         cfg.ClusterID,
+		shutdownTimeout:   cfg.ShutdownTimeout,
+		clusterSecret:     cfg.ClusterSecret,
+		tls:               tlsConfig,
+		nodeScheme:        cfg.Host.NodeScheme,
+		nodeClient:        &http.Client{Transport: nodeTransport, Timeout: cfg.RequestTimeout},
+	}
+	hs.idle = logic.NewIdleUnloader(ollamaClient, host_logger, cfg.Host.IdleTimeout, hs.reportModelUnloaded)
+	return hs
+}
+
+// discoverNode looks for a node until one accepts this host or ctx is done
+func (hs *HostServer) discoverNode(ctx context.Context) {
+	for hs.getNodeURL() == "" && !hs.findNode(ctx) {
+		hs.logger.Info("No node accepted this host, retrying", "in", DiscoveryRetryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DiscoveryRetryInterval):
+		}
+	}
+}
+
+// findNode registers with the configured node, or with a node advertised over
+// mDNS and then, once, one found in the ARP table
+func (hs *HostServer) findNode(ctx context.Context) bool {
+	if hs.staticNodeURL != "" {
+		return hs.tryPingNode(hs.staticNodeURL)
+	}
+
+	if hs.mdns {
+		browseCtx, cancel := context.WithTimeout(ctx, BrowseTimeout)
+		nodeURLs, err := databinding.BrowseNodes(browseCtx)
+		cancel()
+		if err != nil {
+			hs.logger.Warn("mDNS discovery failed", "error", err)
+		}
+		for _, nodeURL := range nodeURLs {
+			if hs.tryPingNode(nodeURL) {
+				return true
+			}
+		}
+	}
+
+	// Pinging every neighbour is a last resort, done at most once
+	if !hs.arpFallback || hs.scanned {
+		return false
+	}
+	hs.scanned = true
+	return hs.ScanNetwork(ctx)
+}
+
+// listenForBeacons registers with the node of the first beacon of this host's
+// cluster, as long as the host has no node
+func (hs *HostServer) listenForBeacons(ctx context.Context) {
+	hs.logger.Info("Listening for node beacons", "port", hs.beacon.Port, "cluster", hs.clusterID)
+	err := databinding.ListenBeacons(ctx, hs.beacon.Port, hs.clusterID, hs.clusterSecret, func(nodeURL string) {
+		if hs.getNodeURL() != "" {
+			return
+		}
+		hs.logger.Info("Node beacon received", "node_url", nodeURL)
+		hs.tryPingNode(nodeURL)
+	})
+	if err != nil {
+		hs.logger.Warn("Stopped listening for node beacons", "error", err)
 	}
 }
 
-func (hs *HostServer) ScanNetwork() {
-	hs.logger.Println("Starting network scan...")
+// ScanNetwork pings the addresses in the ARP table one at a time until a node
+// accepts this host
+func (hs *HostServer) ScanNetwork(ctx context.Context) bool {
+	hs.logger.Info("Starting network scan")
 
 	ips := hs.getActiveIPs()
 	if len(ips) == 0 {
-		hs.logger.Println("No active IP addresses found.")
-		return
+		hs.logger.Info("No active IP addresses found")
+		return false
 	}
 
 	localIP := hs.getLocalIP()
@@ -56,8 +223,20 @@ func (hs *HostServer) ScanNetwork() {
 			continue
 		}
 
-		go hs.tryPingNode(ip)
+		// Stop once a beacon led to a node in the meantime
+		if ctx.Err() != nil {
+			return false
+		}
+		if hs.getNodeURL() != "" {
+			return true
+		}
+
+		nodeURL := fmt.Sprintf("%s://%s", hs.nodeScheme, net.JoinHostPort(ip, strconv.Itoa(hs.nodePort)))
+		if hs.tryPingNode(nodeURL) {
+			return true
+		}
 	}
+	return false
 }
 
 func (hs *HostServer) getLocalIP() string {
@@ -75,32 +254,209 @@ func (hs *HostServer) getLocalIP() string {
 	return ""
 }
 
-func (hs *HostServer) tryPingNode(ip string) {
-	url := fmt.Sprintf("http://%s:8080/ping", ip)
+// tryPingNode registers this host with the node at nodeURL and reports
+// whether it was accepted
+func (hs *HostServer) tryPingNode(nodeURL string) bool {
+	hs.registerMu.Lock()
+	defer hs.registerMu.Unlock()
+
+	// A host that is shutting down must not register again
+	if hs.draining.Load() {
+		return false
+	}
+
+	// Once registered, only the same node is pinged again to re-register
+	if current := hs.getNodeURL(); current != "" && current != nodeURL {
+		return false
+	}
 
 	infoPackage := databinding.InfoPackage{
 		IPAddress:  hs.getLocalIP(),
 		Identifier: 0, // Host
 		HostName:   hs.hostName,
 		Timestamp:  time.Now().Unix(),
-		HostPort:   "9090",
+		HostPort:   strconv.Itoa(hs.port),
+		Scheme:     hs.tls.Scheme(),
+		Capacity:   hs.capacity,
+	}
+	if hs.clusterSecret != "" {
+		infoPackage.Sign(hs.clusterSecret)
+	}
+
+	resp, err := hs.postToNode(nodeURL, "/ping", infoPackage)
+	if err != nil {
+		hs.logger.Debug("Ping failed", "node_url", nodeURL, "error", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		hs.logger.Warn("Node rejected registration, check the cluster secret", "node_url", nodeURL)
+		return false
 	}
 
-	jsonData, _ := json.Marshal(infoPackage)
+	if resp.StatusCode == http.StatusOK {
+		var pingResponse databinding.PingResponse
+		if err := json.NewDecoder(resp.Body).Decode(&pingResponse); err != nil {
+			hs.logger.Warn("Invalid ping response", "node_url", nodeURL, "error", err)
+			return false
+		}
+		hs.setNode(nodeURL, pingResponse.HostToken)
+
+		hs.logger.Info("Node found", "node_url", nodeURL)
+		hs.heartbeatOnce.Do(func() {
+			go hs.runHeartbeat()
+		})
+		return true
+	}
+	return false
+}
+
+// runHeartbeat periodically reports liveness and loaded models to the node
+func (hs *HostServer) runHeartbeat() {
+	ticker := time.NewTicker(hs.heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if hs.draining.Load() {
+			return
+		}
+		hs.sendHeartbeat()
+	}
+}
+
+func (hs *HostServer) sendHeartbeat() {
+	loadedModels, err := hs.ollama.FetchRunningModels(context.Background())
+	if err != nil {
+		// Still send the heartbeat, the host itself is alive
+		loadedModels = []string{}
+	}
+
+	heartbeat := databinding.Heartbeat{
+		IPAddress:    hs.getLocalIP(),
+		HostPort:     strconv.Itoa(hs.port),
+		Timestamp:    time.Now().Unix(),
+		LoadedModels: loadedModels,
+	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	nodeURL := hs.getNodeURL()
+	resp, err := hs.postToNode(nodeURL, "/heartbeat", heartbeat)
 	if err != nil {
-		hs.logger.Printf("Ping to %s failed: %v", ip, err)
+		hs.logger.Warn("Heartbeat failed", "node_url", nodeURL, "error", err)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		hs.logger.Printf("Node found at %s!", ip)
-		hs.nodeIP = ip
-		// Optionally, you might want to stop further scanning here
+	// The node forgot about us (restart or expiry) or no longer accepts our
+	// token, register again
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
+		hs.logger.Info("Node does not know this host, re-registering", "node_url", nodeURL, "status", resp.StatusCode)
+		hs.tryPingNode(nodeURL)
 		return
 	}
+
+	// Keep the renewed token
+	var heartbeatResponse databinding.HeartbeatResponse
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&heartbeatResponse) == nil && heartbeatResponse.HostToken != "" {
+		hs.setHostToken(heartbeatResponse.HostToken)
+	}
+}
+
+// postToNode sends a JSON payload to a node, authenticated with the host
+// token once the node issued one
+func (hs *HostServer) postToNode(nodeURL, path string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", nodeURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := hs.getHostToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return hs.nodeClient.Do(req)
+}
+
+// deregister tells the node to stop scheduling to this host
+func (hs *HostServer) deregister() {
+	nodeURL := hs.getNodeURL()
+	if nodeURL == "" {
+		return
+	}
+
+	deregistration := databinding.Deregistration{
+		IPAddress: hs.getLocalIP(),
+		Timestamp: time.Now().Unix(),
+	}
+
+	resp, err := hs.postToNode(nodeURL, "/deregister", deregistration)
+	if err != nil {
+		hs.logger.Warn("Failed to deregister from node", "node_url", nodeURL, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		hs.logger.Warn("Node rejected deregistration", "node_url", nodeURL, "status", resp.Status)
+		return
+	}
+	hs.logger.Info("Deregistered from node", "node_url", nodeURL)
+}
+
+// setNode records the node this host registered with and the token it issued
+func (hs *HostServer) setNode(nodeURL, token string) {
+	hs.nodeMu.Lock()
+	defer hs.nodeMu.Unlock()
+	hs.nodeURL = nodeURL
+	hs.hostToken = token
+}
+
+func (hs *HostServer) getNodeURL() string {
+	hs.nodeMu.Lock()
+	defer hs.nodeMu.Unlock()
+	return hs.nodeURL
+}
+
+func (hs *HostServer) setHostToken(token string) {
+	hs.nodeMu.Lock()
+	defer hs.nodeMu.Unlock()
+	hs.hostToken = token
+}
+
+func (hs *HostServer) getHostToken() string {
+	hs.nodeMu.Lock()
+	defer hs.nodeMu.Unlock()
+	return hs.hostToken
+}
+
+// reportModelUnloaded tells the node that a model is no longer loaded here
+func (hs *HostServer) reportModelUnloaded(modelName string) {
+	nodeURL := hs.getNodeURL()
+	if nodeURL == "" {
+		return
+	}
+
+	status := databinding.ModelStatus{
+		IPAddress: hs.getLocalIP(),
+		Model:     modelName,
+		Loaded:    false,
+	}
+
+	resp, err := hs.postToNode(nodeURL, "/model-status", status)
+	if err != nil {
+		hs.logger.Warn("Failed to report model unload to node", "model", modelName, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		hs.logger.Warn("Node rejected model unload report", "model", modelName, "status", resp.Status)
+	}
 }
 
 func (hs *HostServer) getActiveIPs() []string {
@@ -114,7 +470,7 @@ func (hs *HostServer) getActiveIPs() []string {
 	case "darwin":
 		ips = hs.getActiveIPsDarwin()
 	default:
-		hs.logger.Printf("Unsupported OS: %s", runtime.GOOS)
+		hs.logger.Error("Unsupported OS", "os", runtime.GOOS)
 	}
 
 	return ips
@@ -126,7 +482,7 @@ func (hs *HostServer) getActiveIPsLinux() []string {
 	arpFile := "/proc/net/arp"
 	file, err := os.Open(arpFile)
 	if err != nil {
-		hs.logger.Printf("Error opening %s: %v", arpFile, err)
+		hs.logger.Error("Error opening ARP table", "path", arpFile, "error", err)
 		return ips
 	}
 	defer file.Close()
@@ -151,7 +507,7 @@ func (hs *HostServer) getActiveIPsLinux() []string {
 	}
 
 	if err := scanner.Err(); err != nil {
-		hs.logger.Printf("Error reading %s: %v", arpFile, err)
+		hs.logger.Error("Error reading ARP table", "path", arpFile, "error", err)
 	}
 
 	return ips
@@ -163,7 +519,7 @@ func (hs *HostServer) getActiveIPsWindows() []string {
 	cmd := exec.Command("arp", "-a")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		hs.logger.Printf("Error executing arp command: %v", err)
+		hs.logger.Error("Error executing arp command", "error", err)
 		return ips
 	}
 
@@ -195,7 +551,7 @@ func (hs *HostServer) getActiveIPsDarwin() []string {
 	cmd := exec.Command("arp", "-a")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		hs.logger.Printf("Error executing arp command: %v", err)
+		hs.logger.Error("Error executing arp command", "error", err)
 		return ips
 	}
 
@@ -225,33 +581,82 @@ func (hs *HostServer) getActiveIPsDarwin() []string {
 }
 
 func (hs *HostServer) SetupRoutes() *gin.Engine {
-	r := gin.Default()
-
-	routeHandler := routes.NewRouteHandler(hs.logger, hs.ollama)
+	r := gin.New()
+	r.Use(gin.Recovery(), otelgin.Middleware("deepgate-host"), databinding.RequestIDMiddleware(), databinding.AccessLog(hs.logger), metrics.Middleware())
+	r.GET("/metrics", metrics.Handler())
+
+	// Only the node that enrolled this host may call the /host routes
+	auth := routes.RequireHostToken(hs.logger, hs.clusterSecret, hs.getLocalIP())
+	drain := routes.RejectWhileDraining(hs.draining.Load)
+	routeHandler := routes.NewRouteHandler(hs.logger, hs.ollama, hs.idle, auth, drain)
 	routeHandler.RegisterRoutes(r)
 
 	return r
 }
 
-func (hs *HostServer) Run(skipScan bool) {
-	// Ask whether to scan the network
-	if skipScan {
-		hs.ScanNetwork()
+func (hs *HostServer) Run() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hs.idle.Start(ctx)
+
+	server, err := hs.tls.NewServer(fmt.Sprintf("0.0.0.0:%d", hs.port), hs.SetupRoutes())
+	if err != nil {
+		hs.logger.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// The node calls back while registering, so listen before looking for it
+	listener, err := databinding.Listen(server)
+	if err != nil {
+		hs.logger.Error("Failed to listen", "address", server.Addr, "error", err)
+		os.Exit(1)
+	}
+
+	hs.logger.Info("Host server starting", "address", server.Addr, "scheme", hs.tls.Scheme())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- databinding.Serve(server, listener)
+	}()
+	go hs.discoverNode(ctx)
+	if hs.staticNodeURL == "" && hs.beacon.Enabled {
+		// Without the secret anyone could forge a beacon for a rogue node
+		if hs.clusterSecret == "" {
+			hs.logger.Warn("No cluster secret is configured, ignoring node beacons")
+		} else {
+			go hs.listenForBeacons(ctx)
+		}
 	}
 
-	r := hs.SetupRoutes()
-	hs.logger.Println("Host server starting on 0.0.0.0:9090")
-	r.Run("0.0.0.0:9090")
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			hs.logger.Error("Host server stopped", "error", err)
+		}
+	case <-ctx.Done():
+		// Refuse new chats and leave the node, then let running chats finish
+		hs.logger.Info("Shutting down, draining requests", "timeout", hs.shutdownTimeout)
+		hs.draining.Store(true)
+		hs.deregister()
+		if err := databinding.Shutdown(server, hs.shutdownTimeout); err != nil {
+			hs.logger.Warn("Host server did not drain", "error", err)
+		}
+		hs.logger.Info("Host server stopped")
+	}
+
+	// Flush the spans that are still buffered
+	if err := hs.shutdownTracing(context.Background()); err != nil {
+		hs.logger.Error("Failed to flush traces", "error", err)
+	}
 }
 
 func main() {
-	//reader := bufio.NewReader(os.Stdin)
-	//fmt.Print("Do you want to run a network scan? (yes/no): ")
-	//input, _ := reader.ReadString('\n')
-	//input = strings.TrimSpace(strings.ToLower(input))
-
-	//skipScan := (input == "yes" || input == "y")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	hostServer := NewHostServer()
-	hostServer.Run(false)
+	hostServer := NewHostServer(cfg)
+	hostServer.Run()
 }
@@ -0,0 +1,122 @@
+// Package metrics exposes the Host's Prometheus metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "deepgate_host"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, including the whole stream for chats.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"route", "method"})
+
+	timeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time from sending a chat to Ollama until its first event.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"model"})
+
+	tokensPerSecond = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tokens_per_second",
+		Help:      "Generation speed of completed chats as reported by Ollama.",
+		Buckets:   prometheus.LinearBuckets(5, 10, 12),
+	}, []string{"model"})
+
+	inFlightStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inflight_streams",
+		Help:      "Chats currently running on this host.",
+	})
+
+	modelLoadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "model_load_duration_seconds",
+		Help:      "Duration of model loads in Ollama, by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"model", "result"})
+
+	ollamaRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ollama_requests_total",
+		Help:      "Requests to Ollama by operation and result (success or error).",
+	}, []string{"operation", "result"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records the count and latency of every request by route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Use the route pattern so path parameters do not explode the label set
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		requestsTotal.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		requestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveTimeToFirstToken records how long Ollama took to start streaming
+func ObserveTimeToFirstToken(model string, d time.Duration) {
+	timeToFirstToken.WithLabelValues(model).Observe(d.Seconds())
+}
+
+// ObserveGeneration records the generation speed of a completed chat
+func ObserveGeneration(model string, completionTokens int, evalDuration time.Duration) {
+	if evalDuration > 0 && completionTokens > 0 {
+		tokensPerSecond.WithLabelValues(model).Observe(float64(completionTokens) / evalDuration.Seconds())
+	}
+}
+
+// StreamStarted counts a new running chat, StreamEnded undoes it
+func StreamStarted() {
+	inFlightStreams.Inc()
+}
+
+func StreamEnded() {
+	inFlightStreams.Dec()
+}
+
+// ObserveModelLoad records the duration of a model load and whether it succeeded
+func ObserveModelLoad(model string, d time.Duration, err error) {
+	modelLoadDuration.WithLabelValues(model, result(err)).Observe(d.Seconds())
+}
+
+// OllamaRequest counts a request to Ollama and whether it failed
+func OllamaRequest(operation string, err error) {
+	ollamaRequests.WithLabelValues(operation, result(err)).Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
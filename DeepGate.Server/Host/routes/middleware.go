@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireHostToken rejects requests without a host token the Node issued to
+// this host with 401. Without a cluster secret every request is accepted.
+func RequireHostToken(logger *slog.Logger, clusterSecret, hostIP string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if clusterSecret == "" {
+			c.Next()
+			return
+		}
+
+		hostToken, err := verifyHostToken(c, clusterSecret)
+		if err == nil && hostToken.HostIP != hostIP {
+			err = fmt.Errorf("token was issued to %s", hostToken.HostIP)
+		}
+		if err != nil {
+			logger.WarnContext(c.Request.Context(), "Rejected request without a valid host token", "client_ip", c.ClientIP(), "error", err)
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid host token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// verifyHostToken checks the bearer token of a request
+func verifyHostToken(c *gin.Context, clusterSecret string) (*databinding.HostToken, error) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, databinding.ErrInvalidHostToken
+	}
+	return databinding.VerifyHostToken(clusterSecret, strings.TrimSpace(token), time.Now())
+}
+
+// RejectWhileDraining answers 503 once the host is shutting down, so that the
+// Node sends the request to another host
+func RejectWhileDraining(draining func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining() {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Host is shutting down"})
+			return
+		}
+		c.Next()
+	}
+}
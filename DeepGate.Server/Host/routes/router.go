@@ -2,33 +2,46 @@ package routes
 
 import (
 	"host/clients"
+	"host/logic"
+	"host/metrics"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	databinding "Pkgs/DataBinding"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type RouteHandler struct {
-	logger *log.Logger
+	logger *slog.Logger
 	ollama *clients.OllamaClient
+	idle   *logic.IdleUnloader
+	auth   gin.HandlerFunc
+	drain  gin.HandlerFunc
 }
 
-func NewRouteHandler(logger *log.Logger, ollamaClient *clients.OllamaClient) *RouteHandler {
+// NewRouteHandler creates the handler of the /host routes, which are guarded
+// by the auth middleware. Loads and chats also pass the drain middleware.
+func NewRouteHandler(logger *slog.Logger, ollamaClient *clients.OllamaClient, idle *logic.IdleUnloader, auth, drain gin.HandlerFunc) *RouteHandler {
 	return &RouteHandler{
 		logger: logger,
 		ollama: ollamaClient,
+		idle:   idle,
+		auth:   auth,
+		drain:  drain,
 	}
 }
 
 // RegisterRoutes registers all host-related routes
 func (r *RouteHandler) RegisterRoutes(router *gin.Engine) {
-	router.POST("/host/load-model", r.handleLoadModel)
-	router.GET("/host/fetch-models", r.handleFetchLocalModelList)
-	router.POST("/host/chat", r.handleChatCompletion)
+	host := router.Group("/host", r.auth)
+	host.POST("/load-model", r.drain, r.handleLoadModel)
+	host.POST("/unload-model", r.handleUnloadModel)
+	host.GET("/fetch-models", r.handleFetchLocalModelList)
+	host.POST("/chat", r.drain, r.handleChatCompletion)
 }
 
 func (r *RouteHandler) handleLoadModel(c *gin.Context) {
@@ -37,27 +50,30 @@ func (r *RouteHandler) handleLoadModel(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		r.logger.Printf("Error binding request: %v", err)
+		r.logger.WarnContext(c.Request.Context(), "Error binding request", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request format",
 		})
 		return
 	}
 
+	ctx := c.Request.Context()
 	start := time.Now()
-	r.logger.Printf("Received request to load model: %s", request.ModelName)
+	r.logger.InfoContext(ctx, "Received request to load model", "model", request.ModelName)
 
-	err := r.ollama.LoadLocalModel(request.ModelName)
+	err := r.ollama.LoadLocalModel(ctx, request.ModelName)
 	if err != nil {
-		r.logger.Printf("Failed to load model %s: %v", request.ModelName, err)
+		r.logger.ErrorContext(ctx, "Failed to load model", "model", request.ModelName, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	r.idle.Touch(request.ModelName)
+
 	elapsed := time.Since(start)
-	r.logger.Printf("Successfully loaded model %s in %v", request.ModelName, elapsed)
+	r.logger.InfoContext(ctx, "Successfully loaded model", "model", request.ModelName, "elapsed", elapsed)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Model loaded successfully",
@@ -66,13 +82,50 @@ func (r *RouteHandler) handleLoadModel(c *gin.Context) {
 	})
 }
 
+func (r *RouteHandler) handleUnloadModel(c *gin.Context) {
+	var request struct {
+		ModelName string `json:"model_name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.logger.WarnContext(c.Request.Context(), "Error binding request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	start := time.Now()
+	r.logger.InfoContext(ctx, "Received request to unload model", "model", request.ModelName)
+
+	err := r.ollama.StopModel(ctx, request.ModelName)
+	if err != nil {
+		r.logger.ErrorContext(ctx, "Failed to unload model", "model", request.ModelName, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	r.idle.Forget(request.ModelName)
+
+	elapsed := time.Since(start)
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Model unloaded successfully",
+		"model":      request.ModelName,
+		"time_taken": elapsed.String(),
+	})
+}
+
 func (r *RouteHandler) handleFetchLocalModelList(c *gin.Context) {
+	ctx := c.Request.Context()
 	start := time.Now()
-	r.logger.Printf("Fetching local model list")
+	r.logger.InfoContext(ctx, "Fetching local model list")
 
-	models, err := r.ollama.FetchLocalModelList()
+	models, err := r.ollama.FetchLocalModelList(ctx)
 	if err != nil {
-		r.logger.Printf("Error fetching model list: %v", err)
+		r.logger.ErrorContext(ctx, "Error fetching model list", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -80,56 +133,123 @@ func (r *RouteHandler) handleFetchLocalModelList(c *gin.Context) {
 	}
 
 	elapsed := time.Since(start)
-	r.logger.Printf("Successfully fetched model list in %v", elapsed)
+	r.logger.InfoContext(ctx, "Successfully fetched model list", "elapsed", elapsed)
 
 	c.JSON(http.StatusOK, models)
 }
 
 func (r *RouteHandler) handleChatCompletion(c *gin.Context) {
+	ctx, span := databinding.StartSpan(c.Request.Context(), "handleChatCompletion")
+	defer span.End()
 	var chatRequest databinding.ChatCompletion
 
 	if err := c.ShouldBindJSON(&chatRequest); err != nil {
-		r.logger.Printf("Error binding chat request: %v", err)
+		r.logger.WarnContext(ctx, "Error binding chat request", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid chat request format",
 		})
 		return
 	}
 
+	if err := chatRequest.Validate(); err != nil {
+		r.logger.WarnContext(ctx, "Invalid chat request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// The Node has already prepended the conversation's messages
+	if len(chatRequest.Messages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "messages are required",
+		})
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("deepgate.model", chatRequest.Model),
+		attribute.Bool("deepgate.stream", chatRequest.IsStreaming()),
+	)
+
 	start := time.Now()
-	r.logger.Printf("Starting chat completion with model: %s", chatRequest.Model)
+	r.logger.InfoContext(ctx, "Starting chat completion", "model", chatRequest.Model)
 
 	// Create channels for streaming
-	responseChan := make(chan string)
+	eventChan := make(chan databinding.StreamEvent)
 	errorChan := make(chan error)
 
+	// Keep the model loaded for the whole chat
+	r.idle.Begin(chatRequest.Model)
+	defer r.idle.End(chatRequest.Model)
+
+	metrics.StreamStarted()
+	defer metrics.StreamEnded()
+
+	// Start streaming in a goroutine
+	go r.ollama.StreamChatCompletion(ctx, chatRequest, eventChan, errorChan)
+
+	if !chatRequest.IsStreaming() {
+		r.collectChatCompletion(c, chatRequest, start, eventChan, errorChan)
+		return
+	}
+
 	// Set up Server-Sent Events
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Transfer-Encoding", "chunked")
-
-	// Start streaming in a goroutine
-	go r.ollama.StreamChatCompletion(chatRequest, responseChan, errorChan)
+	c.Header(databinding.StreamVersionHeader, databinding.StreamProtocolVersion)
 
 	// Stream the response
 	c.Stream(func(w io.Writer) bool {
 		select {
-		case content, ok := <-responseChan:
+		case event, ok := <-eventChan:
 			if !ok {
 				elapsed := time.Since(start)
-				r.logger.Printf("Chat completion finished in %v", elapsed)
+				r.logger.InfoContext(ctx, "Chat completion finished", "model", chatRequest.Model, "elapsed", elapsed)
+				return false
+			}
+			if err := databinding.WriteStreamEvent(w, event); err != nil {
+				r.logger.WarnContext(ctx, "Failed to write stream event", "error", err)
 				return false
 			}
-			c.SSEvent("message", content)
 			return true
 		case err := <-errorChan:
-			r.logger.Printf("Error during chat completion: %v", err)
-			c.SSEvent("error", err.Error())
+			r.logger.ErrorContext(ctx, "Error during chat completion", "model", chatRequest.Model, "error", err)
+			databinding.WriteStreamEvent(w, databinding.StreamEvent{Type: databinding.EventError, Error: err.Error()})
 			return false
-		case <-c.Request.Context().Done():
-			r.logger.Printf("Client disconnected")
+		case <-ctx.Done():
+			r.logger.InfoContext(ctx, "Client disconnected")
 			return false
 		}
 	})
 }
+
+// collectChatCompletion gathers a whole chat and responds with it as one JSON document
+func (r *RouteHandler) collectChatCompletion(c *gin.Context, chatRequest databinding.ChatCompletion, start time.Time, eventChan chan databinding.StreamEvent, errorChan chan error) {
+	ctx := c.Request.Context()
+	response := databinding.NewChatResponse(chatRequest.Model)
+
+	for {
+		select {
+		case event, ok := <-eventChan:
+			if !ok {
+				elapsed := time.Since(start)
+				r.logger.InfoContext(ctx, "Chat completion finished", "model", chatRequest.Model, "elapsed", elapsed)
+				c.JSON(http.StatusOK, response)
+				return
+			}
+			response.Add(event)
+		case err := <-errorChan:
+			r.logger.ErrorContext(ctx, "Error during chat completion", "model", chatRequest.Model, "error", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": err.Error(),
+			})
+			return
+		case <-ctx.Done():
+			r.logger.InfoContext(ctx, "Client disconnected")
+			return
+		}
+	}
+}
@@ -2,35 +2,67 @@ package clients
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	databinding "Pkgs/DataBinding"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // APIClient struct to manage API calls
 type APIClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	Token      string // Host token sent as a bearer token, if set
 }
 
 // NewAPIClient initializes a new API client
-func NewAPIClient(baseURL string) *APIClient {
+func NewAPIClient(baseURL string, timeout time.Duration) *APIClient {
 	return &APIClient{
 		BaseURL: baseURL,
 		HTTPClient: &http.Client{
-			Timeout: 10 * time.Second, // Set timeout for API calls
+			Timeout: timeout, // Set timeout for API calls
 		},
 	}
 }
 
-func MakeTemporaryAPIClient(ipAddress, hostPort string) *APIClient {
-	return NewAPIClient(fmt.Sprintf("http://%s:%s", ipAddress, hostPort))
+// hostTransport carries the calls to every host so that connections are
+// reused across temporary clients
+var hostTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// hostTimeout bounds the non-streaming calls to hosts
+var hostTimeout = 10 * time.Second
+
+// ConfigureHostClient sets how the certificates of https hosts are verified
+// and how long non-streaming calls to hosts may take
+func ConfigureHostClient(config *tls.Config, timeout time.Duration) {
+	hostTransport.TLSClientConfig = config
+	hostTimeout = timeout
+}
+
+// MakeTemporaryAPIClient creates a client for a host at the address and with
+// the scheme it advertised, authenticating with the host's token
+func MakeTemporaryAPIClient(hostInfo databinding.InfoPackage, token string) *APIClient {
+	scheme := hostInfo.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	client := NewAPIClient(fmt.Sprintf("%s://%s:%s", scheme, hostInfo.IPAddress, hostInfo.HostPort), hostTimeout)
+	client.HTTPClient.Transport = hostTransport
+	client.Token = token
+	return client
 }
 
 // MakeRequest is a generic method to send API requests
-func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, headers map[string]string) ([]byte, error) {
+func (c *APIClient) MakeRequest(ctx context.Context, method, endpoint string, body interface{}, headers map[string]string) ([]byte, error) {
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 
 	var reqBody io.Reader
@@ -43,13 +75,18 @@ func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, heade
 	}
 
 	// Create a new request
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
+	databinding.InjectTraceContext(ctx, req.Header)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
@@ -74,43 +111,85 @@ func (c *APIClient) MakeRequest(method, endpoint string, body interface{}, heade
 	return respBody, nil
 }
 
-// MakeStreamRequest sends a request and returns the response body for streaming
-func (c *APIClient) MakeStreamRequest(method, endpoint string, headers map[string]string, body interface{}) (*http.Response, error) {
+// MakeStreamRequest sends a request and returns the response body for streaming.
+// Cancelling ctx aborts the stream, e.g. when the client disconnects. The
+// request is traced until the response body is closed.
+func (c *APIClient) MakeStreamRequest(ctx context.Context, method, endpoint string, headers map[string]string, body interface{}) (*http.Response, error) {
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 
+	ctx, span := databinding.StartSpan(ctx, method+" "+endpoint,
+		attribute.String("http.request.method", method),
+		attribute.String("url.full", url),
+	)
+
 	var reqBody io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
+			databinding.EndSpan(span, err)
 			return nil, err
 		}
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
 	// Create a new request
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
+		databinding.EndSpan(span, err)
 		return nil, err
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
+	databinding.InjectTraceContext(ctx, req.Header)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
 
-	// Send the request
-	resp, err := c.HTTPClient.Do(req)
+	// Send the request without the client timeout, which would otherwise cut
+	// long running streams short
+	streamClient := &http.Client{
+		Transport: c.HTTPClient.Transport,
+	}
+	resp, err := streamClient.Do(req)
 	if err != nil {
+		databinding.EndSpan(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	// Do not close resp.Body here because the caller will handle the streaming
 	if resp.StatusCode >= 400 {
 		respBody, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API error: %s", string(respBody))
+		err := fmt.Errorf("API error: %s", string(respBody))
+		databinding.EndSpan(span, err)
+		return nil, err
 	}
 
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span}
 	return resp, nil
 }
+
+// tracedBody ends the span of a streaming request once its body is closed
+type tracedBody struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
+
+// setRequestID forwards the request ID carried by ctx
+func setRequestID(ctx context.Context, req *http.Request) {
+	if requestID := databinding.RequestID(ctx); requestID != "" {
+		req.Header.Set(databinding.RequestIDHeader, requestID)
+	}
+}
@@ -0,0 +1,216 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"node/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ConversationsCollection = "conversations" // Conversations of every owner
+	AuditCollection         = "audit_log"     // Audit records of chats and model loads
+)
+
+// indexOptionsConflict is the MongoDB error code of an index that exists with
+// other options
+const indexOptionsConflict = 85
+
+type MongoClient struct {
+	database      *mongo.Database
+	conversations *mongo.Collection
+	audit         *mongo.Collection
+}
+
+// NewMongoClient accepts the existing database from DatabaseConnections
+func NewMongoClient(database *mongo.Database) *MongoClient {
+	return &MongoClient{
+		database:      database,
+		conversations: database.Collection(ConversationsCollection),
+		audit:         database.Collection(AuditCollection),
+	}
+}
+
+// EnsureIndexes creates the indexes conversations and audit records are
+// queried by, and the index expiring audit records after auditRetention
+func (mc *MongoClient) EnsureIndexes(ctx context.Context, auditRetention time.Duration) error {
+	_, err := mc.conversations.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner", Value: 1}, {Key: "updated_at", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create conversation indexes: %v", err)
+	}
+
+	_, err = mc.audit.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "model", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "caller", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create audit indexes: %v", err)
+	}
+
+	return mc.ensureAuditRetention(ctx, auditRetention)
+}
+
+// ensureAuditRetention creates the TTL index of the audit records, or
+// updates its expiry when the retention changed
+func (mc *MongoClient) ensureAuditRetention(ctx context.Context, retention time.Duration) error {
+	keys := bson.D{{Key: "time", Value: 1}}
+	seconds := int32(retention.Seconds())
+
+	_, err := mc.audit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == indexOptionsConflict {
+		err = mc.database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: AuditCollection},
+			{Key: "index", Value: bson.D{{Key: "keyPattern", Value: keys}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set audit retention: %v", err)
+	}
+	return nil
+}
+
+// CreateConversation stores a new conversation
+func (mc *MongoClient) CreateConversation(ctx context.Context, conversation models.Conversation) error {
+	if _, err := mc.conversations.InsertOne(ctx, conversation); err != nil {
+		return fmt.Errorf("failed to store conversation: %v", err)
+	}
+	return nil
+}
+
+// ListConversations returns an owner's conversations without their messages,
+// most recently updated first
+func (mc *MongoClient) ListConversations(ctx context.Context, owner string, limit, offset int64) ([]models.Conversation, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetProjection(bson.M{"messages": 0}).
+		SetSkip(offset).
+		SetLimit(limit)
+
+	cursor, err := mc.conversations.Find(ctx, bson.M{"owner": owner}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+
+	conversations := []models.Conversation{}
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode conversations: %v", err)
+	}
+	return conversations, nil
+}
+
+// GetConversation retrieves an owner's conversation, or nil if there is none
+// with that ID
+func (mc *MongoClient) GetConversation(ctx context.Context, owner, id string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := mc.conversations.FindOne(ctx, bson.M{"_id": id, "owner": owner}).Decode(&conversation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation %s: %v", id, err)
+	}
+	return &conversation, nil
+}
+
+// AppendMessages adds messages to the end of an owner's conversation and
+// returns the updated conversation, or nil if there is none with that ID. The
+// conversation's model is updated unless model is empty.
+func (mc *MongoClient) AppendMessages(ctx context.Context, owner, id, model string, messages []models.ConversationMessage, updatedAt int64) (*models.Conversation, error) {
+	set := bson.M{"updated_at": updatedAt}
+	if model != "" {
+		set["model"] = model
+	}
+	update := bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": messages}},
+		"$set":  set,
+	}
+
+	var conversation models.Conversation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := mc.conversations.FindOneAndUpdate(ctx, bson.M{"_id": id, "owner": owner}, update, opts).Decode(&conversation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to append to conversation %s: %v", id, err)
+	}
+	return &conversation, nil
+}
+
+// DeleteConversation removes an owner's conversation and reports whether it existed
+func (mc *MongoClient) DeleteConversation(ctx context.Context, owner, id string) (bool, error) {
+	result, err := mc.conversations.DeleteOne(ctx, bson.M{"_id": id, "owner": owner})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete conversation %s: %v", id, err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// InsertAuditRecord stores an audit record
+func (mc *MongoClient) InsertAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	if _, err := mc.audit.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to store audit record: %v", err)
+	}
+	return nil
+}
+
+// QueryAuditRecords returns the audit records matching a query, newest first
+func (mc *MongoClient) QueryAuditRecords(ctx context.Context, query models.AuditQuery) ([]models.AuditRecord, error) {
+	filter := bson.M{}
+	if query.Model != "" {
+		filter["model"] = query.Model
+	}
+	if query.Caller != "" {
+		filter["caller"] = query.Caller
+	}
+	timeRange := bson.M{}
+	if !query.From.IsZero() {
+		timeRange["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeRange["$lt"] = query.To
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetLimit(query.Limit)
+
+	cursor, err := mc.audit.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit records: %v", err)
+	}
+
+	records := []models.AuditRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode audit records: %v", err)
+	}
+	return records, nil
+}
+
+// RedactAuditRecord removes the prompt and response of an audit record and
+// reports whether the record exists
+func (mc *MongoClient) RedactAuditRecord(ctx context.Context, id string) (bool, error) {
+	result, err := mc.audit.UpdateByID(ctx, id, bson.M{
+		"$unset": bson.M{"prompt": "", "response": ""},
+		"$set":   bson.M{"redacted": true},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to redact audit record %s: %v", id, err)
+	}
+	return result.MatchedCount == 1, nil
+}
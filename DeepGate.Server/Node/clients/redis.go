@@ -4,110 +4,368 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"node/models"
 
+	databinding "Pkgs/DataBinding"
+
 	"github.com/go-redis/redis/v8"
 )
 
 const (
-	LLModelsKey      = "llm_models" // Key for storing list of models
-	LLMHostKeyPrefix = "llm_host:"  // Prefix for host keys
-	DefaultTTL       = 24 * time.Hour
+	LLModelsKey           = "llm_models"          // Legacy key holding all models as one JSON blob
+	LLModelsLegacyKey     = "llm_models:migrated" // Legacy blob after migration
+	LLModelNamesKey       = "llm_model_names"     // Set of registered model names
+	LLModelKeyPrefix      = "llm_model:"          // Prefix for model info hashes
+	LLModelHostsKeyPrefix = "llm_model_hosts:"    // Prefix for model hosting server hashes
+	LLMHostKeyPrefix      = "llm_host:"           // Prefix for host keys
+	LLMHostTasksKey       = "llm_host_tasks"      // Hash of host IP -> in-flight task count
+	LLMHostCooldownPrefix = "llm_host_cooldown:"  // Prefix for keys of hosts in cooldown
+	UsageKeyPrefix        = "llm_usage:"          // Prefix for usage hashes, llm_usage:<dimension>:<name>
+	UsageIndexPrefix      = "llm_usage_index:"    // Prefix for sets of names with usage per dimension
+	APIKeysKey            = "llm_api_keys"        // Set of API key IDs
+	APIKeyPrefix          = "llm_api_key:"        // Prefix for API key records by ID
+	APIKeyHashPrefix      = "llm_api_key_hash:"   // Prefix for API key hash -> ID of valid keys
+	RateLimitPrefix       = "llm_rate:"           // Prefix for rate limit windows, llm_rate:<key ID>:<limit>:<window>
 )
 
 type RedisClient struct {
-	client *redis.Client
+	client  *redis.Client
+	hostTTL time.Duration // How long a host record outlives its last save
 }
 
 // NewRedisClient now accepts the existing Redis client from DatabaseConnections
-func NewRedisClient(client *redis.Client) *RedisClient {
+func NewRedisClient(client *redis.Client, hostTTL time.Duration) *RedisClient {
+	client.AddHook(tracingHook{})
 	return &RedisClient{
-		client: client,
+		client:  client,
+		hostTTL: hostTTL,
 	}
 }
 
-// AddOrUpdateLLModel now takes a slice of models and saves them directly
-func (rc *RedisClient) UpdateLLModelList(ctx context.Context, models []models.LLModel) error {
-	data, err := json.Marshal(models)
-	if err != nil {
-		return fmt.Errorf("failed to marshal models: %v", err)
+// The model registry is stored per model so that concurrent pings and loads
+// only touch the keys they change:
+//
+//	llm_model_names          SET  of model names
+//	llm_model:<name>         HASH of model info fields
+//	llm_model_hosts:<name>   HASH of host IP -> "1" (active) / "0" (inactive)
+//
+// Multi-key updates run inside MULTI transactions or Lua scripts.
+
+// addHostingServerScript registers a host for a model without resetting the
+// status of an already registered host.
+// KEYS[1] = info key, KEYS[2] = hosts key, KEYS[3] = names set
+// ARGV = name, parameter_size, family, size, host IP
+var addHostingServerScript = redis.NewScript(`
+redis.call("HSET", KEYS[1], "name", ARGV[1], "parameter_size", ARGV[2], "family", ARGV[3], "size", ARGV[4])
+redis.call("HSETNX", KEYS[2], ARGV[5], "0")
+redis.call("SADD", KEYS[3], ARGV[1])
+return 1
+`)
+
+// upsertHostingServerScript registers a host for a model with an explicit status.
+// KEYS[1] = info key, KEYS[2] = hosts key, KEYS[3] = names set
+// ARGV = name, parameter_size, family, size, host IP, status
+var upsertHostingServerScript = redis.NewScript(`
+redis.call("HSET", KEYS[1], "name", ARGV[1], "parameter_size", ARGV[2], "family", ARGV[3], "size", ARGV[4])
+redis.call("HSET", KEYS[2], ARGV[5], ARGV[6])
+redis.call("SADD", KEYS[3], ARGV[1])
+return 1
+`)
+
+// setHostingServerStatusScript updates the status only if the host is still
+// registered for the model, so an evicted host is never re-added by accident.
+// KEYS[1] = hosts key, ARGV = host IP, status
+var setHostingServerStatusScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// removeHostingServerScript removes a host from the hosting servers of one
+// model and drops the model once no host is left. It returns 1 if the model
+// was dropped.
+// KEYS[1] = info key, KEYS[2] = hosts key, KEYS[3] = names set
+// ARGV = name, host IP
+var removeHostingServerScript = redis.NewScript(`
+redis.call("HDEL", KEYS[2], ARGV[2])
+if redis.call("HLEN", KEYS[2]) == 0 then
+	redis.call("DEL", KEYS[1])
+	redis.call("SREM", KEYS[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func llModelInfoKey(name string) string {
+	return LLModelKeyPrefix + name
+}
+
+func llModelHostsKey(name string) string {
+	return LLModelHostsKeyPrefix + name
+}
+
+func statusValue(status bool) string {
+	if status {
+		return "1"
 	}
+	return "0"
+}
 
-	return rc.client.Set(ctx, LLModelsKey, data, DefaultTTL).Err()
+func modelInfoArgs(info models.HostModelInfo) []interface{} {
+	return []interface{}{info.Name, info.ParameterSize, info.Family, strconv.FormatInt(info.Size, 10)}
 }
 
-// 🔹 AddOrUpdateLLModel() - Adds a new model or updates an existing one
+// AddOrUpdateLLModel adds a new model or replaces an existing one, including its hosting servers
 func (rc *RedisClient) AddOrUpdateLLModel(ctx context.Context, newModel models.LLModel) error {
-	// Get existing models
-	allModels, err := rc.GetAllLLModels(ctx)
+	name := newModel.Modelinfo.Name
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, llModelInfoKey(name), modelInfoFields(newModel.Modelinfo))
+		pipe.Del(ctx, llModelHostsKey(name))
+		for _, server := range newModel.HostingServers {
+			pipe.HSet(ctx, llModelHostsKey(name), server.IPAdd, statusValue(server.Status))
+		}
+		pipe.SAdd(ctx, LLModelNamesKey, name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save model %s: %v", name, err)
+	}
+	return nil
+}
+
+// AddHostingServer registers a host for a model, creating the model if needed.
+// The status of an already registered host is left untouched.
+func (rc *RedisClient) AddHostingServer(ctx context.Context, info models.HostModelInfo, ipAddress string) error {
+	keys := []string{llModelInfoKey(info.Name), llModelHostsKey(info.Name), LLModelNamesKey}
+	args := append(modelInfoArgs(info), ipAddress)
+	if err := addHostingServerScript.Run(ctx, rc.client, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed to add host %s to model %s: %v", ipAddress, info.Name, err)
+	}
+	return nil
+}
+
+// UpsertHostingServer registers a host for a model with the given status, creating the model if needed
+func (rc *RedisClient) UpsertHostingServer(ctx context.Context, info models.HostModelInfo, ipAddress string, status bool) error {
+	keys := []string{llModelInfoKey(info.Name), llModelHostsKey(info.Name), LLModelNamesKey}
+	args := append(modelInfoArgs(info), ipAddress, statusValue(status))
+	if err := upsertHostingServerScript.Run(ctx, rc.client, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed to upsert host %s for model %s: %v", ipAddress, info.Name, err)
+	}
+	return nil
+}
+
+// SetHostingServerStatus flips the status of a registered hosting server.
+// It reports false if the host is not registered for the model.
+func (rc *RedisClient) SetHostingServerStatus(ctx context.Context, modelName, ipAddress string, status bool) (bool, error) {
+	updated, err := setHostingServerStatusScript.Run(ctx, rc.client, []string{llModelHostsKey(modelName)}, ipAddress, statusValue(status)).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to update host %s for model %s: %v", ipAddress, modelName, err)
+	}
+	return updated == 1, nil
+}
+
+// RemoveHostingServer removes a host from the hosting servers of a model. It
+// reports true if the model had no host left and was removed from the registry.
+func (rc *RedisClient) RemoveHostingServer(ctx context.Context, modelName, ipAddress string) (bool, error) {
+	keys := []string{llModelInfoKey(modelName), llModelHostsKey(modelName), LLModelNamesKey}
+	dropped, err := removeHostingServerScript.Run(ctx, rc.client, keys, modelName, ipAddress).Int()
 	if err != nil {
+		return false, fmt.Errorf("failed to remove host %s from model %s: %v", ipAddress, modelName, err)
+	}
+	return dropped == 1, nil
+}
+
+// maxTxRetries bounds the retries of transactions aborted by a watched key
+const maxTxRetries = 5
+
+// RemoveHostFromAllModels removes a host from the hosting servers of every
+// model, dropping the models no other host serves. The model names are read
+// first so that every key the removal touches is passed to it. The names set
+// is watched, a model registered in the meantime retries the removal.
+func (rc *RedisClient) RemoveHostFromAllModels(ctx context.Context, ipAddress string) error {
+	remove := func(tx *redis.Tx) error {
+		names, err := tx.SMembers(ctx, LLModelNamesKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, name := range names {
+				keys := []string{llModelInfoKey(name), llModelHostsKey(name), LLModelNamesKey}
+				removeHostingServerScript.Eval(ctx, pipe, keys, name, ipAddress)
+			}
+			return nil
+		})
 		return err
 	}
 
-	// Check if model exists and update it
-	found := false
-	for i, model := range allModels {
-		if model.Modelinfo.Name == newModel.Modelinfo.Name {
-			allModels[i] = newModel // Update existing model
-			found = true
+	var err error
+	for attempt := 0; attempt < maxTxRetries; attempt++ {
+		err = rc.client.Watch(ctx, remove, LLModelNamesKey)
+		if err != redis.TxFailedErr {
 			break
 		}
 	}
+	if err != nil {
+		return fmt.Errorf("failed to remove host %s from models: %v", ipAddress, err)
+	}
+	return nil
+}
 
-	// If not found, append it
-	if !found {
-		allModels = append(allModels, newModel)
+// GetLLModel retrieves a single model by name, or nil if it is not registered
+func (rc *RedisClient) GetLLModel(ctx context.Context, modelName string) (*models.LLModel, error) {
+	pipe := rc.client.Pipeline()
+	infoCmd := pipe.HGetAll(ctx, llModelInfoKey(modelName))
+	hostsCmd := pipe.HGetAll(ctx, llModelHostsKey(modelName))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get model %s from Redis: %v", modelName, err)
+	}
+
+	if len(infoCmd.Val()) == 0 {
+		return nil, nil
 	}
 
-	// Save updated models back to Redis
-	return rc.UpdateLLModelList(ctx, allModels)
+	model := buildLLModel(infoCmd.Val(), hostsCmd.Val())
+	return &model, nil
+}
+
+// CountLLModels returns the number of registered models
+func (rc *RedisClient) CountLLModels(ctx context.Context) (int, error) {
+	count, err := rc.client.SCard(ctx, LLModelNamesKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count models: %v", err)
+	}
+	return int(count), nil
 }
 
 // GetAllLLModels retrieves all LLModels from Redis
 func (rc *RedisClient) GetAllLLModels(ctx context.Context) ([]models.LLModel, error) {
-	data, err := rc.client.Get(ctx, LLModelsKey).Bytes()
-	if err == redis.Nil {
-		return []models.LLModel{}, nil
-	}
+	names, err := rc.client.SMembers(ctx, LLModelNamesKey).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get models from Redis: %v", err)
 	}
+	sort.Strings(names)
 
-	var models []models.LLModel
-	if err := json.Unmarshal(data, &models); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal models: %v", err)
+	pipe := rc.client.Pipeline()
+	infoCmds := make([]*redis.StringStringMapCmd, len(names))
+	hostsCmds := make([]*redis.StringStringMapCmd, len(names))
+	for i, name := range names {
+		infoCmds[i] = pipe.HGetAll(ctx, llModelInfoKey(name))
+		hostsCmds[i] = pipe.HGetAll(ctx, llModelHostsKey(name))
+	}
+	if len(names) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get models from Redis: %v", err)
+		}
+	}
+
+	allModels := make([]models.LLModel, 0, len(names))
+	for i := range names {
+		// Skip names whose model was removed in the meantime
+		if len(infoCmds[i].Val()) == 0 {
+			continue
+		}
+		allModels = append(allModels, buildLLModel(infoCmds[i].Val(), hostsCmds[i].Val()))
 	}
 
-	return models, nil
+	return allModels, nil
 }
 
-// RemoveLLModel removes a model from the Redis list
+// RemoveLLModel removes a model and its hosting servers from the registry
 func (rc *RedisClient) RemoveLLModel(ctx context.Context, modelName string) error {
-	all_models, err := rc.GetAllLLModels(ctx)
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, llModelInfoKey(modelName), llModelHostsKey(modelName))
+		pipe.SRem(ctx, LLModelNamesKey, modelName)
+		return nil
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to remove model %s: %v", modelName, err)
 	}
+	return nil
+}
 
-	filtered := make([]models.LLModel, 0)
-	for _, model := range all_models {
-		if model.Modelinfo.Name != modelName {
-			filtered = append(filtered, model)
+// MigrateLLModelList moves models from the legacy single JSON blob into the
+// per model keys. The legacy key is renamed rather than deleted so it can be
+// inspected afterwards. It returns the number of migrated models.
+func (rc *RedisClient) MigrateLLModelList(ctx context.Context) (int, error) {
+	migrated := 0
+	err := rc.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, LLModelsKey).Bytes()
+		if err == redis.Nil {
+			return nil
 		}
+		if err != nil {
+			return err
+		}
+
+		var legacyModels []models.LLModel
+		if err := json.Unmarshal(data, &legacyModels); err != nil {
+			return fmt.Errorf("failed to unmarshal legacy models: %v", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, model := range legacyModels {
+				name := model.Modelinfo.Name
+				pipe.HSet(ctx, llModelInfoKey(name), modelInfoFields(model.Modelinfo))
+				for _, server := range model.HostingServers {
+					pipe.HSetNX(ctx, llModelHostsKey(name), server.IPAdd, statusValue(server.Status))
+				}
+				pipe.SAdd(ctx, LLModelNamesKey, name)
+			}
+			pipe.Rename(ctx, LLModelsKey, LLModelsLegacyKey)
+			return nil
+		})
+		if err == nil {
+			migrated = len(legacyModels)
+		}
+		return err
+	}, LLModelsKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate legacy models: %v", err)
 	}
 
-	return rc.saveModels(ctx, filtered)
+	return migrated, nil
 }
 
-// saveModels helper function to save models to Redis
-func (rc *RedisClient) saveModels(ctx context.Context, models []models.LLModel) error {
-	data, err := json.Marshal(models)
-	if err != nil {
-		return fmt.Errorf("failed to marshal models: %v", err)
+func modelInfoFields(info models.HostModelInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           info.Name,
+		"parameter_size": info.ParameterSize,
+		"family":         info.Family,
+		"size":           strconv.FormatInt(info.Size, 10),
+	}
+}
+
+// buildLLModel assembles an LLModel from its info and hosts hashes
+func buildLLModel(info map[string]string, hosts map[string]string) models.LLModel {
+	size, _ := strconv.ParseInt(info["size"], 10, 64)
+	model := models.LLModel{
+		Modelinfo: models.HostModelInfo{
+			Name:          info["name"],
+			ParameterSize: info["parameter_size"],
+			Family:        info["family"],
+			Size:          size,
+		},
+		HostingServers: make([]models.HostingServer, 0, len(hosts)),
+	}
+
+	for ip, status := range hosts {
+		model.HostingServers = append(model.HostingServers, models.HostingServer{
+			IPAdd:  ip,
+			Status: status == "1",
+		})
 	}
+	sort.Slice(model.HostingServers, func(i, j int) bool {
+		return model.HostingServers[i].IPAdd < model.HostingServers[j].IPAdd
+	})
 
-	return rc.client.Set(ctx, LLModelsKey, data, DefaultTTL).Err()
+	return model
 }
 
 // SaveLLMHost stores a host with its IP as the key
@@ -118,7 +376,108 @@ func (rc *RedisClient) SaveLLMHost(ctx context.Context, host models.LLMHost) err
 	}
 
 	key := LLMHostKeyPrefix + host.HostInfo.IPAddress
-	return rc.client.Set(ctx, key, data, DefaultTTL).Err()
+	return rc.client.Set(ctx, key, data, rc.hostTTL).Err()
+}
+
+// HostModelsChange is what SyncLLMHost changed in the registry
+type HostModelsChange struct {
+	Saved   bool     // The host was saved
+	Removed []string // Models the host no longer has
+	Dropped []string // Removed models left without hosts, dropped from the registry
+}
+
+// SyncLLMHost saves the host returned by update and registers it for its
+// models in one transaction, removing it from the models it had before.
+// update is given the stored host, nil if there is none, and returns nil to
+// leave the registry untouched. The host key is watched, so an eviction or a
+// concurrent sync in the meantime reruns update with the new stored host.
+func (rc *RedisClient) SyncLLMHost(ctx context.Context, ipAddress string, update func(stored *models.LLMHost) *models.LLMHost) (HostModelsChange, error) {
+	key := LLMHostKeyPrefix + ipAddress
+
+	var change HostModelsChange
+	sync := func(tx *redis.Tx) error {
+		change = HostModelsChange{}
+
+		var stored *models.LLMHost
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			stored = &models.LLMHost{}
+			if err := json.Unmarshal(data, stored); err != nil {
+				return fmt.Errorf("failed to unmarshal host: %v", err)
+			}
+		}
+
+		var previous []models.HostModelInfo
+		if stored != nil {
+			previous = stored.ModelInfo
+		}
+		host := update(stored)
+		if host == nil {
+			return nil
+		}
+		data, err = json.Marshal(host)
+		if err != nil {
+			return fmt.Errorf("failed to marshal host: %v", err)
+		}
+
+		removed := removedModels(previous, host.ModelInfo)
+		dropCmds := make([]*redis.Cmd, len(removed))
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, rc.hostTTL)
+			for _, info := range host.ModelInfo {
+				keys := []string{llModelInfoKey(info.Name), llModelHostsKey(info.Name), LLModelNamesKey}
+				addHostingServerScript.Eval(ctx, pipe, keys, append(modelInfoArgs(info), ipAddress)...)
+			}
+			for i, name := range removed {
+				keys := []string{llModelInfoKey(name), llModelHostsKey(name), LLModelNamesKey}
+				dropCmds[i] = removeHostingServerScript.Eval(ctx, pipe, keys, name, ipAddress)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		change.Saved = true
+		change.Removed = removed
+		for i, name := range removed {
+			if dropped, _ := dropCmds[i].Int(); dropped == 1 {
+				change.Dropped = append(change.Dropped, name)
+			}
+		}
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < maxTxRetries; attempt++ {
+		err = rc.client.Watch(ctx, sync, key)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return HostModelsChange{}, fmt.Errorf("failed to sync host %s: %v", ipAddress, err)
+	}
+	return change, nil
+}
+
+// removedModels returns the names of the previous models missing from current
+func removedModels(previous, current []models.HostModelInfo) []string {
+	kept := make(map[string]bool, len(current))
+	for _, hostModel := range current {
+		kept[hostModel.Name] = true
+	}
+
+	var removed []string
+	for _, hostModel := range previous {
+		if !kept[hostModel.Name] {
+			removed = append(removed, hostModel.Name)
+		}
+	}
+	return removed
 }
 
 // GetLLMHost retrieves a host by its IP address
@@ -161,3 +520,305 @@ func (rc *RedisClient) GetAllLLMHostIPs(ctx context.Context) ([]string, error) {
 
 	return ips, nil
 }
+
+// GetAllLLMHosts retrieves every registered host
+func (rc *RedisClient) GetAllLLMHosts(ctx context.Context) ([]models.LLMHost, error) {
+	ips, err := rc.GetAllLLMHostIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]models.LLMHost, 0, len(ips))
+	for _, ip := range ips {
+		host, err := rc.GetLLMHost(ctx, ip)
+		if err != nil {
+			return nil, err
+		}
+		// Key may have expired between KEYS and GET
+		if host != nil {
+			hosts = append(hosts, *host)
+		}
+	}
+
+	return hosts, nil
+}
+
+// decrementTaskScript decrements a host's task count without going below zero
+// KEYS[1] = tasks hash, ARGV[1] = host IP
+var decrementTaskScript = redis.NewScript(`
+local count = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+if count <= 1 then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+	return 0
+end
+return redis.call("HINCRBY", KEYS[1], ARGV[1], -1)
+`)
+
+// IncrementHostTasks atomically increments the in-flight task count of a host
+func (rc *RedisClient) IncrementHostTasks(ctx context.Context, ipAddress string) (int64, error) {
+	count, err := rc.client.HIncrBy(ctx, LLMHostTasksKey, ipAddress, 1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment tasks for %s: %v", ipAddress, err)
+	}
+	return count, nil
+}
+
+// DecrementHostTasks atomically decrements the in-flight task count of a host
+func (rc *RedisClient) DecrementHostTasks(ctx context.Context, ipAddress string) (int64, error) {
+	count, err := decrementTaskScript.Run(ctx, rc.client, []string{LLMHostTasksKey}, ipAddress).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to decrement tasks for %s: %v", ipAddress, err)
+	}
+	return count, nil
+}
+
+// GetHostTaskCounts returns the in-flight task count of every busy host
+func (rc *RedisClient) GetHostTaskCounts(ctx context.Context) (map[string]int, error) {
+	values, err := rc.client.HGetAll(ctx, LLMHostTasksKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task counts: %v", err)
+	}
+
+	counts := make(map[string]int, len(values))
+	for ip, value := range values {
+		count, _ := strconv.Atoi(value)
+		counts[ip] = count
+	}
+	return counts, nil
+}
+
+// ResetHostTaskCounts clears all task counts, used at startup when no streams can be in flight
+func (rc *RedisClient) ResetHostTaskCounts(ctx context.Context) error {
+	return rc.client.Del(ctx, LLMHostTasksKey).Err()
+}
+
+// SetHostCooldown marks a host as unhealthy until the cooldown expires
+func (rc *RedisClient) SetHostCooldown(ctx context.Context, ipAddress string, cooldown time.Duration) error {
+	return rc.client.Set(ctx, LLMHostCooldownPrefix+ipAddress, time.Now().Unix(), cooldown).Err()
+}
+
+// GetHostsInCooldown reports which of the given hosts are currently in cooldown
+func (rc *RedisClient) GetHostsInCooldown(ctx context.Context, ipAddresses []string) (map[string]bool, error) {
+	pipe := rc.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(ipAddresses))
+	for i, ip := range ipAddresses {
+		cmds[i] = pipe.Exists(ctx, LLMHostCooldownPrefix+ip)
+	}
+	if len(ipAddresses) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get host cooldowns: %v", err)
+		}
+	}
+
+	cooling := make(map[string]bool)
+	for i, ip := range ipAddresses {
+		if cmds[i].Val() > 0 {
+			cooling[ip] = true
+		}
+	}
+	return cooling, nil
+}
+
+// RecordUsage adds the usage of a chat to the counters of every dimension,
+// dimensions maps a dimension such as models.UsageByModel to a name
+func (rc *RedisClient) RecordUsage(ctx context.Context, usage databinding.Usage, dimensions map[string]string) error {
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for dimension, name := range dimensions {
+			if name == "" {
+				continue
+			}
+
+			key := UsageKeyPrefix + dimension + ":" + name
+			pipe.HIncrBy(ctx, key, "requests", 1)
+			pipe.HIncrBy(ctx, key, "prompt_tokens", int64(usage.PromptEvalCount))
+			pipe.HIncrBy(ctx, key, "completion_tokens", int64(usage.EvalCount))
+			pipe.HIncrBy(ctx, key, "total_duration", usage.TotalDuration)
+			pipe.HIncrBy(ctx, key, "load_duration", usage.LoadDuration)
+			pipe.HIncrBy(ctx, key, "prompt_eval_duration", usage.PromptEvalDuration)
+			pipe.HIncrBy(ctx, key, "eval_duration", usage.EvalDuration)
+			pipe.SAdd(ctx, UsageIndexPrefix+dimension, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %v", err)
+	}
+	return nil
+}
+
+// GetUsage returns the accumulated usage of every name in a dimension
+func (rc *RedisClient) GetUsage(ctx context.Context, dimension string) (map[string]models.UsageStats, error) {
+	names, err := rc.client.SMembers(ctx, UsageIndexPrefix+dimension).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage index for %s: %v", dimension, err)
+	}
+
+	pipe := rc.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(names))
+	for i, name := range names {
+		cmds[i] = pipe.HGetAll(ctx, UsageKeyPrefix+dimension+":"+name)
+	}
+	if len(names) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get usage for %s: %v", dimension, err)
+		}
+	}
+
+	usage := make(map[string]models.UsageStats, len(names))
+	for i, name := range names {
+		values := cmds[i].Val()
+		counter := func(field string) int64 {
+			value, _ := strconv.ParseInt(values[field], 10, 64)
+			return value
+		}
+
+		usage[name] = models.UsageStats{
+			Requests:           counter("requests"),
+			PromptTokens:       counter("prompt_tokens"),
+			CompletionTokens:   counter("completion_tokens"),
+			TotalDuration:      counter("total_duration"),
+			LoadDuration:       counter("load_duration"),
+			PromptEvalDuration: counter("prompt_eval_duration"),
+			EvalDuration:       counter("eval_duration"),
+		}
+	}
+	return usage, nil
+}
+
+// storedAPIKey is an API key record together with the hash of its secret
+type storedAPIKey struct {
+	models.APIKey
+	Hash string `json:"hash"`
+}
+
+// CreateAPIKey stores a new API key under its ID and the hash of its secret
+func (rc *RedisClient) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) error {
+	data, err := json.Marshal(storedAPIKey{APIKey: key, Hash: hash})
+	if err != nil {
+		return fmt.Errorf("failed to marshal API key: %v", err)
+	}
+
+	_, err = rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, APIKeyPrefix+key.ID, data, 0)
+		pipe.Set(ctx, APIKeyHashPrefix+hash, key.ID, 0)
+		pipe.SAdd(ctx, APIKeysKey, key.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store API key: %v", err)
+	}
+	return nil
+}
+
+// getStoredAPIKey retrieves an API key record by ID, nil if it does not exist
+func (rc *RedisClient) getStoredAPIKey(ctx context.Context, id string) (*storedAPIKey, error) {
+	data, err := rc.client.Get(ctx, APIKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key from Redis: %v", err)
+	}
+
+	var key storedAPIKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API key: %v", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByHash retrieves the valid API key whose secret has the given
+// hash, nil if there is none
+func (rc *RedisClient) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	id, err := rc.client.Get(ctx, APIKeyHashPrefix+hash).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %v", err)
+	}
+
+	key, err := rc.getStoredAPIKey(ctx, id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return &key.APIKey, nil
+}
+
+// GetAllAPIKeys retrieves every API key, revoked ones included, oldest first
+func (rc *RedisClient) GetAllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ids, err := rc.client.SMembers(ctx, APIKeysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key IDs: %v", err)
+	}
+
+	keys := make([]models.APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := rc.getStoredAPIKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked so that its secret no longer
+// authenticates. It returns nil if the key does not exist.
+func (rc *RedisClient) RevokeAPIKey(ctx context.Context, id string) (*models.APIKey, error) {
+	key, err := rc.getStoredAPIKey(ctx, id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return &key.APIKey, nil
+	}
+
+	key.RevokedAt = time.Now().Unix()
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal API key: %v", err)
+	}
+
+	_, err = rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, APIKeyPrefix+id, data, 0)
+		pipe.Del(ctx, APIKeyHashPrefix+key.Hash)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke API key: %v", err)
+	}
+	return &key.APIKey, nil
+}
+
+// IncrementRateWindow adds amount to a rate limit window counter and returns
+// the new total. The counter expires after ttl.
+func (rc *RedisClient) IncrementRateWindow(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, RateLimitPrefix+key, amount)
+		pipe.Expire(ctx, RateLimitPrefix+key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment rate window %s: %v", key, err)
+	}
+	return incr.Val(), nil
+}
+
+// GetRateWindow returns the value of a rate limit window counter
+func (rc *RedisClient) GetRateWindow(ctx context.Context, key string) (int64, error) {
+	value, err := rc.client.Get(ctx, RateLimitPrefix+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate window %s: %v", key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,315 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"node/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	llama   = models.HostModelInfo{Name: "llama3:8b", ParameterSize: "8B", Family: "llama", Size: 4_700_000_000}
+	mistral = models.HostModelInfo{Name: "mistral:7b", ParameterSize: "7B", Family: "llama", Size: 4_100_000_000}
+	qwen    = models.HostModelInfo{Name: "qwen2:1.5b", ParameterSize: "1.5B", Family: "qwen2", Size: 930_000_000}
+)
+
+// newTestRedis returns a client of a fresh in-memory Redis
+func newTestRedis(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisClient(client, time.Minute), server
+}
+
+// hostingServers returns the hosting servers of a model, nil if it is not registered
+func hostingServers(t *testing.T, rc *RedisClient, name string) []models.HostingServer {
+	t.Helper()
+	model, err := rc.GetLLModel(context.Background(), name)
+	if err != nil {
+		t.Fatalf("GetLLModel(%s): %v", name, err)
+	}
+	if model == nil {
+		return nil
+	}
+	return model.HostingServers
+}
+
+// modelNames returns the registered model names
+func modelNames(t *testing.T, server *miniredis.Miniredis) []string {
+	t.Helper()
+	if !server.Exists(LLModelNamesKey) {
+		return nil
+	}
+	names, err := server.SMembers(LLModelNamesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestAddHostingServer(t *testing.T) {
+	ctx := context.Background()
+	rc, server := newTestRedis(t)
+
+	if err := rc.AddHostingServer(ctx, llama, "10.0.0.1"); err != nil {
+		t.Fatalf("AddHostingServer: %v", err)
+	}
+	model, err := rc.GetLLModel(ctx, llama.Name)
+	if err != nil || model == nil {
+		t.Fatalf("GetLLModel: %v, %v", model, err)
+	}
+	if model.Modelinfo != llama {
+		t.Errorf("model info = %+v, want %+v", model.Modelinfo, llama)
+	}
+	if want := []models.HostingServer{{IPAdd: "10.0.0.1"}}; !reflect.DeepEqual(model.HostingServers, want) {
+		t.Errorf("hosting servers = %+v, want %+v", model.HostingServers, want)
+	}
+	if names := modelNames(t, server); !reflect.DeepEqual(names, []string{llama.Name}) {
+		t.Errorf("model names = %v", names)
+	}
+
+	// Adding an active host again keeps it active
+	if err := rc.UpsertHostingServer(ctx, llama, "10.0.0.1", true); err != nil {
+		t.Fatalf("UpsertHostingServer: %v", err)
+	}
+	if err := rc.AddHostingServer(ctx, llama, "10.0.0.1"); err != nil {
+		t.Fatalf("AddHostingServer: %v", err)
+	}
+	if want := []models.HostingServer{{IPAdd: "10.0.0.1", Status: true}}; !reflect.DeepEqual(hostingServers(t, rc, llama.Name), want) {
+		t.Errorf("hosting servers = %+v, want %+v", hostingServers(t, rc, llama.Name), want)
+	}
+}
+
+func TestUpsertHostingServer(t *testing.T) {
+	ctx := context.Background()
+	rc, _ := newTestRedis(t)
+
+	if err := rc.UpsertHostingServer(ctx, llama, "10.0.0.2", true); err != nil {
+		t.Fatalf("UpsertHostingServer: %v", err)
+	}
+	if err := rc.UpsertHostingServer(ctx, llama, "10.0.0.1", false); err != nil {
+		t.Fatalf("UpsertHostingServer: %v", err)
+	}
+	want := []models.HostingServer{{IPAdd: "10.0.0.1"}, {IPAdd: "10.0.0.2", Status: true}}
+	if got := hostingServers(t, rc, llama.Name); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosting servers = %+v, want %+v", got, want)
+	}
+
+	if err := rc.UpsertHostingServer(ctx, llama, "10.0.0.2", false); err != nil {
+		t.Fatalf("UpsertHostingServer: %v", err)
+	}
+	want[1].Status = false
+	if got := hostingServers(t, rc, llama.Name); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosting servers = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetHostingServerStatus(t *testing.T) {
+	ctx := context.Background()
+	rc, _ := newTestRedis(t)
+
+	if err := rc.AddHostingServer(ctx, llama, "10.0.0.1"); err != nil {
+		t.Fatalf("AddHostingServer: %v", err)
+	}
+	if updated, err := rc.SetHostingServerStatus(ctx, llama.Name, "10.0.0.1", true); err != nil || !updated {
+		t.Errorf("SetHostingServerStatus of a registered host = %v, %v", updated, err)
+	}
+
+	// An unregistered host is not added back
+	if updated, err := rc.SetHostingServerStatus(ctx, llama.Name, "10.0.0.9", true); err != nil || updated {
+		t.Errorf("SetHostingServerStatus of an unregistered host = %v, %v", updated, err)
+	}
+	if want := []models.HostingServer{{IPAdd: "10.0.0.1", Status: true}}; !reflect.DeepEqual(hostingServers(t, rc, llama.Name), want) {
+		t.Errorf("hosting servers = %+v, want %+v", hostingServers(t, rc, llama.Name), want)
+	}
+}
+
+func TestRemoveHostingServer(t *testing.T) {
+	ctx := context.Background()
+	rc, server := newTestRedis(t)
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if err := rc.AddHostingServer(ctx, llama, ip); err != nil {
+			t.Fatalf("AddHostingServer: %v", err)
+		}
+	}
+
+	dropped, err := rc.RemoveHostingServer(ctx, llama.Name, "10.0.0.1")
+	if err != nil || dropped {
+		t.Fatalf("RemoveHostingServer of one of two hosts = %v, %v", dropped, err)
+	}
+	if want := []models.HostingServer{{IPAdd: "10.0.0.2"}}; !reflect.DeepEqual(hostingServers(t, rc, llama.Name), want) {
+		t.Errorf("hosting servers = %+v, want %+v", hostingServers(t, rc, llama.Name), want)
+	}
+
+	dropped, err = rc.RemoveHostingServer(ctx, llama.Name, "10.0.0.2")
+	if err != nil || !dropped {
+		t.Fatalf("RemoveHostingServer of the last host = %v, %v", dropped, err)
+	}
+	if model, _ := rc.GetLLModel(ctx, llama.Name); model != nil {
+		t.Errorf("model without hosts still registered: %+v", model)
+	}
+	if names := modelNames(t, server); len(names) != 0 {
+		t.Errorf("model names = %v, want none", names)
+	}
+}
+
+func TestRemoveHostFromAllModels(t *testing.T) {
+	ctx := context.Background()
+	rc, server := newTestRedis(t)
+
+	if err := rc.RemoveHostFromAllModels(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("RemoveHostFromAllModels of an empty registry: %v", err)
+	}
+
+	registrations := []struct {
+		model models.HostModelInfo
+		ip    string
+	}{
+		{llama, "10.0.0.1"},
+		{llama, "10.0.0.2"},
+		{mistral, "10.0.0.1"},
+	}
+	for _, r := range registrations {
+		if err := rc.AddHostingServer(ctx, r.model, r.ip); err != nil {
+			t.Fatalf("AddHostingServer: %v", err)
+		}
+	}
+
+	if err := rc.RemoveHostFromAllModels(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("RemoveHostFromAllModels: %v", err)
+	}
+	if want := []models.HostingServer{{IPAdd: "10.0.0.2"}}; !reflect.DeepEqual(hostingServers(t, rc, llama.Name), want) {
+		t.Errorf("llama hosting servers = %+v, want %+v", hostingServers(t, rc, llama.Name), want)
+	}
+	if servers := hostingServers(t, rc, mistral.Name); servers != nil {
+		t.Errorf("mistral has no hosts left but is still registered with %+v", servers)
+	}
+	if names := modelNames(t, server); !reflect.DeepEqual(names, []string{llama.Name}) {
+		t.Errorf("model names = %v, want only %s", names, llama.Name)
+	}
+}
+
+func TestMigrateLLModelList(t *testing.T) {
+	ctx := context.Background()
+	rc, server := newTestRedis(t)
+
+	if migrated, err := rc.MigrateLLModelList(ctx); err != nil || migrated != 0 {
+		t.Fatalf("MigrateLLModelList without a legacy blob = %d, %v", migrated, err)
+	}
+
+	// A host registered since keeps its status over the legacy one
+	if err := rc.UpsertHostingServer(ctx, llama, "10.0.0.1", true); err != nil {
+		t.Fatalf("UpsertHostingServer: %v", err)
+	}
+	legacy := []models.LLModel{
+		{Modelinfo: llama, HostingServers: []models.HostingServer{{IPAdd: "10.0.0.1"}, {IPAdd: "10.0.0.2", Status: true}}},
+		{Modelinfo: mistral, HostingServers: []models.HostingServer{{IPAdd: "10.0.0.3"}}},
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Set(LLModelsKey, string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := rc.MigrateLLModelList(ctx)
+	if err != nil || migrated != 2 {
+		t.Fatalf("MigrateLLModelList = %d, %v, want 2 models", migrated, err)
+	}
+
+	all, err := rc.GetAllLLModels(ctx)
+	if err != nil {
+		t.Fatalf("GetAllLLModels: %v", err)
+	}
+	want := []models.LLModel{
+		{Modelinfo: llama, HostingServers: []models.HostingServer{{IPAdd: "10.0.0.1", Status: true}, {IPAdd: "10.0.0.2", Status: true}}},
+		{Modelinfo: mistral, HostingServers: []models.HostingServer{{IPAdd: "10.0.0.3"}}},
+	}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("models after migration = %+v, want %+v", all, want)
+	}
+
+	if server.Exists(LLModelsKey) || !server.Exists(LLModelsLegacyKey) {
+		t.Error("legacy blob was not renamed")
+	}
+	if migrated, err := rc.MigrateLLModelList(ctx); err != nil || migrated != 0 {
+		t.Errorf("second MigrateLLModelList = %d, %v, want nothing to migrate", migrated, err)
+	}
+}
+
+func TestRemovedModels(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current []models.HostModelInfo
+		want              []string
+	}{
+		{"first registration", nil, []models.HostModelInfo{llama, mistral}, nil},
+		{"unchanged", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{mistral, llama}, nil},
+		{"model pulled", []models.HostModelInfo{llama}, []models.HostModelInfo{llama, qwen}, nil},
+		{"model removed", []models.HostModelInfo{llama, mistral, qwen}, []models.HostModelInfo{mistral}, []string{"llama3:8b", "qwen2:1.5b"}},
+		{"model replaced", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{llama, qwen}, []string{"mistral:7b"}},
+		{"all removed", []models.HostModelInfo{llama, mistral}, nil, []string{"llama3:8b", "mistral:7b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := removedModels(tt.previous, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("removedModels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncLLMHostConcurrentEviction(t *testing.T) {
+	ctx := context.Background()
+	rc, _ := newTestRedis(t)
+
+	host := models.LLMHost{IPAdd: "10.0.0.1", Status: true, ModelInfo: []models.HostModelInfo{llama}}
+	host.HostInfo.IPAddress = host.IPAdd
+	if err := rc.SaveLLMHost(ctx, host); err != nil {
+		t.Fatalf("SaveLLMHost: %v", err)
+	}
+
+	// The host is evicted after update has read it, the transaction must be
+	// retried with the evicted host
+	calls := 0
+	change, err := rc.SyncLLMHost(ctx, host.IPAdd, func(stored *models.LLMHost) *models.LLMHost {
+		calls++
+		if calls == 1 {
+			evicted := *stored
+			evicted.Status = false
+			if err := rc.SaveLLMHost(ctx, evicted); err != nil {
+				t.Fatalf("SaveLLMHost: %v", err)
+			}
+		}
+		if !stored.Status {
+			return nil
+		}
+		stored.ModelInfo = []models.HostModelInfo{llama, mistral}
+		return stored
+	})
+	if err != nil {
+		t.Fatalf("SyncLLMHost: %v", err)
+	}
+	if calls != 2 || change.Saved {
+		t.Errorf("update called %d times, saved %v, want a retry that saves nothing", calls, change.Saved)
+	}
+	for _, name := range []string{llama.Name, mistral.Name} {
+		if servers := hostingServers(t, rc, name); servers != nil {
+			t.Errorf("evicted host registered for %s: %+v", name, servers)
+		}
+	}
+	stored, err := rc.GetLLMHost(ctx, host.IPAdd)
+	if err != nil || stored == nil || stored.Status {
+		t.Errorf("stored host = %+v, %v, want it offline", stored, err)
+	}
+}
@@ -0,0 +1,55 @@
+package clients
+
+import (
+	"context"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook wraps every Redis command, pipeline and transaction issued by
+// the RedisClient methods in a span
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = databinding.StartSpan(ctx, "redis "+cmd.Name(),
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation.name", cmd.Name()),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	databinding.EndSpan(trace.SpanFromContext(ctx), commandError(cmd))
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = databinding.StartSpan(ctx, "redis pipeline",
+		attribute.String("db.system", "redis"),
+		attribute.Int("db.operation.batch.size", len(cmds)),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = commandError(cmd); err != nil {
+			break
+		}
+	}
+	databinding.EndSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// commandError returns the error of a command, a missing key is not one
+func commandError(cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"node/clients"
+	"node/models"
+)
+
+// APIKeySecretPrefix starts every API key secret
+const APIKeySecretPrefix = "dg_"
+
+// rateWindow is the length of the fixed windows rate limits are counted in
+const rateWindow = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// Rate limits an API key can exceed
+const (
+	LimitRequests = "requests"
+	LimitTokens   = "tokens"
+)
+
+// RateLimitError is returned when an API key exceeded one of its limits
+type RateLimitError struct {
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s per minute limit exceeded", e.Limit)
+}
+
+// HashAPIKey returns the hash an API key secret is stored under
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey generates and stores a new API key. The secret is returned
+// once and cannot be recovered later.
+func CreateAPIKey(ctx context.Context, key models.APIKey, redis *clients.RedisClient) (string, *models.APIKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	secret = APIKeySecretPrefix + secret
+
+	key.ID = id
+	key.Prefix = secret[:len(APIKeySecretPrefix)+6]
+	key.CreatedAt = time.Now().Unix()
+	key.RevokedAt = 0
+
+	if err := redis.CreateAPIKey(ctx, key, HashAPIKey(secret)); err != nil {
+		return "", nil, err
+	}
+	return secret, &key, nil
+}
+
+// AuthenticateAPIKey returns the valid API key a secret belongs to
+func AuthenticateAPIKey(ctx context.Context, secret string, redis *clients.RedisClient) (*models.APIKey, error) {
+	key, err := redis.GetAPIKeyByHash(ctx, HashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Revoked() {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+// AdmitRequest counts a request against the limits of an API key. It returns
+// a RateLimitError when the key already used up its requests or tokens for
+// the current minute. Tokens are only known once a chat completes, so a chat
+// is admitted as long as the token budget is not yet exhausted.
+func AdmitRequest(ctx context.Context, key *models.APIKey, redis *clients.RedisClient) error {
+	return admitRequest(ctx, key, redis, time.Now())
+}
+
+// admitRequest is AdmitRequest at the time now
+func admitRequest(ctx context.Context, key *models.APIKey, redis *clients.RedisClient, now time.Time) error {
+	if key.TokensPerMinute > 0 {
+		tokens, err := redis.GetRateWindow(ctx, rateWindowKey(key.ID, LimitTokens, now))
+		if err != nil {
+			return err
+		}
+		if tokens >= int64(key.TokensPerMinute) {
+			return &RateLimitError{Limit: LimitTokens, RetryAfter: untilNextWindow(now)}
+		}
+	}
+
+	if key.RequestsPerMinute > 0 {
+		requests, err := redis.IncrementRateWindow(ctx, rateWindowKey(key.ID, LimitRequests, now), 1, 2*rateWindow)
+		if err != nil {
+			return err
+		}
+		if requests > int64(key.RequestsPerMinute) {
+			return &RateLimitError{Limit: LimitRequests, RetryAfter: untilNextWindow(now)}
+		}
+	}
+
+	return nil
+}
+
+// ConsumeTokens counts the tokens of a completed chat against the token
+// limit of an API key. Failures are logged, the chat has already been served.
+func ConsumeTokens(ctx context.Context, key *models.APIKey, tokens int, redis *clients.RedisClient, logger *slog.Logger) {
+	if key.TokensPerMinute == 0 || tokens == 0 {
+		return
+	}
+
+	// The request context may already be cancelled once the stream has ended
+	windowKey := rateWindowKey(key.ID, LimitTokens, time.Now())
+	if _, err := redis.IncrementRateWindow(context.WithoutCancel(ctx), windowKey, int64(tokens), 2*rateWindow); err != nil {
+		logger.ErrorContext(ctx, "Failed to count tokens against API key", "api_key", key.ID, "error", err)
+	}
+}
+
+// rateWindowKey names the counter of a limit in the window containing now
+func rateWindowKey(keyID, limit string, now time.Time) string {
+	window := now.Truncate(rateWindow).Unix()
+	return keyID + ":" + limit + ":" + strconv.FormatInt(window, 10)
+}
+
+// untilNextWindow returns how long until the rate window containing now ends
+func untilNextWindow(now time.Time) time.Duration {
+	return now.Truncate(rateWindow).Add(rateWindow).Sub(now)
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	buffer := make([]byte, n)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %v", err)
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,144 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"node/models"
+)
+
+// windowStart is the start of a rate window, in the middle of a test run
+var windowStart = time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+
+// checkAdmit fails the test unless admitRequest returns a RateLimitError for
+// wantLimit, or admits the request when wantLimit is empty
+func checkAdmit(t *testing.T, err error, wantLimit string, wantRetry time.Duration) {
+	t.Helper()
+	if wantLimit == "" {
+		if err != nil {
+			t.Fatalf("request rejected: %v", err)
+		}
+		return
+	}
+
+	var limitErr *RateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want a %s RateLimitError", err, wantLimit)
+	}
+	if limitErr.Limit != wantLimit || limitErr.RetryAfter != wantRetry {
+		t.Errorf("got %s limit retrying after %v, want %s after %v", limitErr.Limit, limitErr.RetryAfter, wantLimit, wantRetry)
+	}
+}
+
+func TestAdmitRequestUnlimited(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	key := &models.APIKey{ID: "k1"}
+
+	for i := 0; i < 100; i++ {
+		checkAdmit(t, admitRequest(ctx, key, rc, windowStart), "", 0)
+	}
+}
+
+func TestAdmitRequestRequestsPerMinute(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	key := &models.APIKey{ID: "k1", RequestsPerMinute: 2}
+	other := &models.APIKey{ID: "k2", RequestsPerMinute: 2}
+
+	now := windowStart.Add(15 * time.Second)
+	checkAdmit(t, admitRequest(ctx, key, rc, now), "", 0)
+	checkAdmit(t, admitRequest(ctx, key, rc, now.Add(time.Second)), "", 0)
+	checkAdmit(t, admitRequest(ctx, key, rc, now.Add(5*time.Second)), LimitRequests, 40*time.Second)
+
+	// Keys are limited separately
+	checkAdmit(t, admitRequest(ctx, other, rc, now), "", 0)
+
+	// The last second of the window is still limited, the next window is not
+	checkAdmit(t, admitRequest(ctx, key, rc, windowStart.Add(rateWindow-time.Second)), LimitRequests, time.Second)
+	checkAdmit(t, admitRequest(ctx, key, rc, windowStart.Add(rateWindow)), "", 0)
+}
+
+func TestAdmitRequestTokensPerMinute(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	key := &models.APIKey{ID: "k1", TokensPerMinute: 100}
+	now := windowStart.Add(10 * time.Second)
+	tokensKey := rateWindowKey(key.ID, LimitTokens, now)
+
+	// Chats are admitted while the budget is not used up, whatever they cost
+	if _, err := rc.IncrementRateWindow(ctx, tokensKey, 99, 2*rateWindow); err != nil {
+		t.Fatal(err)
+	}
+	checkAdmit(t, admitRequest(ctx, key, rc, now), "", 0)
+
+	if _, err := rc.IncrementRateWindow(ctx, tokensKey, 1, 2*rateWindow); err != nil {
+		t.Fatal(err)
+	}
+	checkAdmit(t, admitRequest(ctx, key, rc, now), LimitTokens, 50*time.Second)
+	checkAdmit(t, admitRequest(ctx, key, rc, windowStart.Add(rateWindow)), "", 0)
+}
+
+func TestAdmitRequestTokensBeforeRequests(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	key := &models.APIKey{ID: "k1", RequestsPerMinute: 1, TokensPerMinute: 10}
+	if _, err := rc.IncrementRateWindow(ctx, rateWindowKey(key.ID, LimitTokens, windowStart), 10, 2*rateWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request refused for its tokens does not use up a request
+	checkAdmit(t, admitRequest(ctx, key, rc, windowStart), LimitTokens, rateWindow)
+	requests, err := rc.GetRateWindow(ctx, rateWindowKey(key.ID, LimitRequests, windowStart))
+	if err != nil || requests != 0 {
+		t.Errorf("requests counted = %d, %v, want 0", requests, err)
+	}
+}
+
+func TestConsumeTokens(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+
+	unlimited := &models.APIKey{ID: "k1"}
+	ConsumeTokens(ctx, unlimited, 50, rc, discardLogger)
+	limited := &models.APIKey{ID: "k2", TokensPerMinute: 100}
+	ConsumeTokens(ctx, limited, 30, rc, discardLogger)
+	ConsumeTokens(ctx, limited, 20, rc, discardLogger)
+
+	now := time.Now()
+	if tokens, _ := rc.GetRateWindow(ctx, rateWindowKey(unlimited.ID, LimitTokens, now)); tokens != 0 {
+		t.Errorf("tokens counted for an unlimited key: %d", tokens)
+	}
+	// The window may have just turned, the tokens are in one of the two
+	tokens, _ := rc.GetRateWindow(ctx, rateWindowKey(limited.ID, LimitTokens, now))
+	previous, _ := rc.GetRateWindow(ctx, rateWindowKey(limited.ID, LimitTokens, now.Add(-rateWindow)))
+	if tokens+previous != 50 {
+		t.Errorf("tokens counted = %d, want 50", tokens+previous)
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+
+	secret, created, err := CreateAPIKey(ctx, models.APIKey{Name: "ci", AllowedModels: []string{"llama3:8b"}}, rc)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	key, err := AuthenticateAPIKey(ctx, secret, rc)
+	if err != nil || key.ID != created.ID {
+		t.Fatalf("AuthenticateAPIKey = %+v, %v, want key %s", key, err, created.ID)
+	}
+
+	if _, err := AuthenticateAPIKey(ctx, secret+"x", rc); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("wrong secret: %v, want ErrInvalidAPIKey", err)
+	}
+	if _, err := rc.RevokeAPIKey(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, secret, rc); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("revoked key: %v, want ErrInvalidAPIKey", err)
+	}
+}
@@ -0,0 +1,48 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+
+	"node/clients"
+	"node/models"
+)
+
+// Auditor writes the audit records of chats and model loads
+type Auditor struct {
+	mongo  *clients.MongoClient
+	logger *slog.Logger
+
+	// Bodies keeps prompts and responses in the records, otherwise only the
+	// metadata of a request is stored
+	Bodies bool
+}
+
+func NewAuditor(mongo *clients.MongoClient, logger *slog.Logger, bodies bool) *Auditor {
+	return &Auditor{
+		mongo:  mongo,
+		logger: logger,
+		Bodies: bodies,
+	}
+}
+
+// Record stores an audit record, dropping its bodies unless they are audited.
+// Failures are logged, the request has already been served.
+func (a *Auditor) Record(ctx context.Context, record models.AuditRecord) {
+	id, err := randomHex(12)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "Failed to write audit record", "error", err)
+		return
+	}
+	record.ID = id
+
+	if !a.Bodies {
+		record.Prompt = nil
+		record.Response = ""
+	}
+
+	// The request context may already be cancelled once the stream has ended
+	if err := a.mongo.InsertAuditRecord(context.WithoutCancel(ctx), record); err != nil {
+		a.logger.ErrorContext(ctx, "Failed to write audit record", "route", record.Route, "error", err)
+	}
+}
@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// BeaconBroadcaster periodically sends the node's signed beacon so that hosts
+// find it without scanning
+type BeaconBroadcaster struct {
+	sender   *databinding.BeaconSender
+	beacon   databinding.Beacon
+	secret   string
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+// NewBeaconBroadcaster creates a broadcaster of beacon. A beacon without an
+// address points hosts at the address it was sent from.
+func NewBeaconBroadcaster(sender *databinding.BeaconSender, beacon databinding.Beacon, secret string, interval time.Duration, logger *slog.Logger) *BeaconBroadcaster {
+	return &BeaconBroadcaster{
+		sender:   sender,
+		beacon:   beacon,
+		secret:   secret,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Start sends beacons in the background until ctx is cancelled, then closes
+// the sender
+func (b *BeaconBroadcaster) Start(ctx context.Context) {
+	go func() {
+		defer b.sender.Close()
+
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := b.sender.Send(b.beacon, b.secret); err != nil {
+				b.logger.Warn("Failed to send beacon", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"node/clients"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// MaxConversationTitle is the length titles derived from a first message are cut to
+const MaxConversationTitle = 80
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// conversationRoles are the roles a stored message may have
+var conversationRoles = map[string]bool{
+	"system":    true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// ValidateMessages checks messages before they are stored in a conversation
+func ValidateMessages(messages []databinding.Message) error {
+	for i, message := range messages {
+		if !conversationRoles[message.Role] {
+			return fmt.Errorf("message %d has invalid role %q", i, message.Role)
+		}
+	}
+	return nil
+}
+
+// CreateConversation starts a conversation for an owner. Without a title the
+// first user message names it.
+func CreateConversation(ctx context.Context, owner, title, model string, messages []databinding.Message, mongo *clients.MongoClient) (*models.Conversation, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, err
+	}
+
+	if title == "" {
+		title = defaultTitle(messages)
+	}
+
+	now := time.Now().Unix()
+	conversation := models.Conversation{
+		ID:        id,
+		Owner:     owner,
+		Title:     title,
+		Model:     model,
+		Messages:  conversationMessages(messages, now),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := mongo.CreateConversation(ctx, conversation); err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// AppendToConversation adds messages to an owner's conversation
+func AppendToConversation(ctx context.Context, owner, id string, messages []databinding.Message, mongo *clients.MongoClient) (*models.Conversation, error) {
+	now := time.Now().Unix()
+	conversation, err := mongo.AppendMessages(ctx, owner, id, "", conversationMessages(messages, now), now)
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, ErrConversationNotFound
+	}
+	return conversation, nil
+}
+
+// ContinueConversation prepends the stored history of an owner's
+// conversation to a chat. It returns the chat's own messages, which are the
+// new turn to store once the reply is complete.
+func ContinueConversation(ctx context.Context, owner string, chat *databinding.ChatCompletion, mongo *clients.MongoClient) ([]databinding.Message, error) {
+	conversation, err := mongo.GetConversation(ctx, owner, chat.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, ErrConversationNotFound
+	}
+	return prependHistory(chat, conversation), nil
+}
+
+// prependHistory puts the stored messages of a conversation ahead of a chat's
+// own messages, which it returns
+func prependHistory(chat *databinding.ChatCompletion, conversation *models.Conversation) []databinding.Message {
+	turn := chat.Messages
+	history := make([]databinding.Message, 0, len(conversation.Messages)+len(turn))
+	for _, message := range conversation.Messages {
+		history = append(history, databinding.Message{Role: message.Role, Content: message.Content})
+	}
+	chat.Messages = append(history, turn...)
+	return turn
+}
+
+// SaveConversationTurn stores the messages of a completed chat and the
+// model's reply. Failures are logged, the chat has already been served.
+func SaveConversationTurn(ctx context.Context, owner, id, model string, turn []databinding.Message, reply string, mongo *clients.MongoClient, logger *slog.Logger) {
+	now := time.Now().Unix()
+	messages := conversationMessages(turn, now)
+	messages = append(messages, models.ConversationMessage{
+		Role:      "assistant",
+		Content:   reply,
+		Model:     model,
+		CreatedAt: now,
+	})
+
+	// The request context may already be cancelled once the stream has ended
+	conversation, err := mongo.AppendMessages(context.WithoutCancel(ctx), owner, id, model, messages, now)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to store conversation turn", "conversation", id, "error", err)
+		return
+	}
+	if conversation == nil {
+		logger.WarnContext(ctx, "Conversation was deleted during the chat", "conversation", id)
+	}
+}
+
+// conversationMessages converts chat messages into stored messages
+func conversationMessages(messages []databinding.Message, createdAt int64) []models.ConversationMessage {
+	stored := make([]models.ConversationMessage, len(messages))
+	for i, message := range messages {
+		stored[i] = models.ConversationMessage{
+			Role:      message.Role,
+			Content:   message.Content,
+			CreatedAt: createdAt,
+		}
+	}
+	return stored
+}
+
+// defaultTitle names a conversation after its first user message
+func defaultTitle(messages []databinding.Message) string {
+	for _, message := range messages {
+		if message.Role != "user" || message.Content == "" {
+			continue
+		}
+		title := []rune(message.Content)
+		if len(title) > MaxConversationTitle {
+			return string(title[:MaxConversationTitle]) + "…"
+		}
+		return string(title)
+	}
+	return "New conversation"
+}
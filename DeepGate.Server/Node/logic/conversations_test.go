@@ -0,0 +1,231 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"node/clients"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestValidateMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []databinding.Message
+		wantErr  string
+	}{
+		{name: "none", messages: nil},
+		{name: "every role", messages: []databinding.Message{
+			{Role: "system", Content: "Be brief"},
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello"},
+			{Role: "tool", Content: "{}"},
+		}},
+		{name: "empty content", messages: []databinding.Message{{Role: "user"}}},
+		{name: "unknown role", messages: []databinding.Message{{Role: "user", Content: "Hi"}, {Role: "bot", Content: "Hello"}}, wantErr: `message 1 has invalid role "bot"`},
+		{name: "missing role", messages: []databinding.Message{{Content: "Hi"}}, wantErr: `message 0 has invalid role ""`},
+		{name: "roles are case sensitive", messages: []databinding.Message{{Role: "User", Content: "Hi"}}, wantErr: `invalid role "User"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMessages(tt.messages)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrependHistory(t *testing.T) {
+	stored := []models.ConversationMessage{
+		{Role: "system", Content: "Be brief", CreatedAt: 1},
+		{Role: "user", Content: "Hi", CreatedAt: 1},
+		{Role: "assistant", Content: "Hello", Model: "llama3:8b", CreatedAt: 2},
+	}
+	history := []databinding.Message{
+		{Role: "system", Content: "Be brief"},
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello"},
+	}
+	question := []databinding.Message{{Role: "user", Content: "How are you?"}}
+
+	tests := []struct {
+		name         string
+		stored       []models.ConversationMessage
+		messages     []databinding.Message
+		wantMessages []databinding.Message
+	}{
+		{
+			name:         "history before the turn",
+			stored:       stored,
+			messages:     question,
+			wantMessages: append(append([]databinding.Message{}, history...), question...),
+		},
+		{
+			name:         "history only",
+			stored:       stored,
+			wantMessages: history,
+		},
+		{
+			name:         "new conversation",
+			messages:     question,
+			wantMessages: question,
+		},
+		{
+			name:         "nothing at all",
+			wantMessages: []databinding.Message{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := databinding.ChatCompletion{Model: "llama3:8b", ConversationID: "c1", Messages: tt.messages}
+			turn := prependHistory(&chat, &models.Conversation{ID: "c1", Messages: tt.stored})
+
+			if !reflect.DeepEqual(turn, tt.messages) {
+				t.Errorf("turn = %+v, want the chat's own messages %+v", turn, tt.messages)
+			}
+			if !reflect.DeepEqual(chat.Messages, tt.wantMessages) {
+				t.Errorf("messages = %+v, want %+v", chat.Messages, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestDefaultTitle(t *testing.T) {
+	long := strings.Repeat("é", MaxConversationTitle+5)
+
+	tests := []struct {
+		name     string
+		messages []databinding.Message
+		want     string
+	}{
+		{"first user message", []databinding.Message{{Role: "system", Content: "Be brief"}, {Role: "user", Content: "Plan a trip"}, {Role: "user", Content: "To Rome"}}, "Plan a trip"},
+		{"empty user message skipped", []databinding.Message{{Role: "user"}, {Role: "user", Content: "Hi"}}, "Hi"},
+		{"no user message", []databinding.Message{{Role: "system", Content: "Be brief"}}, "New conversation"},
+		{"cut by rune", []databinding.Message{{Role: "user", Content: long}}, strings.Repeat("é", MaxConversationTitle) + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultTitle(tt.messages); got != tt.want {
+				t.Errorf("title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// mockMongo runs test against a mocked MongoDB deployment, whose replies are
+// queued with AddMockResponses
+func mockMongo(t *testing.T, test func(mt *mtest.T, mongo *clients.MongoClient)) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("mongo", func(mt *mtest.T) {
+		test(mt, clients.NewMongoClient(mt.DB))
+	})
+}
+
+// commandDocument decodes a field of the next command sent to MongoDB
+func commandDocument(mt *mtest.T, command, field string) bson.M {
+	mt.Helper()
+	event := mt.GetStartedEvent()
+	if event == nil || event.CommandName != command {
+		mt.Fatalf("command = %+v, want %s", event, command)
+	}
+	var document bson.M
+	if err := event.Command.Lookup(field).Unmarshal(&document); err != nil {
+		mt.Fatalf("decoding %s of %s: %v", field, command, err)
+	}
+	return document
+}
+
+func TestContinueConversation(t *testing.T) {
+	conversationsNS := "test." + clients.ConversationsCollection
+
+	mockMongo(t, func(mt *mtest.T, mongo *clients.MongoClient) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, conversationsNS, mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "c1"},
+			{Key: "owner", Value: "key-1"},
+			{Key: "messages", Value: bson.A{bson.D{{Key: "role", Value: "user"}, {Key: "content", Value: "Hi"}}}},
+		}))
+
+		chat := databinding.ChatCompletion{Model: "llama3:8b", ConversationID: "c1", Messages: []databinding.Message{{Role: "user", Content: "Again"}}}
+		turn, err := ContinueConversation(context.Background(), "key-1", &chat, mongo)
+		if err != nil {
+			mt.Fatalf("ContinueConversation: %v", err)
+		}
+		if len(turn) != 1 || len(chat.Messages) != 2 || chat.Messages[0].Content != "Hi" {
+			mt.Errorf("turn = %+v, messages = %+v", turn, chat.Messages)
+		}
+
+		// The conversation is looked up within its owner's
+		if filter := commandDocument(mt, "find", "filter"); !reflect.DeepEqual(filter, bson.M{"_id": "c1", "owner": "key-1"}) {
+			mt.Errorf("filter = %v", filter)
+		}
+
+		// Another owner's conversation is not found
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, conversationsNS, mtest.FirstBatch))
+		chat = databinding.ChatCompletion{Model: "llama3:8b", ConversationID: "c1"}
+		if _, err := ContinueConversation(context.Background(), "key-2", &chat, mongo); !errors.Is(err, ErrConversationNotFound) {
+			mt.Errorf("other owner: %v, want ErrConversationNotFound", err)
+		}
+		if filter := commandDocument(mt, "find", "filter"); filter["owner"] != "key-2" {
+			mt.Errorf("filter = %v, want the owner key-2", filter)
+		}
+	})
+}
+
+func TestSaveConversationTurn(t *testing.T) {
+	mockMongo(t, func(mt *mtest.T, mongo *clients.MongoClient) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "c1"}}}))
+
+		// The turn is stored even when the request is already cancelled
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		turn := []databinding.Message{{Role: "user", Content: "Hi"}}
+		SaveConversationTurn(ctx, "key-1", "c1", "llama3:8b", turn, "Hello", mongo, discardLogger)
+
+		event := mt.GetStartedEvent()
+		if event == nil || event.CommandName != "findAndModify" {
+			mt.Fatalf("command = %+v, want findAndModify", event)
+		}
+		var command struct {
+			Query  bson.M `bson:"query"`
+			Update struct {
+				Set  bson.M `bson:"$set"`
+				Push struct {
+					Messages struct {
+						Each []models.ConversationMessage `bson:"$each"`
+					} `bson:"messages"`
+				} `bson:"$push"`
+			} `bson:"update"`
+		}
+		if err := bson.Unmarshal(event.Command, &command); err != nil {
+			mt.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(command.Query, bson.M{"_id": "c1", "owner": "key-1"}) {
+			mt.Errorf("query = %v", command.Query)
+		}
+		if command.Update.Set["model"] != "llama3:8b" {
+			mt.Errorf("$set = %v, want the chat's model", command.Update.Set)
+		}
+		messages := command.Update.Push.Messages.Each
+		if len(messages) != 2 ||
+			messages[0].Role != "user" || messages[0].Content != "Hi" || messages[0].Model != "" ||
+			messages[1].Role != "assistant" || messages[1].Content != "Hello" || messages[1].Model != "llama3:8b" {
+			mt.Errorf("pushed messages = %+v", messages)
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"node/clients"
+	"node/metrics"
+)
+
+const (
+	DefaultHostCooldown    = 30 * time.Second // How long a failing host is avoided
+	DefaultMaxChatAttempts = 3                // Hosts tried for a chat before giving up
+)
+
+// MarkHostUnhealthy puts a host in cooldown so the schedulers try it last
+func MarkHostUnhealthy(ctx context.Context, ipAddress string, cooldown time.Duration, redis *clients.RedisClient, logger *slog.Logger) {
+	metrics.HostFailed(ipAddress)
+
+	// The request that failed may already be cancelled
+	if err := redis.SetHostCooldown(context.WithoutCancel(ctx), ipAddress, cooldown); err != nil {
+		logger.ErrorContext(ctx, "Failed to mark host unhealthy", "host", ipAddress, "error", err)
+		return
+	}
+	logger.WarnContext(ctx, "Host marked unhealthy", "host", ipAddress, "cooldown", cooldown)
+}
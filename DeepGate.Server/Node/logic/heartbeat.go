@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"node/clients"
+	"node/metrics"
+	"node/models"
+)
+
+const (
+	DefaultHeartbeatInterval   = 10 * time.Second // Expected interval between host heartbeats
+	DefaultMaxMissedHeartbeats = 3                // Heartbeats a host may miss before eviction
+)
+
+// HeartbeatMonitor periodically evicts hosts that stopped sending heartbeats
+type HeartbeatMonitor struct {
+	redis  *clients.RedisClient
+	logger *slog.Logger
+
+	// Interval is how often hosts send heartbeats and the monitor checks them
+	Interval time.Duration
+	// MaxMissed is how many heartbeats a host may miss before it is evicted
+	MaxMissed int
+}
+
+func NewHeartbeatMonitor(redis *clients.RedisClient, logger *slog.Logger) *HeartbeatMonitor {
+	return &HeartbeatMonitor{
+		redis:     redis,
+		logger:    logger,
+		Interval:  DefaultHeartbeatInterval,
+		MaxMissed: DefaultMaxMissedHeartbeats,
+	}
+}
+
+// Start runs the monitor in the background until ctx is cancelled
+func (m *HeartbeatMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.checkHosts(ctx)
+			}
+		}
+	}()
+}
+
+// checkHosts evicts every online host whose last heartbeat is too old
+func (m *HeartbeatMonitor) checkHosts(ctx context.Context) {
+	hosts, err := m.redis.GetAllLLMHosts(ctx)
+	if err != nil {
+		m.logger.Error("Heartbeat check failed to fetch hosts", "error", err)
+		return
+	}
+
+	deadline := time.Now().Add(-time.Duration(m.MaxMissed) * m.Interval).Unix()
+	online := 0
+	for _, host := range hosts {
+		if !host.Status {
+			continue
+		}
+		if host.LastHeartbeat >= deadline {
+			online++
+			continue
+		}
+
+		m.logger.Warn("Host missed heartbeats, marking offline", "host", host.IPAdd, "missed", m.MaxMissed)
+		if err := EvictHost(ctx, host, m.redis, m.logger); err != nil {
+			m.logger.Error("Failed to evict host", "host", host.IPAdd, "error", err)
+			online++
+		}
+	}
+
+	// The check runs often enough to keep the registry metrics current
+	modelCount, err := m.redis.CountLLModels(ctx)
+	if err != nil {
+		m.logger.Error("Heartbeat check failed to count models", "error", err)
+		return
+	}
+	metrics.SetRegistrySize(modelCount, online, len(hosts)-online)
+}
+
+// EvictHost marks a host offline and removes it from every model's hosting
+// servers. The host is marked offline first so that a concurrent
+// SyncHostModels does not add it back.
+func EvictHost(ctx context.Context, host models.LLMHost, redis *clients.RedisClient, logger *slog.Logger) error {
+	host.Status = false
+	if err := redis.SaveLLMHost(ctx, host); err != nil {
+		return err
+	}
+
+	if err := redis.RemoveHostFromAllModels(ctx, host.IPAdd); err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "Host evicted from the registry", "host", host.IPAdd)
+	return nil
+}
+
+// DeregisterHost removes a host that is shutting down from the registry, so
+// that no new chats are scheduled to it. Streams already running on the host
+// are left to finish.
+func DeregisterHost(ctx context.Context, ipAddress string, redis *clients.RedisClient, logger *slog.Logger) error {
+	// The host record goes first, a concurrent SyncHostModels then no longer
+	// finds the host and leaves its models alone
+	if err := redis.RemoveLLMHostByIP(ctx, ipAddress); err != nil {
+		return fmt.Errorf("failed to remove host %s: %v", ipAddress, err)
+	}
+
+	if err := redis.RemoveHostFromAllModels(ctx, ipAddress); err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "Host deregistered", "host", ipAddress)
+	return nil
+}
+
+// RestoreHost marks a returning host online and adds it back to the hosting
+// servers of its models. Models the host reports as loaded are marked active.
+func RestoreHost(ctx context.Context, host models.LLMHost, loadedModels []string, redis *clients.RedisClient, logger *slog.Logger) error {
+	host.Status = true
+	if err := redis.SaveLLMHost(ctx, host); err != nil {
+		return err
+	}
+
+	loaded := make(map[string]bool, len(loadedModels))
+	for _, name := range loadedModels {
+		loaded[name] = true
+	}
+
+	for _, hostModel := range host.ModelInfo {
+		if err := redis.UpsertHostingServer(ctx, hostModel, host.IPAdd, loaded[hostModel.Name]); err != nil {
+			return err
+		}
+	}
+
+	logger.InfoContext(ctx, "Host is back online", "host", host.IPAdd)
+	return nil
+}
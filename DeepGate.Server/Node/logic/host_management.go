@@ -2,37 +2,58 @@ package logic
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"sync"
 
 	"node/clients"
 	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrModelNotFound     = errors.New("model not found")
+	ErrNoAvailableHosts  = errors.New("no available hosts found")
+	ErrNoInactiveServers = errors.New("no inactive servers available")
 )
 
 // GetBestHost finds the best available host for a given model
-func GetBestHost(modelName string, redis *clients.RedisClient, logger *log.Logger) (*models.LLMHost, error) {
-	logger.Printf("Fetching best host for model: %s", modelName)
+func GetBestHost(ctx context.Context, request ScheduleRequest, redis *clients.RedisClient, schedulers *SchedulerSet, logger *slog.Logger) (bestHost *models.LLMHost, err error) {
+	ctx, span := databinding.StartSpan(ctx, "GetBestHost", attribute.String("deepgate.model", request.Model))
+	defer func() { databinding.EndSpan(span, err) }()
 
-	// Get all models from Redis
-	allModels, err := redis.GetAllLLModels(context.Background())
+	candidates, err := GetCandidateHosts(ctx, request, redis, schedulers, logger)
 	if err != nil {
-		logger.Printf("Error fetching models from Redis: %v", err)
 		return nil, err
 	}
 
-	// Find the requested model
-	var selectedModel *models.LLModel
-	for _, model := range allModels {
-		if model.Modelinfo.Name == modelName {
-			selectedModel = &model
-			break
-		}
+	bestHost = candidates[0]
+	span.SetAttributes(attribute.String("deepgate.host", bestHost.IPAdd))
+	logger.DebugContext(ctx, "Selected best host", "host", bestHost.IPAdd, "task_count", bestHost.TaskCount)
+	return bestHost, nil
+}
+
+// GetCandidateHosts returns every active host for a model, ordered best first
+// by the scheduler configured for that model
+func GetCandidateHosts(ctx context.Context, request ScheduleRequest, redis *clients.RedisClient, schedulers *SchedulerSet, logger *slog.Logger) (candidates []*models.LLMHost, err error) {
+	ctx, span := databinding.StartSpan(ctx, "GetCandidateHosts", attribute.String("deepgate.model", request.Model))
+	defer func() { databinding.EndSpan(span, err) }()
+
+	logger.DebugContext(ctx, "Fetching candidate hosts", "model", request.Model)
+
+	// Get the requested model from Redis
+	selectedModel, err := redis.GetLLModel(ctx, request.Model)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error fetching model from Redis", "model", request.Model, "error", err)
+		return nil, err
 	}
 
 	if selectedModel == nil {
-		logger.Printf("Model not found: %s", modelName)
-		return nil, fmt.Errorf("model not found")
+		logger.InfoContext(ctx, "Model not found", "model", request.Model)
+		return nil, ErrModelNotFound
 	}
 
 	// Filter active hosts
@@ -43,109 +64,122 @@ func GetBestHost(modelName string, redis *clients.RedisClient, logger *log.Logge
 		}
 	}
 
-	logger.Printf("Active hosts found: %v", activeHosts)
+	logger.DebugContext(ctx, "Active hosts found", "model", request.Model, "hosts", activeHosts)
 
-	// Case 1: If there's only one active host, return it immediately
-	if len(activeHosts) == 1 {
-		host, err := redis.GetLLMHost(context.Background(), activeHosts[0])
-		if err != nil {
-			logger.Printf("Error fetching host details for %s: %v", activeHosts[0], err)
-			return nil, err
-		}
-		logger.Printf("Returning single active host: %s", host.IPAdd)
-		return host, nil
+	if len(activeHosts) == 0 {
+		logger.InfoContext(ctx, "No available hosts found", "model", request.Model)
+		return nil, ErrNoAvailableHosts
 	}
 
-	// Case 2: Multiple active hosts, find the one with the lowest task count
-	if len(activeHosts) > 1 {
-		logger.Println("Fetching details of multiple active hosts...")
-
-		var wg sync.WaitGroup
-		hostChan := make(chan *models.LLMHost, len(activeHosts))
-		errChan := make(chan error, len(activeHosts))
-
-		for _, ip := range activeHosts {
-			wg.Add(1)
-			go func(ip string) {
-				defer wg.Done()
-				host, err := redis.GetLLMHost(context.Background(), ip)
-				if err != nil {
-					logger.Printf("Failed to fetch host %s: %v", ip, err)
-					errChan <- err
-					return
-				}
+	taskCounts, err := redis.GetHostTaskCounts(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error fetching task counts", "error", err)
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	hostChan := make(chan *models.LLMHost, len(activeHosts))
+
+	for _, ip := range activeHosts {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			host, err := redis.GetLLMHost(ctx, ip)
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to fetch host", "host", ip, "error", err)
+				return
+			}
+			// Skip hosts that expired or went offline
+			if host != nil && host.Status {
+				host.TaskCount = taskCounts[host.IPAdd]
 				hostChan <- host
-			}(ip)
-		}
+			}
+		}(ip)
+	}
 
-		wg.Wait()
-		close(hostChan)
-		close(errChan)
+	wg.Wait()
+	close(hostChan)
 
-		// Pick the host with the lowest task count
-		var bestHost *models.LLMHost
-		minTaskCount := int(^uint(0) >> 1) // Max int value
+	hosts := make([]*models.LLMHost, 0, len(activeHosts))
+	for host := range hostChan {
+		hosts = append(hosts, host)
+	}
 
-		for host := range hostChan {
-			logger.Printf("Host %s has task count: %d", host.IPAdd, host.TaskCount)
-			if host.TaskCount < minTaskCount {
-				bestHost = host
-				minTaskCount = host.TaskCount
-			}
-		}
+	if len(hosts) == 0 {
+		logger.InfoContext(ctx, "No available hosts found", "model", request.Model)
+		return nil, ErrNoAvailableHosts
+	}
+
+	cooling, err := redis.GetHostsInCooldown(ctx, activeHosts)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error fetching host cooldowns", "error", err)
+		return nil, err
+	}
 
-		if bestHost != nil {
-			logger.Printf("Selected best host: %s with task count: %d", bestHost.IPAdd, bestHost.TaskCount)
-			return bestHost, nil
+	// Hosts that failed recently are only tried after the healthy ones
+	healthy := make([]*models.LLMHost, 0, len(hosts))
+	unhealthy := make([]*models.LLMHost, 0)
+	for _, host := range hosts {
+		if cooling[host.IPAdd] {
+			unhealthy = append(unhealthy, host)
+		} else {
+			healthy = append(healthy, host)
 		}
 	}
 
-	logger.Println("No available hosts found")
-	return nil, fmt.Errorf("no available hosts found")
+	scheduler := schedulers.For(request.Model)
+	candidates = scheduler.Order(request, healthy)
+	if len(unhealthy) > 0 {
+		logger.InfoContext(ctx, "Hosts are cooling down after failures", "model", request.Model, "count", len(unhealthy))
+		candidates = append(candidates, scheduler.Order(request, unhealthy)...)
+	}
+	logger.DebugContext(ctx, "Scheduler ordered candidate hosts", "scheduler", scheduler.Name(), "model", request.Model, "count", len(candidates))
+	span.SetAttributes(
+		attribute.String("deepgate.scheduler", scheduler.Name()),
+		attribute.Int("deepgate.candidates", len(candidates)),
+	)
+
+	return candidates, nil
 }
 
 // GetServerToLoad finds an inactive hosting server for a given model
-func GetServerToLoad(modelName string, redis *clients.RedisClient, logger *log.Logger) (*models.LLMHost, *models.LLModel, error) {
-	logger.Printf("Finding inactive server for model: %s", modelName)
+func GetServerToLoad(ctx context.Context, modelName string, redis *clients.RedisClient, logger *slog.Logger) (*models.LLMHost, *models.LLModel, error) {
+	logger.DebugContext(ctx, "Finding inactive server", "model", modelName)
 
-	// Get all models from Redis
-	allModels, err := redis.GetAllLLModels(context.Background())
+	// Get the requested model from Redis
+	selectedModel, err := redis.GetLLModel(ctx, modelName)
 	if err != nil {
-		logger.Printf("Error fetching models from Redis: %v", err)
+		logger.ErrorContext(ctx, "Error fetching model from Redis", "model", modelName, "error", err)
 		return nil, nil, err
 	}
 
-	// Find the requested model
-	var selectedModel *models.LLModel
-	for _, model := range allModels {
-		if model.Modelinfo.Name == modelName {
-			selectedModel = &model
-			break
-		}
-	}
-
 	if selectedModel == nil {
-		logger.Printf("Model not found: %s", modelName)
-		return nil, nil, fmt.Errorf("model not found")
+		logger.InfoContext(ctx, "Model not found", "model", modelName)
+		return nil, nil, ErrModelNotFound
 	}
 
 	// Find the first inactive server
 	for _, hostingServer := range selectedModel.HostingServers {
 		if !hostingServer.Status { // Inactive server found
-			logger.Printf("Inactive server found: %s", hostingServer.IPAdd)
+			logger.DebugContext(ctx, "Inactive server found", "model", modelName, "host", hostingServer.IPAdd)
 
 			// Retrieve server details from Redis
-			host, err := redis.GetLLMHost(context.Background(), hostingServer.IPAdd)
+			host, err := redis.GetLLMHost(ctx, hostingServer.IPAdd)
 			if err != nil {
-				logger.Printf("Error fetching inactive host details (%s): %v", hostingServer.IPAdd, err)
+				logger.ErrorContext(ctx, "Error fetching inactive host details", "host", hostingServer.IPAdd, "error", err)
 				return nil, nil, err
 			}
 
-			logger.Printf("Returning inactive host: %s", host.IPAdd)
+			// Skip hosts whose registration expired
+			if host == nil {
+				continue
+			}
+
+			logger.InfoContext(ctx, "Returning inactive host", "model", modelName, "host", host.IPAdd)
 			return host, selectedModel, nil
 		}
 	}
 
-	logger.Println("No inactive servers found")
-	return nil, nil, fmt.Errorf("no inactive servers available")
+	logger.InfoContext(ctx, "No inactive servers found", "model", modelName)
+	return nil, nil, ErrNoInactiveServers
 }
@@ -0,0 +1,140 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"node/clients"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// InventoryRefresher periodically pulls the model list of every online host,
+// so that models pulled or removed with Ollama show up in the registry
+type InventoryRefresher struct {
+	redis    *clients.RedisClient
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+func NewInventoryRefresher(redis *clients.RedisClient, logger *slog.Logger, interval time.Duration) *InventoryRefresher {
+	return &InventoryRefresher{
+		redis:    redis,
+		logger:   logger,
+		interval: interval,
+	}
+}
+
+// Start runs the refresher in the background until ctx is cancelled
+func (r *InventoryRefresher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.refreshHosts(ctx)
+			}
+		}
+	}()
+}
+
+// refreshHosts syncs the models of every online host
+func (r *InventoryRefresher) refreshHosts(ctx context.Context) {
+	hosts, err := r.redis.GetAllLLMHosts(ctx)
+	if err != nil {
+		r.logger.Error("Inventory refresh failed to fetch hosts", "error", err)
+		return
+	}
+
+	for _, host := range hosts {
+		if !host.Status {
+			continue
+		}
+		if err := r.refreshHost(ctx, host); err != nil {
+			r.logger.Warn("Failed to refresh host models", "host", host.IPAdd, "error", err)
+		}
+	}
+}
+
+// refreshHost fetches the models of a host and syncs the registry when they
+// changed
+func (r *InventoryRefresher) refreshHost(ctx context.Context, host models.LLMHost) error {
+	current, err := FetchHostModels(ctx, host.HostInfo, host.Token)
+	if err != nil {
+		return err
+	}
+	if sameModels(host.ModelInfo, current) {
+		return nil
+	}
+
+	// The host may have been evicted or deregistered during the fetch, it
+	// must not be added back to the models then
+	return SyncHostModels(ctx, host.IPAdd, func(stored *models.LLMHost) *models.LLMHost {
+		if stored == nil || !stored.Status {
+			return nil
+		}
+		stored.ModelInfo = current
+		return stored
+	}, r.redis, r.logger)
+}
+
+// FetchHostModels asks a host for the models it has pulled
+func FetchHostModels(ctx context.Context, hostInfo databinding.InfoPackage, hostToken string) ([]models.HostModelInfo, error) {
+	apiClient := clients.MakeTemporaryAPIClient(hostInfo, hostToken)
+
+	resp, err := apiClient.MakeRequest(ctx, "GET", "/host/fetch-models", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch model list: %v", err)
+	}
+
+	modelResponse, err := models.ParseModelResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return models.ConvertModelsToHostInfo(modelResponse.Models), nil
+}
+
+// SyncHostModels saves the host returned by update and registers it for its
+// models, removing it from the ones it no longer has. Models left without
+// hosts are dropped from the registry. Every current model is registered
+// again, a host returning after eviction was removed from all of them. Reading
+// the stored host and the update happen in one transaction, see
+// clients.RedisClient.SyncLLMHost.
+func SyncHostModels(ctx context.Context, ipAddress string, update func(stored *models.LLMHost) *models.LLMHost, redis *clients.RedisClient, logger *slog.Logger) error {
+	change, err := redis.SyncLLMHost(ctx, ipAddress, update)
+	if err != nil {
+		return err
+	}
+
+	for _, modelName := range change.Removed {
+		logger.InfoContext(ctx, "Model removed from host", "model", modelName, "host", ipAddress)
+	}
+	for _, modelName := range change.Dropped {
+		logger.InfoContext(ctx, "Model has no hosts left, removed from the registry", "model", modelName)
+	}
+	return nil
+}
+
+// sameModels reports whether two model lists hold the same models, in any order
+func sameModels(a, b []models.HostModelInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byName := make(map[string]models.HostModelInfo, len(a))
+	for _, hostModel := range a {
+		byName[hostModel.Name] = hostModel
+	}
+	for _, hostModel := range b {
+		if other, ok := byName[hostModel.Name]; !ok || other != hostModel {
+			return false
+		}
+	}
+	return true
+}
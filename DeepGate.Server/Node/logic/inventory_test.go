@@ -0,0 +1,212 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"node/clients"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	llama   = models.HostModelInfo{Name: "llama3:8b", ParameterSize: "8B", Family: "llama", Size: 4_700_000_000}
+	mistral = models.HostModelInfo{Name: "mistral:7b", ParameterSize: "7B", Family: "llama", Size: 4_100_000_000}
+	qwen    = models.HostModelInfo{Name: "qwen2:1.5b", ParameterSize: "1.5B", Family: "qwen2", Size: 930_000_000}
+)
+
+func TestSameModels(t *testing.T) {
+	repulled := llama
+	repulled.Size++
+
+	tests := []struct {
+		name string
+		a, b []models.HostModelInfo
+		want bool
+	}{
+		{"both empty", nil, []models.HostModelInfo{}, true},
+		{"same order", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{llama, mistral}, true},
+		{"any order", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{mistral, llama}, true},
+		{"model added", []models.HostModelInfo{llama}, []models.HostModelInfo{llama, mistral}, false},
+		{"model removed", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{llama}, false},
+		{"model replaced", []models.HostModelInfo{llama, mistral}, []models.HostModelInfo{llama, qwen}, false},
+		{"model changed", []models.HostModelInfo{llama}, []models.HostModelInfo{repulled}, false},
+		{"all removed", []models.HostModelInfo{llama}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameModels(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameModels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newTestRedis returns a client of a fresh in-memory Redis
+func newTestRedis(t *testing.T) *clients.RedisClient {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return clients.NewRedisClient(client, time.Minute)
+}
+
+// registeredModels returns the registered models with the IPs of their hosts
+func registeredModels(t *testing.T, rc *clients.RedisClient) map[string][]string {
+	t.Helper()
+	all, err := rc.GetAllLLModels(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllLLModels: %v", err)
+	}
+	registry := make(map[string][]string, len(all))
+	for _, model := range all {
+		ips := []string{}
+		for _, server := range model.HostingServers {
+			ips = append(ips, server.IPAdd)
+		}
+		registry[model.Modelinfo.Name] = ips
+	}
+	return registry
+}
+
+// fakeHost serves the model list of a host, calling onFetch before answering
+func fakeHost(t *testing.T, hostModels []models.HostModelInfo, onFetch func()) databinding.InfoPackage {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/host/fetch-models" {
+			http.NotFound(w, r)
+			return
+		}
+		if onFetch != nil {
+			onFetch()
+		}
+		var response models.HostModelInfoResponse
+		for _, hostModel := range hostModels {
+			response.Models = append(response.Models, models.Model{
+				Name:    hostModel.Name,
+				Size:    hostModel.Size,
+				Details: models.ModelDetails{Family: hostModel.Family, ParameterSize: hostModel.ParameterSize},
+			})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return databinding.InfoPackage{IPAddress: host, HostPort: port}
+}
+
+// keepOnline registers host as given, the way /ping does
+func keepOnline(host models.LLMHost) func(*models.LLMHost) *models.LLMHost {
+	return func(*models.LLMHost) *models.LLMHost { return &host }
+}
+
+func TestSyncHostModels(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+
+	first := models.LLMHost{IPAdd: "10.0.0.1", HostInfo: databinding.InfoPackage{IPAddress: "10.0.0.1"}, Status: true, ModelInfo: []models.HostModelInfo{llama, mistral}}
+	second := models.LLMHost{IPAdd: "10.0.0.2", HostInfo: databinding.InfoPackage{IPAddress: "10.0.0.2"}, Status: true, ModelInfo: []models.HostModelInfo{llama}}
+	for _, host := range []models.LLMHost{first, second} {
+		if err := SyncHostModels(ctx, host.IPAdd, keepOnline(host), rc, discardLogger); err != nil {
+			t.Fatalf("SyncHostModels: %v", err)
+		}
+	}
+	want := map[string][]string{
+		llama.Name:   {"10.0.0.1", "10.0.0.2"},
+		mistral.Name: {"10.0.0.1"},
+	}
+	if got := registeredModels(t, rc); !reflect.DeepEqual(got, want) {
+		t.Fatalf("registry = %v, want %v", got, want)
+	}
+
+	// mistral loses its only host and is dropped, llama keeps the other one
+	first.ModelInfo = []models.HostModelInfo{qwen}
+	if err := SyncHostModels(ctx, first.IPAdd, keepOnline(first), rc, discardLogger); err != nil {
+		t.Fatalf("SyncHostModels: %v", err)
+	}
+	want = map[string][]string{
+		llama.Name: {"10.0.0.2"},
+		qwen.Name:  {"10.0.0.1"},
+	}
+	if got := registeredModels(t, rc); !reflect.DeepEqual(got, want) {
+		t.Errorf("registry = %v, want %v", got, want)
+	}
+
+	stored, err := rc.GetLLMHost(ctx, first.IPAdd)
+	if err != nil || stored == nil {
+		t.Fatalf("GetLLMHost: %v, %v", stored, err)
+	}
+	if !reflect.DeepEqual(stored.ModelInfo, first.ModelInfo) {
+		t.Errorf("stored models = %v, want %v", stored.ModelInfo, first.ModelInfo)
+	}
+}
+
+func TestRefreshHost(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	refresher := NewInventoryRefresher(rc, discardLogger, time.Minute)
+
+	hostInfo := fakeHost(t, []models.HostModelInfo{llama}, nil)
+	host := models.LLMHost{IPAdd: hostInfo.IPAddress, HostInfo: hostInfo, Status: true, ModelInfo: []models.HostModelInfo{llama, mistral}}
+	if err := SyncHostModels(ctx, host.IPAdd, keepOnline(host), rc, discardLogger); err != nil {
+		t.Fatalf("SyncHostModels: %v", err)
+	}
+
+	if err := refresher.refreshHost(ctx, host); err != nil {
+		t.Fatalf("refreshHost: %v", err)
+	}
+	want := map[string][]string{llama.Name: {host.IPAdd}}
+	if got := registeredModels(t, rc); !reflect.DeepEqual(got, want) {
+		t.Errorf("registry = %v, want mistral dropped: %v", got, want)
+	}
+}
+
+func TestRefreshHostEvictedDuringFetch(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	refresher := NewInventoryRefresher(rc, discardLogger, time.Minute)
+
+	var host models.LLMHost
+	hostInfo := fakeHost(t, []models.HostModelInfo{llama, qwen}, func() {
+		if err := EvictHost(ctx, host, rc, discardLogger); err != nil {
+			t.Errorf("EvictHost: %v", err)
+		}
+	})
+	host = models.LLMHost{IPAdd: hostInfo.IPAddress, HostInfo: hostInfo, Status: true, ModelInfo: []models.HostModelInfo{llama}}
+	if err := SyncHostModels(ctx, host.IPAdd, keepOnline(host), rc, discardLogger); err != nil {
+		t.Fatalf("SyncHostModels: %v", err)
+	}
+
+	if err := refresher.refreshHost(ctx, host); err != nil {
+		t.Fatalf("refreshHost: %v", err)
+	}
+	if got := registeredModels(t, rc); len(got) != 0 {
+		t.Errorf("evicted host was added back to %v", got)
+	}
+	stored, err := rc.GetLLMHost(ctx, host.IPAdd)
+	if err != nil || stored == nil {
+		t.Fatalf("GetLLMHost: %v, %v", stored, err)
+	}
+	if stored.Status {
+		t.Error("refresh undid the eviction")
+	}
+}
@@ -0,0 +1,45 @@
+package logic
+
+import (
+	"sync"
+	"time"
+)
+
+// Weight of the newest observation in the moving average
+const latencyEWMAAlpha = 0.3
+
+// LatencyTracker keeps an exponentially weighted moving average of the time
+// to first token of every host
+type LatencyTracker struct {
+	mu      sync.RWMutex
+	average map[string]float64 // Host IP -> seconds
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{
+		average: make(map[string]float64),
+	}
+}
+
+// Observe records the time to first token of a request served by a host
+func (t *LatencyTracker) Observe(ipAddress string, ttft time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sample := ttft.Seconds()
+	current, ok := t.average[ipAddress]
+	if !ok {
+		t.average[ipAddress] = sample
+		return
+	}
+	t.average[ipAddress] = latencyEWMAAlpha*sample + (1-latencyEWMAAlpha)*current
+}
+
+// Get returns the moving average for a host and whether it has been observed
+func (t *LatencyTracker) Get(ipAddress string) (time.Duration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	average, ok := t.average[ipAddress]
+	return time.Duration(average * float64(time.Second)), ok
+}
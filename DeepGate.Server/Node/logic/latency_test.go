@@ -0,0 +1,39 @@
+package logic
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	tracker := NewLatencyTracker()
+	if _, ok := tracker.Get("10.0.0.1"); ok {
+		t.Fatal("unobserved host has a latency")
+	}
+
+	// The first sample is taken as is, later ones are weighted by
+	// latencyEWMAAlpha
+	samples := []struct {
+		ttft time.Duration
+		want float64 // Seconds
+	}{
+		{time.Second, 1},
+		{2 * time.Second, 0.3*2 + 0.7*1},
+		{0, 0.7 * (0.3*2 + 0.7*1)},
+		{500 * time.Millisecond, 0.3*0.5 + 0.7*0.7*(0.3*2+0.7*1)},
+	}
+	for i, sample := range samples {
+		tracker.Observe("10.0.0.1", sample.ttft)
+		got, ok := tracker.Get("10.0.0.1")
+		if !ok || math.Abs(got.Seconds()-sample.want) > 1e-6 {
+			t.Errorf("after sample %d: average = %v, %v, want %.4fs", i+1, got, ok, sample.want)
+		}
+	}
+
+	// Hosts are tracked separately
+	tracker.Observe("10.0.0.2", 3*time.Second)
+	if got, _ := tracker.Get("10.0.0.2"); got != 3*time.Second {
+		t.Errorf("second host average = %v, want 3s", got)
+	}
+}
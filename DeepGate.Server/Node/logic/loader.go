@@ -0,0 +1,169 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"node/clients"
+	"node/metrics"
+	"node/models"
+)
+
+// DefaultLoadTimeout bounds how long a model load may take, cold loads of
+// large models from disk can take minutes
+const DefaultLoadTimeout = 5 * time.Minute
+
+// ModelLoader loads models on inactive hosts. Concurrent loads of the same
+// model share a single call to the host.
+type ModelLoader struct {
+	redis  *clients.RedisClient
+	logger *slog.Logger
+
+	// AutoLoad makes chats for a model without an active host load it first
+	AutoLoad bool
+	// Timeout bounds a single load, including waiting for a shared one
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*loadCall
+}
+
+// loadCall is a load in progress that other callers can wait on
+type loadCall struct {
+	done     chan struct{}
+	host     *models.LLMHost
+	response []byte
+	err      error
+}
+
+func NewModelLoader(redis *clients.RedisClient, logger *slog.Logger) *ModelLoader {
+	return &ModelLoader{
+		redis:    redis,
+		logger:   logger,
+		AutoLoad: true,
+		Timeout:  DefaultLoadTimeout,
+		inflight: make(map[string]*loadCall),
+	}
+}
+
+// LoadModel loads a model on an inactive host and marks that host active.
+// It returns the host and its raw load response. If the same model is
+// already being loaded the caller waits for that load instead.
+func (l *ModelLoader) LoadModel(ctx context.Context, modelName string) (*models.LLMHost, []byte, error) {
+	l.mu.Lock()
+	call, exists := l.inflight[modelName]
+	if !exists {
+		call = &loadCall{done: make(chan struct{})}
+		l.inflight[modelName] = call
+
+		// The load runs detached from ctx so a caller that gives up does not
+		// abort it for the others
+		loadCtx := context.WithoutCancel(ctx)
+		go func() {
+			start := time.Now()
+			call.host, call.response, call.err = l.load(loadCtx, modelName)
+			metrics.ObserveModelLoad(modelName, time.Since(start), call.err)
+
+			l.mu.Lock()
+			delete(l.inflight, modelName)
+			l.mu.Unlock()
+			close(call.done)
+		}()
+	} else {
+		l.logger.InfoContext(ctx, "Waiting for in-flight load of model", "model", modelName)
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		return call.host, call.response, call.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil, fmt.Errorf("timed out after %v waiting for model %s to load", l.Timeout, modelName)
+	}
+}
+
+// load performs the actual load on a host
+func (l *ModelLoader) load(ctx context.Context, modelName string) (*models.LLMHost, []byte, error) {
+	start := time.Now()
+
+	// Get inactive host and corresponding model
+	inactiveHost, selectedModel, err := GetServerToLoad(ctx, modelName, l.redis, l.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Call the Host server to load model
+	apiClient := clients.MakeTemporaryAPIClient(inactiveHost.HostInfo, inactiveHost.Token)
+	apiClient.HTTPClient.Timeout = l.Timeout
+
+	request := map[string]string{"model_name": modelName}
+	resp, err := apiClient.MakeRequest(ctx, "POST", "/host/load-model", request, nil)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "Failed to load model", "model", modelName, "host", inactiveHost.IPAdd, "error", err)
+		return nil, nil, fmt.Errorf("failed to load model: %v", err)
+	}
+
+	// Update HostingServer status to active
+	_, err = l.redis.SetHostingServerStatus(ctx, selectedModel.Modelinfo.Name, inactiveHost.HostInfo.IPAddress, true)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "Failed to update model in Redis", "model", modelName, "error", err)
+		return nil, nil, fmt.Errorf("failed to update model status: %v", err)
+	}
+
+	l.logger.InfoContext(ctx, "Model loaded", "model", modelName, "host", inactiveHost.IPAdd, "elapsed", time.Since(start))
+	return inactiveHost, resp, nil
+}
+
+// UnloadModel unloads a model from its active hosts, or only from hostIP when
+// it is set, and marks those hosts inactive. It returns the unloaded hosts.
+func (l *ModelLoader) UnloadModel(ctx context.Context, modelName, hostIP string) ([]string, error) {
+	selectedModel, err := l.redis.GetLLModel(ctx, modelName)
+	if err != nil {
+		return nil, err
+	}
+	if selectedModel == nil {
+		return nil, ErrModelNotFound
+	}
+
+	unloaded := []string{}
+	for _, server := range selectedModel.HostingServers {
+		if !server.Status || (hostIP != "" && server.IPAdd != hostIP) {
+			continue
+		}
+
+		host, err := l.redis.GetLLMHost(ctx, server.IPAdd)
+		if err != nil {
+			return unloaded, err
+		}
+		if host == nil {
+			continue
+		}
+
+		apiClient := clients.MakeTemporaryAPIClient(host.HostInfo, host.Token)
+		request := map[string]string{"model_name": modelName}
+		if _, err := apiClient.MakeRequest(ctx, "POST", "/host/unload-model", request, nil); err != nil {
+			l.logger.ErrorContext(ctx, "Failed to unload model", "model", modelName, "host", host.IPAdd, "error", err)
+			return unloaded, fmt.Errorf("failed to unload model on %s: %v", host.IPAdd, err)
+		}
+
+		if _, err := l.redis.SetHostingServerStatus(ctx, modelName, host.IPAdd, false); err != nil {
+			return unloaded, err
+		}
+
+		l.logger.InfoContext(ctx, "Model unloaded", "model", modelName, "host", host.IPAdd)
+		unloaded = append(unloaded, host.IPAdd)
+	}
+
+	if len(unloaded) == 0 {
+		return nil, ErrNoAvailableHosts
+	}
+	return unloaded, nil
+}
@@ -0,0 +1,302 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"node/clients"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// loadingHost is a fake host whose loads block until release is closed
+type loadingHost struct {
+	info    databinding.InfoPackage
+	loads   atomic.Int32
+	started chan struct{} // Receives a value when a load reaches the host
+	release chan struct{}
+
+	mu        sync.Mutex
+	cancelled bool // A load request was cancelled by the Node
+}
+
+func newLoadingHost(t *testing.T) *loadingHost {
+	t.Helper()
+	host := &loadingHost{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/host/load-model" {
+			http.NotFound(w, r)
+			return
+		}
+		host.loads.Add(1)
+		host.started <- struct{}{}
+
+		select {
+		case <-host.release:
+			w.Write([]byte(`{"status":"loaded"}`))
+		case <-r.Context().Done():
+			host.mu.Lock()
+			host.cancelled = true
+			host.mu.Unlock()
+		}
+	}))
+	// Blocked loads are released before the server waits for them
+	t.Cleanup(server.Close)
+	t.Cleanup(host.finish)
+
+	ip, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.info = databinding.InfoPackage{IPAddress: ip, HostPort: port}
+	return host
+}
+
+// finish lets every blocked and later load complete
+func (h *loadingHost) finish() {
+	select {
+	case <-h.release:
+	default:
+		close(h.release)
+	}
+}
+
+// waitStarted waits until a load reaches the host
+func (h *loadingHost) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-h.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("load never reached the host")
+	}
+}
+
+// newTestLoader registers host with an inactive llama and returns a loader
+// for it
+func newTestLoader(t *testing.T, host *loadingHost) (*ModelLoader, *clients.RedisClient) {
+	t.Helper()
+	rc := newTestRedis(t)
+	registered := models.LLMHost{
+		IPAdd:     host.info.IPAddress,
+		HostInfo:  host.info,
+		ModelInfo: []models.HostModelInfo{llama},
+		Status:    true,
+	}
+	if err := SyncHostModels(context.Background(), registered.IPAdd, keepOnline(registered), rc, discardLogger); err != nil {
+		t.Fatalf("SyncHostModels: %v", err)
+	}
+	return NewModelLoader(rc, discardLogger), rc
+}
+
+// loadResult is what a LoadModel call returned
+type loadResult struct {
+	host     *models.LLMHost
+	response []byte
+	err      error
+}
+
+// startLoad calls LoadModel in the background
+func startLoad(ctx context.Context, loader *ModelLoader, modelName string) <-chan loadResult {
+	result := make(chan loadResult, 1)
+	go func() {
+		host, response, err := loader.LoadModel(ctx, modelName)
+		result <- loadResult{host, response, err}
+	}()
+	return result
+}
+
+// awaitLoad returns the result of a LoadModel call
+func awaitLoad(t *testing.T, result <-chan loadResult) loadResult {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadModel did not return")
+		return loadResult{}
+	}
+}
+
+// hostActive reports whether the host serves modelName as active
+func hostActive(t *testing.T, rc *clients.RedisClient, modelName, ip string) bool {
+	t.Helper()
+	model, err := rc.GetLLModel(context.Background(), modelName)
+	if err != nil || model == nil {
+		t.Fatalf("GetLLModel = %v, %v", model, err)
+	}
+	for _, server := range model.HostingServers {
+		if server.IPAdd == ip {
+			return server.Status
+		}
+	}
+	return false
+}
+
+// waitInflight waits until the loader has no load of modelName in progress
+func waitInflight(t *testing.T, loader *ModelLoader, modelName string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		loader.mu.Lock()
+		_, loading := loader.inflight[modelName]
+		loader.mu.Unlock()
+		if !loading {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("load of %s never finished", modelName)
+}
+
+func TestLoadModelSharesConcurrentLoads(t *testing.T) {
+	host := newLoadingHost(t)
+	loader, rc := newTestLoader(t, host)
+
+	const callers = 5
+	first := startLoad(context.Background(), loader, llama.Name)
+	host.waitStarted(t)
+
+	// Callers arriving while the load runs wait for it
+	results := []<-chan loadResult{first}
+	for i := 1; i < callers; i++ {
+		results = append(results, startLoad(context.Background(), loader, llama.Name))
+	}
+	time.Sleep(50 * time.Millisecond)
+	host.finish()
+
+	for i, result := range results {
+		r := awaitLoad(t, result)
+		if r.err != nil {
+			t.Fatalf("caller %d: %v", i, r.err)
+		}
+		if r.host == nil || r.host.IPAdd != host.info.IPAddress || string(r.response) != `{"status":"loaded"}` {
+			t.Errorf("caller %d got host %+v and response %s", i, r.host, r.response)
+		}
+	}
+	if loads := host.loads.Load(); loads != 1 {
+		t.Errorf("host received %d loads, want 1", loads)
+	}
+	if !hostActive(t, rc, llama.Name, host.info.IPAddress) {
+		t.Error("host not marked active after the load")
+	}
+
+	// A finished load is not shared with later callers
+	waitInflight(t, loader, llama.Name)
+	if _, err := rc.SetHostingServerStatus(context.Background(), llama.Name, host.info.IPAddress, false); err != nil {
+		t.Fatal(err)
+	}
+	if r := awaitLoad(t, startLoad(context.Background(), loader, llama.Name)); r.err != nil {
+		t.Fatalf("second load: %v", r.err)
+	}
+	if loads := host.loads.Load(); loads != 2 {
+		t.Errorf("host received %d loads, want 2", loads)
+	}
+}
+
+func TestLoadModelSurvivesCancelledCaller(t *testing.T) {
+	host := newLoadingHost(t)
+	loader, rc := newTestLoader(t, host)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := startLoad(ctx, loader, llama.Name)
+	host.waitStarted(t)
+	second := startLoad(context.Background(), loader, llama.Name)
+
+	// The caller that started the load gives up, the load goes on
+	cancel()
+	if r := awaitLoad(t, first); !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("cancelled caller got %v, want context.Canceled", r.err)
+	}
+	host.finish()
+
+	if r := awaitLoad(t, second); r.err != nil || r.host == nil {
+		t.Fatalf("waiting caller got %+v", r)
+	}
+	host.mu.Lock()
+	cancelled := host.cancelled
+	host.mu.Unlock()
+	if cancelled {
+		t.Error("load request was cancelled with its caller")
+	}
+	if loads := host.loads.Load(); loads != 1 {
+		t.Errorf("host received %d loads, want 1", loads)
+	}
+	if !hostActive(t, rc, llama.Name, host.info.IPAddress) {
+		t.Error("host not marked active after the load")
+	}
+}
+
+func TestLoadModelCompletesWithoutCallers(t *testing.T) {
+	host := newLoadingHost(t)
+	loader, rc := newTestLoader(t, host)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := startLoad(ctx, loader, llama.Name)
+	host.waitStarted(t)
+	cancel()
+	awaitLoad(t, result)
+
+	// Nobody waits anymore, the host still ends up active
+	host.finish()
+	waitInflight(t, loader, llama.Name)
+	if !hostActive(t, rc, llama.Name, host.info.IPAddress) {
+		t.Error("load abandoned by its only caller did not complete")
+	}
+}
+
+func TestLoadModelTimeoutReleasesWaiters(t *testing.T) {
+	host := newLoadingHost(t)
+	loader, _ := newTestLoader(t, host)
+	loader.Timeout = 100 * time.Millisecond
+
+	results := []<-chan loadResult{startLoad(context.Background(), loader, llama.Name)}
+	host.waitStarted(t)
+	for i := 1; i < 3; i++ {
+		results = append(results, startLoad(context.Background(), loader, llama.Name))
+	}
+
+	// The host never answers, every caller gives up after the timeout. The
+	// host call is bounded by the same timeout and may fail first.
+	for i, result := range results {
+		if r := awaitLoad(t, result); r.err == nil {
+			t.Errorf("caller %d got host %+v, want a timeout", i, r.host)
+		}
+	}
+	if loads := host.loads.Load(); loads != 1 {
+		t.Errorf("host received %d loads, want 1", loads)
+	}
+
+	// The abandoned load ends with the host call's own timeout, a new
+	// load can start afterwards
+	waitInflight(t, loader, llama.Name)
+}
+
+func TestLoadModelErrors(t *testing.T) {
+	host := newLoadingHost(t)
+	loader, rc := newTestLoader(t, host)
+
+	if _, _, err := loader.LoadModel(context.Background(), "missing:1b"); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("unknown model: %v, want ErrModelNotFound", err)
+	}
+
+	if _, err := rc.SetHostingServerStatus(context.Background(), llama.Name, host.info.IPAddress, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loader.LoadModel(context.Background(), llama.Name); !errors.Is(err, ErrNoInactiveServers) {
+		t.Errorf("model active everywhere: %v, want ErrNoInactiveServers", err)
+	}
+	if loads := host.loads.Load(); loads != 0 {
+		t.Errorf("host received %d loads, want 0", loads)
+	}
+}
@@ -0,0 +1,115 @@
+package logic
+
+import (
+	"fmt"
+
+	"node/models"
+)
+
+// Names of the available scheduling strategies
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastConnections = "least-connections"
+	StrategyWeighted         = "weighted"
+	StrategyLatency          = "latency"
+	StrategyConsistentHash   = "consistent-hash"
+)
+
+// ScheduleRequest carries what a strategy may need to rank hosts
+type ScheduleRequest struct {
+	Model          string
+	ConversationID string
+}
+
+// Scheduler orders the candidate hosts of a request, best host first.
+// Hosts after the first are used as fallbacks.
+type Scheduler interface {
+	Name() string
+	Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost
+}
+
+// SchedulingConfig selects a strategy per model, falling back to Default
+type SchedulingConfig struct {
+	Default string
+	Models  map[string]string
+}
+
+// DefaultSchedulingConfig schedules every model to the least loaded host
+func DefaultSchedulingConfig() SchedulingConfig {
+	return SchedulingConfig{
+		Default: StrategyLeastConnections,
+		Models:  map[string]string{},
+	}
+}
+
+// SchedulerSet holds the scheduler of every configured model
+type SchedulerSet struct {
+	defaultScheduler Scheduler
+	modelSchedulers  map[string]Scheduler
+	latency          *LatencyTracker
+}
+
+// NewSchedulerSet builds the schedulers described by config. Strategies share
+// a single instance so that stateful strategies see every request.
+func NewSchedulerSet(config SchedulingConfig) (*SchedulerSet, error) {
+	latency := NewLatencyTracker()
+	instances := make(map[string]Scheduler)
+
+	build := func(strategy string) (Scheduler, error) {
+		if scheduler, ok := instances[strategy]; ok {
+			return scheduler, nil
+		}
+
+		var scheduler Scheduler
+		switch strategy {
+		case StrategyRoundRobin:
+			scheduler = NewRoundRobinScheduler()
+		case StrategyLeastConnections:
+			scheduler = NewLeastConnectionsScheduler()
+		case StrategyWeighted:
+			scheduler = NewWeightedScheduler()
+		case StrategyLatency:
+			scheduler = NewLatencyScheduler(latency)
+		case StrategyConsistentHash:
+			scheduler = NewConsistentHashScheduler()
+		default:
+			return nil, fmt.Errorf("unknown scheduling strategy: %q", strategy)
+		}
+
+		instances[strategy] = scheduler
+		return scheduler, nil
+	}
+
+	defaultScheduler, err := build(config.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	modelSchedulers := make(map[string]Scheduler, len(config.Models))
+	for model, strategy := range config.Models {
+		scheduler, err := build(strategy)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %v", model, err)
+		}
+		modelSchedulers[model] = scheduler
+	}
+
+	return &SchedulerSet{
+		defaultScheduler: defaultScheduler,
+		modelSchedulers:  modelSchedulers,
+		latency:          latency,
+	}, nil
+}
+
+// For returns the scheduler configured for a model
+func (s *SchedulerSet) For(model string) Scheduler {
+	if scheduler, ok := s.modelSchedulers[model]; ok {
+		return scheduler
+	}
+	return s.defaultScheduler
+}
+
+// Latency returns the tracker fed with time to first token observations
+func (s *SchedulerSet) Latency() *LatencyTracker {
+	return s.latency
+}
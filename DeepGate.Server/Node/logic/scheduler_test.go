@@ -0,0 +1,78 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSchedulerSet(t *testing.T) {
+	schedulers, err := NewSchedulerSet(SchedulingConfig{
+		Default: StrategyLeastConnections,
+		Models: map[string]string{
+			"llama3:8b":  StrategyConsistentHash,
+			"qwen2:1.5b": StrategyLatency,
+			"phi3:mini":  StrategyLatency,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSchedulerSet: %v", err)
+	}
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"llama3:8b", StrategyConsistentHash},
+		{"qwen2:1.5b", StrategyLatency},
+		{"mistral:7b", StrategyLeastConnections},
+	}
+	for _, tt := range tests {
+		if got := schedulers.For(tt.model).Name(); got != tt.want {
+			t.Errorf("For(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+
+	// Models with the same strategy share its state, the latency scheduler
+	// reads the set's tracker
+	if schedulers.For("qwen2:1.5b") != schedulers.For("phi3:mini") {
+		t.Error("models with the same strategy got separate schedulers")
+	}
+	if latency := schedulers.For("qwen2:1.5b").(*LatencyScheduler).latency; latency != schedulers.Latency() {
+		t.Error("latency scheduler does not use the set's tracker")
+	}
+}
+
+func TestNewSchedulerSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SchedulingConfig
+		wantErr string
+	}{
+		{name: "unknown default", config: SchedulingConfig{Default: "random"}, wantErr: `unknown scheduling strategy: "random"`},
+		{name: "missing default", config: SchedulingConfig{}, wantErr: `unknown scheduling strategy: ""`},
+		{
+			name:    "unknown model strategy",
+			config:  SchedulingConfig{Default: StrategyWeighted, Models: map[string]string{"llama3:8b": "fastest"}},
+			wantErr: `model llama3:8b: unknown scheduling strategy: "fastest"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSchedulerSet(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultSchedulingConfig(t *testing.T) {
+	schedulers, err := NewSchedulerSet(DefaultSchedulingConfig())
+	if err != nil {
+		t.Fatalf("NewSchedulerSet: %v", err)
+	}
+	if got := schedulers.For("llama3:8b").Name(); got != StrategyLeastConnections {
+		t.Errorf("default strategy = %s, want %s", got, StrategyLeastConnections)
+	}
+}
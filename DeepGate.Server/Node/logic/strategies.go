@@ -0,0 +1,194 @@
+package logic
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+
+	"node/models"
+)
+
+// sortedByIP returns a copy of hosts in a stable IP order
+func sortedByIP(hosts []*models.LLMHost) []*models.LLMHost {
+	ordered := make([]*models.LLMHost, len(hosts))
+	copy(ordered, hosts)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].IPAdd < ordered[j].IPAdd
+	})
+	return ordered
+}
+
+// hostCapacity returns the advertised capacity of a host, at least 1
+func hostCapacity(host *models.LLMHost) int {
+	if host.HostInfo.Capacity < 1 {
+		return 1
+	}
+	return host.HostInfo.Capacity
+}
+
+// RoundRobinScheduler rotates through the hosts of each model
+type RoundRobinScheduler struct {
+	mu       sync.Mutex
+	counters map[string]uint64
+}
+
+func NewRoundRobinScheduler() *RoundRobinScheduler {
+	return &RoundRobinScheduler{
+		counters: make(map[string]uint64),
+	}
+}
+
+func (s *RoundRobinScheduler) Name() string {
+	return StrategyRoundRobin
+}
+
+func (s *RoundRobinScheduler) Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost {
+	ordered := sortedByIP(hosts)
+	if len(ordered) == 0 {
+		return ordered
+	}
+
+	s.mu.Lock()
+	start := int(s.counters[request.Model] % uint64(len(ordered)))
+	s.counters[request.Model]++
+	s.mu.Unlock()
+
+	return append(ordered[start:], ordered[:start]...)
+}
+
+// LeastConnectionsScheduler prefers the host with the fewest in-flight tasks
+type LeastConnectionsScheduler struct{}
+
+func NewLeastConnectionsScheduler() *LeastConnectionsScheduler {
+	return &LeastConnectionsScheduler{}
+}
+
+func (s *LeastConnectionsScheduler) Name() string {
+	return StrategyLeastConnections
+}
+
+func (s *LeastConnectionsScheduler) Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost {
+	ordered := sortedByIP(hosts)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].TaskCount < ordered[j].TaskCount
+	})
+	return ordered
+}
+
+// WeightedScheduler prefers the host with the lowest load relative to its
+// advertised capacity, so a host with capacity 4 takes four times the tasks
+// of a host with capacity 1
+type WeightedScheduler struct{}
+
+func NewWeightedScheduler() *WeightedScheduler {
+	return &WeightedScheduler{}
+}
+
+func (s *WeightedScheduler) Name() string {
+	return StrategyWeighted
+}
+
+func (s *WeightedScheduler) Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost {
+	load := func(host *models.LLMHost) float64 {
+		return float64(host.TaskCount+1) / float64(hostCapacity(host))
+	}
+
+	ordered := sortedByIP(hosts)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return load(ordered[i]) < load(ordered[j])
+	})
+	return ordered
+}
+
+// LatencyScheduler prefers the host with the lowest moving average of time to
+// first token. Hosts without observations are tried first so they get measured.
+type LatencyScheduler struct {
+	latency *LatencyTracker
+}
+
+func NewLatencyScheduler(latency *LatencyTracker) *LatencyScheduler {
+	return &LatencyScheduler{
+		latency: latency,
+	}
+}
+
+func (s *LatencyScheduler) Name() string {
+	return StrategyLatency
+}
+
+func (s *LatencyScheduler) Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost {
+	ordered := sortedByIP(hosts)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		latencyI, knownI := s.latency.Get(ordered[i].IPAdd)
+		latencyJ, knownJ := s.latency.Get(ordered[j].IPAdd)
+		if knownI != knownJ {
+			return !knownI
+		}
+		return latencyI < latencyJ
+	})
+	return ordered
+}
+
+// ConsistentHashScheduler pins a conversation to the same host so the host
+// can reuse its KV cache. Requests without a conversation ID fall back to
+// least connections.
+type ConsistentHashScheduler struct {
+	replicas int
+	fallback Scheduler
+}
+
+// Virtual nodes per host on the hash ring
+const consistentHashReplicas = 100
+
+func NewConsistentHashScheduler() *ConsistentHashScheduler {
+	return &ConsistentHashScheduler{
+		replicas: consistentHashReplicas,
+		fallback: NewLeastConnectionsScheduler(),
+	}
+}
+
+func (s *ConsistentHashScheduler) Name() string {
+	return StrategyConsistentHash
+}
+
+func (s *ConsistentHashScheduler) Order(request ScheduleRequest, hosts []*models.LLMHost) []*models.LLMHost {
+	if request.ConversationID == "" || len(hosts) < 2 {
+		return s.fallback.Order(request, hosts)
+	}
+
+	type point struct {
+		hash uint32
+		host *models.LLMHost
+	}
+
+	ring := make([]point, 0, len(hosts)*s.replicas)
+	for _, host := range hosts {
+		for i := 0; i < s.replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", host.IPAdd, i)))
+			ring = append(ring, point{hash: hash, host: host})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	// Walk the ring clockwise from the conversation's position; the distinct
+	// hosts met along the way are the fallback order
+	key := crc32.ChecksumIEEE([]byte(request.ConversationID))
+	start := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= key
+	})
+
+	ordered := make([]*models.LLMHost, 0, len(hosts))
+	seen := make(map[string]bool, len(hosts))
+	for i := 0; i < len(ring) && len(ordered) < len(hosts); i++ {
+		host := ring[(start+i)%len(ring)].host
+		if !seen[host.IPAdd] {
+			seen[host.IPAdd] = true
+			ordered = append(ordered, host)
+		}
+	}
+
+	return ordered
+}
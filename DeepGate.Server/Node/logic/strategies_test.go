@@ -0,0 +1,238 @@
+package logic
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// testHost is an LLMHost with a task count and an advertised capacity
+func testHost(ip string, taskCount, capacity int) *models.LLMHost {
+	return &models.LLMHost{
+		IPAdd:     ip,
+		HostInfo:  databinding.InfoPackage{IPAddress: ip, Capacity: capacity},
+		Status:    true,
+		TaskCount: taskCount,
+	}
+}
+
+// hostIPs returns the IPs of hosts in order
+func hostIPs(hosts []*models.LLMHost) []string {
+	ips := make([]string, len(hosts))
+	for i, host := range hosts {
+		ips[i] = host.IPAdd
+	}
+	return ips
+}
+
+func TestRoundRobinScheduler(t *testing.T) {
+	scheduler := NewRoundRobinScheduler()
+	hosts := []*models.LLMHost{testHost("10.0.0.3", 0, 1), testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 0, 1)}
+	llama := ScheduleRequest{Model: "llama3:8b"}
+
+	want := [][]string{
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	}
+	for i, wantIPs := range want {
+		if got := hostIPs(scheduler.Order(llama, hosts)); !reflect.DeepEqual(got, wantIPs) {
+			t.Errorf("request %d: order = %v, want %v", i+1, got, wantIPs)
+		}
+	}
+
+	// Every model rotates on its own
+	if got := hostIPs(scheduler.Order(ScheduleRequest{Model: "qwen2:1.5b"}, hosts)); got[0] != "10.0.0.1" {
+		t.Errorf("first request of another model starts at %s, want 10.0.0.1", got[0])
+	}
+	if got := hostIPs(scheduler.Order(llama, hosts)); got[0] != "10.0.0.2" {
+		t.Errorf("fifth request starts at %s, want 10.0.0.2", got[0])
+	}
+
+	// The caller's slice is left alone
+	if got := hostIPs(hosts); !reflect.DeepEqual(got, []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("hosts reordered in place: %v", got)
+	}
+	if got := scheduler.Order(llama, nil); len(got) != 0 {
+		t.Errorf("no hosts ordered to %v", hostIPs(got))
+	}
+}
+
+func TestLeastConnectionsScheduler(t *testing.T) {
+	hosts := []*models.LLMHost{testHost("10.0.0.3", 1, 1), testHost("10.0.0.1", 2, 1), testHost("10.0.0.2", 1, 1)}
+
+	got := hostIPs(NewLeastConnectionsScheduler().Order(ScheduleRequest{Model: "llama3:8b"}, hosts))
+	// Ties are broken by IP
+	want := []string{"10.0.0.2", "10.0.0.3", "10.0.0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestWeightedScheduler(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []*models.LLMHost
+		want  []string
+	}{
+		{
+			name:  "idle hosts by capacity",
+			hosts: []*models.LLMHost{testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 0, 4), testHost("10.0.0.3", 0, 2)},
+			want:  []string{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		},
+		{
+			name: "load relative to capacity",
+			// (3+1)/4 = 1 against (0+1)/1 = 1 and (1+1)/1 = 2
+			hosts: []*models.LLMHost{testHost("10.0.0.1", 1, 1), testHost("10.0.0.2", 3, 4), testHost("10.0.0.3", 0, 1)},
+			want:  []string{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		},
+		{
+			name:  "busy large host yields",
+			hosts: []*models.LLMHost{testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 4, 4)},
+			want:  []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:  "missing capacity counts as 1",
+			hosts: []*models.LLMHost{testHost("10.0.0.1", 1, 0), testHost("10.0.0.2", 1, 2)},
+			want:  []string{"10.0.0.2", "10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hostIPs(NewWeightedScheduler().Order(ScheduleRequest{Model: "llama3:8b"}, tt.hosts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedSchedulerShare(t *testing.T) {
+	// Handing each task to the first host fills hosts by their capacity
+	hosts := []*models.LLMHost{testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 0, 3)}
+	scheduler := NewWeightedScheduler()
+	for i := 0; i < 8; i++ {
+		scheduler.Order(ScheduleRequest{Model: "llama3:8b"}, hosts)[0].TaskCount++
+	}
+
+	if hosts[0].TaskCount != 2 || hosts[1].TaskCount != 6 {
+		t.Errorf("tasks = %d and %d, want 2 and 6", hosts[0].TaskCount, hosts[1].TaskCount)
+	}
+}
+
+func TestLatencyScheduler(t *testing.T) {
+	latency := NewLatencyTracker()
+	latency.Observe("10.0.0.1", 800*time.Millisecond)
+	latency.Observe("10.0.0.2", 200*time.Millisecond)
+	hosts := []*models.LLMHost{testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 0, 1), testHost("10.0.0.3", 0, 1)}
+
+	// The unmeasured host goes first so it gets measured
+	got := hostIPs(NewLatencyScheduler(latency).Order(ScheduleRequest{Model: "llama3:8b"}, hosts))
+	want := []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+// conversationHosts returns the first host the consistent hash picks for each
+// conversation
+func conversationHosts(scheduler Scheduler, conversations []string, hosts []*models.LLMHost) map[string]string {
+	picked := make(map[string]string, len(conversations))
+	for _, conversation := range conversations {
+		ordered := scheduler.Order(ScheduleRequest{Model: "llama3:8b", ConversationID: conversation}, hosts)
+		picked[conversation] = ordered[0].IPAdd
+	}
+	return picked
+}
+
+func TestConsistentHashScheduler(t *testing.T) {
+	scheduler := NewConsistentHashScheduler()
+	hosts := []*models.LLMHost{testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 0, 1), testHost("10.0.0.3", 0, 1)}
+	conversations := make([]string, 1000)
+	for i := range conversations {
+		conversations[i] = fmt.Sprintf("conversation-%d", i)
+	}
+
+	before := conversationHosts(scheduler, conversations, hosts)
+
+	t.Run("stable", func(t *testing.T) {
+		// Neither the host order nor the load moves a conversation
+		reordered := []*models.LLMHost{testHost("10.0.0.3", 5, 1), testHost("10.0.0.1", 0, 1), testHost("10.0.0.2", 9, 1)}
+		if again := conversationHosts(scheduler, conversations, reordered); !reflect.DeepEqual(again, before) {
+			t.Error("conversations moved between identical host sets")
+		}
+
+		perHost := make(map[string]int)
+		for _, host := range before {
+			perHost[host]++
+		}
+		for _, host := range hosts {
+			if perHost[host.IPAdd] < 200 {
+				t.Errorf("host %s got %d of 1000 conversations", host.IPAdd, perHost[host.IPAdd])
+			}
+		}
+	})
+
+	t.Run("host added", func(t *testing.T) {
+		after := conversationHosts(scheduler, conversations, append(hosts, testHost("10.0.0.4", 0, 1)))
+
+		moved := 0
+		for _, conversation := range conversations {
+			if after[conversation] == before[conversation] {
+				continue
+			}
+			moved++
+			// Only the new host takes conversations over
+			if after[conversation] != "10.0.0.4" {
+				t.Fatalf("%s moved from %s to %s", conversation, before[conversation], after[conversation])
+			}
+		}
+		if moved == 0 || moved > 400 {
+			t.Errorf("%d of 1000 conversations moved to the new host", moved)
+		}
+	})
+
+	t.Run("host removed", func(t *testing.T) {
+		// The fallbacks of a conversation are where it goes when its host leaves
+		fallbacks := make(map[string]string, len(conversations))
+		for _, conversation := range conversations {
+			ordered := scheduler.Order(ScheduleRequest{Model: "llama3:8b", ConversationID: conversation}, hosts)
+			if len(ordered) != len(hosts) {
+				t.Fatalf("%s ordered %d of %d hosts", conversation, len(ordered), len(hosts))
+			}
+			fallbacks[conversation] = ordered[1].IPAdd
+		}
+
+		after := conversationHosts(scheduler, conversations, hosts[:2])
+		for _, conversation := range conversations {
+			want := before[conversation]
+			if want == "10.0.0.3" {
+				want = fallbacks[conversation]
+			}
+			if after[conversation] != want {
+				t.Fatalf("%s moved from %s to %s, want %s", conversation, before[conversation], after[conversation], want)
+			}
+		}
+	})
+}
+
+func TestConsistentHashSchedulerFallback(t *testing.T) {
+	hosts := []*models.LLMHost{testHost("10.0.0.1", 3, 1), testHost("10.0.0.2", 1, 1)}
+	scheduler := NewConsistentHashScheduler()
+
+	// Without a conversation the least loaded host goes first
+	if got := hostIPs(scheduler.Order(ScheduleRequest{Model: "llama3:8b"}, hosts)); !reflect.DeepEqual(got, []string{"10.0.0.2", "10.0.0.1"}) {
+		t.Errorf("order without conversation = %v", got)
+	}
+	single := hosts[:1]
+	if got := hostIPs(scheduler.Order(ScheduleRequest{Model: "llama3:8b", ConversationID: "c1"}, single)); !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Errorf("order of a single host = %v", got)
+	}
+}
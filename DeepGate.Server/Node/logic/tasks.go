@@ -0,0 +1,37 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"node/clients"
+	"node/metrics"
+)
+
+// AcquireHostTask marks a new in-flight task on a host and returns a release
+// function that must be called once the task ends. Release is safe to call
+// more than once and ignores the cancellation of ctx, so it still runs after
+// the client request has been cancelled.
+func AcquireHostTask(ctx context.Context, ipAddress string, redis *clients.RedisClient, logger *slog.Logger) func() {
+	metrics.StreamStarted(ipAddress)
+
+	tracked := true
+	if _, err := redis.IncrementHostTasks(ctx, ipAddress); err != nil {
+		logger.ErrorContext(ctx, "Failed to track task", "host", ipAddress, "error", err)
+		tracked = false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			metrics.StreamEnded(ipAddress)
+			if !tracked {
+				return
+			}
+			if _, err := redis.DecrementHostTasks(context.WithoutCancel(ctx), ipAddress); err != nil {
+				logger.ErrorContext(ctx, "Failed to release task", "host", ipAddress, "error", err)
+			}
+		})
+	}
+}
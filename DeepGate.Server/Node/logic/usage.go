@@ -0,0 +1,30 @@
+package logic
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"node/clients"
+	"node/metrics"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// RecordUsage accounts the usage of a completed chat to its model, host and
+// caller. Failures are logged, a chat is never failed over its accounting.
+func RecordUsage(ctx context.Context, model, hostIP, caller string, usage databinding.Usage, redis *clients.RedisClient, logger *slog.Logger) {
+	dimensions := map[string]string{
+		models.UsageByModel:  model,
+		models.UsageByHost:   hostIP,
+		models.UsageByCaller: caller,
+	}
+
+	metrics.ObserveUsage(model, hostIP, usage.PromptEvalCount, usage.EvalCount, time.Duration(usage.EvalDuration))
+
+	// The request context may already be cancelled once the stream has ended
+	if err := redis.RecordUsage(context.WithoutCancel(ctx), usage, dimensions); err != nil {
+		logger.ErrorContext(ctx, "Failed to record usage", "model", model, "host", hostIP, "error", err)
+	}
+}
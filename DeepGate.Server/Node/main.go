@@ -1,45 +1,176 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	config "Pkgs/Config"
 	databinding "Pkgs/DataBinding"
 
 	"node/clients"
+	"node/logic"
+	"node/metrics"
 	"node/routes"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type NodeServer struct {
-	logger             *log.Logger
+	logger             *slog.Logger
 	databaseConnection *databinding.DatabaseConnections
+	shutdownTracing    func(context.Context) error
 	hostIP             string
 	APIRepo            *clients.APIClient
 	redis              *clients.RedisClient
+	mongo              *clients.MongoClient
+	auditor            *logic.Auditor
+	schedulers         *logic.SchedulerSet
+	loader             *logic.ModelLoader
+	auth               *routes.Authenticator
+	tls                databinding.TLSConfig
+	port               int
+	shutdownTimeout    time.Duration
+	mdns               bool
+	inventoryRefresh   time.Duration
+	hostCooldown       time.Duration
+	maxChatAttempts    int
+	heartbeatInterval  time.Duration
+	maxMissed          int
+	beacon             config.BeaconConfig
+	clusterID          string
+	clusterSecret      string
 }
 
-func NewNodeServer() *NodeServer {
-	logger := databinding.ConfigureLogger()
+func NewNodeServer(cfg *config.Config) *NodeServer {
+	logger := databinding.ConfigureLogger("node", cfg.LogLevel)
 
-	dbConnections, err := databinding.InitializeDatabases()
+	shutdownTracing, err := databinding.ConfigureTracing(context.Background(), "node", cfg.Tracing.Exporter, cfg.Tracing.OTLPEndpoint)
 	if err != nil {
-		logger.Fatalf("Failed to initialize databases: %v", err)
+		logger.Error("Failed to configure tracing", "error", err)
+		os.Exit(1)
 	}
 
-	redis := clients.NewRedisClient(dbConnections.RedisClient)
+	dbConnections, err := databinding.InitializeDatabases(databinding.DatabaseConfig{
+		MongoURI:      cfg.Mongo.URI,
+		MongoDatabase: cfg.Mongo.Database,
+		RedisAddress:  cfg.Redis.Address,
+		RedisPassword: cfg.Redis.Password,
+		RedisDB:       cfg.Redis.DB,
+	})
+	if err != nil {
+		logger.Error("Failed to initialize databases", "error", err)
+		os.Exit(1)
+	}
+
+	redis := clients.NewRedisClient(dbConnections.RedisClient, cfg.Node.HostTTL)
+
+	// Conversations are stored in MongoDB, which is only reached on first use,
+	// so a missing index does not keep the gateway from starting
+	mongo := clients.NewMongoClient(dbConnections.MongoDB)
+	if err := mongo.EnsureIndexes(context.Background(), cfg.Node.AuditRetention); err != nil {
+		logger.Warn("Failed to create MongoDB indexes", "error", err)
+	}
+
+	// Every chat and model load is audited, bodies only when configured
+	auditor := logic.NewAuditor(mongo, logger, cfg.Node.AuditBodies)
+
+	// Move models from the legacy llm_models blob into per model keys
+	migrated, err := redis.MigrateLLModelList(context.Background())
+	if err != nil {
+		logger.Error("Failed to migrate model registry", "error", err)
+		os.Exit(1)
+	}
+	if migrated > 0 {
+		logger.Info("Migrated models from legacy registry", "count", migrated)
+	}
+
+	// No stream can be in flight before the server starts
+	if err := redis.ResetHostTaskCounts(context.Background()); err != nil {
+		logger.Error("Failed to reset host task counts", "error", err)
+		os.Exit(1)
+	}
+
+	// Per model scheduling strategies, least connections unless configured
+	schedulers, err := logic.NewSchedulerSet(logic.SchedulingConfig{
+		Default: cfg.Node.Scheduling.Default,
+		Models:  cfg.Node.Scheduling.Models,
+	})
+	if err != nil {
+		logger.Error("Invalid scheduling config", "error", err)
+		os.Exit(1)
+	}
+
+	// Chats for a model without an active host load it first unless disabled
+	loader := logic.NewModelLoader(redis, logger)
+	loader.AutoLoad = cfg.Node.AutoLoad
+	loader.Timeout = cfg.Node.LoadTimeout
+
+	// Client routes require API keys and admin routes the admin token, unless
+	// the operator explicitly opened them
+	if cfg.Node.OpenAccess {
+		logger.Warn("Open access is enabled, client and admin routes accept any caller")
+	} else if cfg.Node.AdminToken == "" {
+		logger.Error("No admin token is configured, set node.admin_token or enable node.open_access")
+		os.Exit(1)
+	}
+
+	// Hosts enroll with the cluster secret shared by the Node and its hosts
+	if cfg.ClusterSecret == "" {
+		logger.Warn("No cluster secret is configured, any host can register")
+	}
+	auth := routes.NewAuthenticator(logger, redis, cfg.Node.AdminToken, cfg.ClusterSecret, cfg.Node.OpenAccess)
+
+	// Hosts advertising https are verified against the system roots and the
+	// configured CA bundle
+	tlsConfig := databinding.TLSConfig{
+		CertFile:     cfg.TLS.Cert,
+		KeyFile:      cfg.TLS.Key,
+		ClientCAFile: cfg.TLS.ClientCA,
+		CAFile:       cfg.TLS.CA,
+	}
+	hostTLS, err := tlsConfig.ClientConfig()
+	if err != nil {
+		logger.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+	clients.ConfigureHostClient(hostTLS, cfg.RequestTimeout)
 
 	return &NodeServer{
 		logger:             logger,
+		shutdownTracing:    shutdownTracing,
 		databaseConnection: dbConnections,
 		redis:              redis,
+		mongo:              mongo,
+		auditor:            auditor,
+		schedulers:         schedulers,
+		loader:             loader,
+		auth:               auth,
+		tls:                tlsConfig,
+		port:               cfg.Node.Port,
+		shutdownTimeout:    cfg.ShutdownTimeout,
+		mdns:               cfg.MDNS,
+		inventoryRefresh:   cfg.Node.InventoryRefresh,
+		hostCooldown:       cfg.Node.HostCooldown,
+		maxChatAttempts:    cfg.Node.MaxChatAttempts,
+		heartbeatInterval:  cfg.HeartbeatInterval,
+		maxMissed:          cfg.Node.MaxMissedHeartbeats,
+		beacon:             cfg.Beacon,
+		clusterID:          cfg.ClusterID,
+		clusterSecret:      cfg.ClusterSecret,
 	}
 }
 
 func (ns *NodeServer) SetupRoutes() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Recovery(), otelgin.Middleware("deepgate-node"), databinding.RequestIDMiddleware(), databinding.AccessLog(ns.logger), metrics.Middleware())
 	// r.POST("/ping", func(c *gin.Context) {
 	// 	var infoPackage databinding.InfoPackage
 	// 	if err := c.BindJSON(&infoPackage); err != nil {
@@ -85,25 +216,116 @@ func (ns *NodeServer) SetupRoutes() *gin.Engine {
 
 	// Initialize and register host routes
 	// Host routing logic
-	hostHandler := routes.NewHostHandler(ns.logger, ns.redis)
+	hostHandler := routes.NewHostHandler(ns.logger, ns.redis, ns.auth)
 	hostHandler.RegisterRoutes(r)
 
 	// Client routing logic
-	clientHandler := routes.NewClientHandler(ns.logger, ns.redis)
+	clientHandler := routes.NewClientHandler(ns.logger, ns.redis, ns.mongo, ns.schedulers, ns.loader, ns.auth, ns.auditor)
+	clientHandler.HostCooldown = ns.hostCooldown
+	clientHandler.MaxChatAttempts = ns.maxChatAttempts
 	clientHandler.RegisterRoutes(r)
 
+	// Conversation routing logic
+	conversationHandler := routes.NewConversationHandler(ns.logger, ns.mongo, ns.auth)
+	conversationHandler.RegisterRoutes(r)
+
+	// Admin routing logic
+	adminHandler := routes.NewAdminHandler(ns.logger, ns.redis, ns.mongo, ns.auth)
+	adminHandler.RegisterRoutes(r)
+
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/metrics", metrics.Handler())
 
 	return r
 }
 
 func (ns *NodeServer) Run() {
-	r := ns.SetupRoutes()
-	ns.logger.Println("Node server starting on 0.0.0.0:8080")
-	r.Run("0.0.0.0:8080")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Evict hosts that stop sending heartbeats
+	monitor := logic.NewHeartbeatMonitor(ns.redis, ns.logger)
+	monitor.Interval = ns.heartbeatInterval
+	monitor.MaxMissed = ns.maxMissed
+	monitor.Start(ctx)
+
+	// Pick up models pulled or removed on hosts
+	if ns.inventoryRefresh > 0 {
+		logic.NewInventoryRefresher(ns.redis, ns.logger, ns.inventoryRefresh).Start(ctx)
+	}
+
+	server, err := ns.tls.NewServer(fmt.Sprintf("0.0.0.0:%d", ns.port), ns.SetupRoutes())
+	if err != nil {
+		ns.logger.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Let hosts on the network find this node
+	stopAdvertising := func() {}
+	if ns.mdns {
+		stop, err := databinding.AdvertiseNode(ns.port, ns.tls.Scheme())
+		if err != nil {
+			ns.logger.Warn("Failed to advertise node over mDNS", "error", err)
+		} else {
+			stopAdvertising = stop
+			ns.logger.Info("Advertising node over mDNS", "service", databinding.DiscoveryService)
+		}
+	}
+	defer stopAdvertising()
+
+	// Unsigned beacons could point hosts at any node, they need the secret
+	if ns.beacon.Enabled && ns.clusterSecret == "" {
+		ns.logger.Warn("No cluster secret is configured, not sending beacons")
+	} else if ns.beacon.Enabled {
+		sender, err := databinding.NewBeaconSender(ns.beacon.Address, ns.beacon.Port)
+		if err != nil {
+			ns.logger.Warn("Failed to start beacon", "error", err)
+		} else {
+			beacon := databinding.Beacon{
+				ClusterID: ns.clusterID,
+				Address:   ns.beacon.NodeAddress,
+				Port:      ns.port,
+				Scheme:    ns.tls.Scheme(),
+			}
+			logic.NewBeaconBroadcaster(sender, beacon, ns.clusterSecret, ns.beacon.Interval, ns.logger).Start(ctx)
+			ns.logger.Info("Sending beacons", "address", ns.beacon.Address, "port", ns.beacon.Port, "cluster", ns.clusterID)
+		}
+	}
+
+	ns.logger.Info("Node server starting", "address", server.Addr, "scheme", ns.tls.Scheme())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- databinding.ListenAndServe(server)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			ns.logger.Error("Node server stopped", "error", err)
+		}
+	case <-ctx.Done():
+		// Stop accepting connections and let running chats finish
+		ns.logger.Info("Shutting down, draining requests", "timeout", ns.shutdownTimeout)
+		stopAdvertising()
+		if err := databinding.Shutdown(server, ns.shutdownTimeout); err != nil {
+			ns.logger.Warn("Node server did not drain", "error", err)
+		}
+		ns.logger.Info("Node server stopped")
+	}
+
+	// Flush the spans that are still buffered
+	if err := ns.shutdownTracing(context.Background()); err != nil {
+		ns.logger.Error("Failed to flush traces", "error", err)
+	}
 }
 
 func main() {
-	nodeServer := NewNodeServer()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	nodeServer := NewNodeServer(cfg)
 	nodeServer.Run()
 }
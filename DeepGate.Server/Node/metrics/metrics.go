@@ -0,0 +1,146 @@
+// Package metrics exposes the Node's Prometheus metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "deepgate_node"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, including the whole stream for chats.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"route", "method"})
+
+	timeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time from sending a chat to a host until its first stream event.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"model", "host"})
+
+	tokensPerSecond = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tokens_per_second",
+		Help:      "Generation speed of completed chats as reported by Ollama.",
+		Buckets:   prometheus.LinearBuckets(5, 10, 12),
+	}, []string{"model", "host"})
+
+	tokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Tokens processed by completed chats, by kind (prompt or completion).",
+	}, []string{"model", "kind"})
+
+	inFlightStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inflight_streams",
+		Help:      "Chats currently proxied to each host.",
+	}, []string{"host"})
+
+	hostFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "host_failures_total",
+		Help:      "Chats that failed on a host and put it in cooldown.",
+	}, []string{"host"})
+
+	registryModels = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "registry_models",
+		Help:      "Models in the registry.",
+	})
+
+	registryHosts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "registry_hosts",
+		Help:      "Hosts in the registry by status (online or offline).",
+	}, []string{"status"})
+
+	modelLoadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "model_load_duration_seconds",
+		Help:      "Duration of model loads requested by the Node, by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"model", "result"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records the count and latency of every request by route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Use the route pattern so path parameters do not explode the label set
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		requestsTotal.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		requestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveTimeToFirstToken records how long a host took to start streaming
+func ObserveTimeToFirstToken(model, host string, d time.Duration) {
+	timeToFirstToken.WithLabelValues(model, host).Observe(d.Seconds())
+}
+
+// ObserveUsage records the token counts and generation speed of a completed chat
+func ObserveUsage(model, host string, promptTokens, completionTokens int, evalDuration time.Duration) {
+	tokensTotal.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	tokensTotal.WithLabelValues(model, "completion").Add(float64(completionTokens))
+	if evalDuration > 0 && completionTokens > 0 {
+		tokensPerSecond.WithLabelValues(model, host).Observe(float64(completionTokens) / evalDuration.Seconds())
+	}
+}
+
+// StreamStarted counts a new chat proxied to a host, StreamEnded undoes it
+func StreamStarted(host string) {
+	inFlightStreams.WithLabelValues(host).Inc()
+}
+
+func StreamEnded(host string) {
+	inFlightStreams.WithLabelValues(host).Dec()
+}
+
+// HostFailed counts a chat failure blamed on a host
+func HostFailed(host string) {
+	hostFailures.WithLabelValues(host).Inc()
+}
+
+// SetRegistrySize records the number of registered models and hosts
+func SetRegistrySize(models, onlineHosts, offlineHosts int) {
+	registryModels.Set(float64(models))
+	registryHosts.WithLabelValues("online").Set(float64(onlineHosts))
+	registryHosts.WithLabelValues("offline").Set(float64(offlineHosts))
+}
+
+// ObserveModelLoad records the duration of a model load and whether it succeeded
+func ObserveModelLoad(model string, d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	modelLoadDuration.WithLabelValues(model, result).Observe(d.Seconds())
+}
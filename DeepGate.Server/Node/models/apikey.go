@@ -0,0 +1,32 @@
+package models
+
+// APIKey is the record of a client credential. The secret itself is never
+// stored, only its hash.
+type APIKey struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	Prefix            string   `json:"prefix"`               // First characters of the secret, to recognise it
+	AllowedModels     []string `json:"allowed_models"`       // Empty allows every model
+	RequestsPerMinute int      `json:"requests_per_minute"`  // 0 is unlimited
+	TokensPerMinute   int      `json:"tokens_per_minute"`    // 0 is unlimited
+	CreatedAt         int64    `json:"created_at"`           // Unix seconds
+	RevokedAt         int64    `json:"revoked_at,omitempty"` // Unix seconds, 0 while the key is valid
+}
+
+// Revoked reports whether the key has been revoked
+func (k APIKey) Revoked() bool {
+	return k.RevokedAt != 0
+}
+
+// AllowsModel reports whether the key may use a model
+func (k APIKey) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 {
+		return true
+	}
+	for _, allowed := range k.AllowedModels {
+		if allowed == model {
+			return true
+		}
+	}
+	return false
+}
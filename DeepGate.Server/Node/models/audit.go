@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// AuditRecord is written for every chat and model load served by the Node
+type AuditRecord struct {
+	ID               string                `json:"id" bson:"_id"`
+	Time             time.Time             `json:"time" bson:"time"` // When the request arrived, records expire relative to it
+	Route            string                `json:"route" bson:"route"`
+	RequestID        string                `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Caller           string                `json:"caller" bson:"caller"` // API key ID, or the client IP without one
+	APIKeyID         string                `json:"api_key_id,omitempty" bson:"api_key_id,omitempty"`
+	ClientIP         string                `json:"client_ip" bson:"client_ip"`
+	Model            string                `json:"model,omitempty" bson:"model,omitempty"`
+	HostIP           string                `json:"host_ip,omitempty" bson:"host_ip,omitempty"` // Host that answered
+	ConversationID   string                `json:"conversation_id,omitempty" bson:"conversation_id,omitempty"`
+	Status           int                   `json:"status" bson:"status"`
+	Error            string                `json:"error,omitempty" bson:"error,omitempty"` // Set when a stream failed after it started
+	LatencyMs        int64                 `json:"latency_ms" bson:"latency_ms"`
+	PromptTokens     int                   `json:"prompt_tokens" bson:"prompt_tokens"`
+	CompletionTokens int                   `json:"completion_tokens" bson:"completion_tokens"`
+	Prompt           []databinding.Message `json:"prompt,omitempty" bson:"prompt,omitempty"`     // Only stored when bodies are audited
+	Response         string                `json:"response,omitempty" bson:"response,omitempty"` // Only stored when bodies are audited
+	Redacted         bool                  `json:"redacted,omitempty" bson:"redacted,omitempty"` // Bodies were removed after the fact
+}
+
+// AuditQuery selects audit records, zero fields match every record
+type AuditQuery struct {
+	From   time.Time
+	To     time.Time
+	Model  string
+	Caller string
+	Limit  int64
+}
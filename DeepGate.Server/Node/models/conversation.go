@@ -0,0 +1,21 @@
+package models
+
+// Conversation is a chat history stored on the Node. Chats sent with its ID
+// continue it and append their turns to it.
+type Conversation struct {
+	ID        string                `json:"id" bson:"_id"`
+	Owner     string                `json:"-" bson:"owner"` // ID of the API key the conversation belongs to, "" without authentication
+	Title     string                `json:"title" bson:"title"`
+	Model     string                `json:"model,omitempty" bson:"model,omitempty"` // Model of the last chat
+	Messages  []ConversationMessage `json:"messages,omitempty" bson:"messages"`
+	CreatedAt int64                 `json:"created_at" bson:"created_at"` // Unix seconds
+	UpdatedAt int64                 `json:"updated_at" bson:"updated_at"` // Unix seconds
+}
+
+// ConversationMessage is a single turn of a conversation
+type ConversationMessage struct {
+	Role      string `json:"role" bson:"role"`
+	Content   string `json:"content" bson:"content"`
+	Model     string `json:"model,omitempty" bson:"model,omitempty"` // Model that wrote an assistant message
+	CreatedAt int64  `json:"created_at" bson:"created_at"`           // Unix seconds
+}
@@ -46,11 +46,13 @@ func ParseModelResponse(data []byte) (*HostModelInfoResponse, error) {
 
 // Base unit of deepgate-service-cluster
 type LLMHost struct {
-	IPAdd     string                  `json:"ip_add"`
-	HostInfo  databinding.InfoPackage `json:"host_info"`
-	ModelInfo []HostModelInfo         `json:"model_info"`
-	Status    bool                    `json:"status"`
-	TaskCount int                     `json:"task_count"`
+	IPAdd         string                  `json:"ip_add"`
+	HostInfo      databinding.InfoPackage `json:"host_info"`
+	ModelInfo     []HostModelInfo         `json:"model_info"`
+	Status        bool                    `json:"status"`
+	TaskCount     int                     `json:"task_count"`
+	LastHeartbeat int64                   `json:"last_heartbeat"`  // Unix timestamp of the last heartbeat
+	Token         string                  `json:"token,omitempty"` // Host token the Node calls the host with
 }
 
 type LLModel struct {
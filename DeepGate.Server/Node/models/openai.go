@@ -0,0 +1,335 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// OpenAI compatible request/response types for the /v1 gateway
+// START
+type OpenAIChatRequest struct {
+	Model               string                `json:"model"`
+	Messages            []OpenAIMessage       `json:"messages"`
+	Stream              bool                  `json:"stream"`
+	Temperature         *float64              `json:"temperature,omitempty"`
+	TopP                *float64              `json:"top_p,omitempty"`
+	MaxTokens           *int                  `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                  `json:"max_completion_tokens,omitempty"`
+	Seed                *int                  `json:"seed,omitempty"`
+	Stop                OpenAIStop            `json:"stop,omitempty"`
+	PresencePenalty     *float64              `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64              `json:"frequency_penalty,omitempty"`
+	ResponseFormat      *OpenAIResponseFormat `json:"response_format,omitempty"`
+	StreamOptions       *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+}
+
+// OpenAIStreamOptions asks for a final chunk carrying the usage
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIStop accepts both a single stop sequence and a list of them
+type OpenAIStop []string
+
+func (s *OpenAIStop) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+
+	var stop string
+	if err := json.Unmarshal(data, &stop); err == nil {
+		*s = OpenAIStop{stop}
+		return nil
+	}
+
+	var stops []string
+	if err := json.Unmarshal(data, &stops); err != nil {
+		return fmt.Errorf("unsupported stop: %v", err)
+	}
+	*s = stops
+	return nil
+}
+
+// OpenAIResponseFormat selects plain text, any JSON object or a JSON schema
+type OpenAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema,omitempty"`
+}
+
+// OpenAIMessage represents a single message in an OpenAI chat request
+type OpenAIMessage struct {
+	Role    string        `json:"role"`
+	Content OpenAIContent `json:"content"`
+}
+
+// OpenAIContent accepts both the plain string form and the array of content
+// parts form of an OpenAI message content field. Only text parts are kept.
+type OpenAIContent string
+
+func (oc *OpenAIContent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*oc = ""
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*oc = OpenAIContent(text)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("unsupported message content: %v", err)
+	}
+
+	var builder strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" {
+			builder.WriteString(part.Text)
+		}
+	}
+	*oc = OpenAIContent(builder.String())
+	return nil
+}
+
+type OpenAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *OpenAIResponseMessage `json:"message,omitempty"`
+	Delta        *OpenAIDelta           `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+type OpenAIResponseMessage struct {
+	Role             string           `json:"role"`
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAIDelta struct {
+	Role             string           `json:"role,omitempty"`
+	Content          string           `json:"content,omitempty"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	Index    int                    `json:"index"`
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Function OpenAIToolCallFunction `json:"function"`
+}
+
+// OpenAIToolCallFunction carries the arguments as a JSON encoded string
+type OpenAIToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// END
+
+// ToChatCompletion converts an OpenAI chat request into the internal ChatCompletion
+func (r OpenAIChatRequest) ToChatCompletion() databinding.ChatCompletion {
+	messages := make([]databinding.Message, len(r.Messages))
+	for i, message := range r.Messages {
+		messages[i] = databinding.Message{
+			Role:    message.Role,
+			Content: string(message.Content),
+		}
+	}
+
+	chat := databinding.ChatCompletion{
+		Model:    r.Model,
+		Messages: messages,
+	}
+
+	maxTokens := r.MaxTokens
+	if r.MaxCompletionTokens != nil {
+		maxTokens = r.MaxCompletionTokens
+	}
+
+	options := databinding.ChatOptions{
+		Temperature:      r.Temperature,
+		TopP:             r.TopP,
+		NumPredict:       maxTokens,
+		Seed:             r.Seed,
+		Stop:             r.Stop,
+		PresencePenalty:  r.PresencePenalty,
+		FrequencyPenalty: r.FrequencyPenalty,
+	}
+	if options.Temperature != nil || options.TopP != nil || options.NumPredict != nil || options.Seed != nil ||
+		len(options.Stop) > 0 || options.PresencePenalty != nil || options.FrequencyPenalty != nil {
+		chat.Options = &options
+	}
+
+	if r.ResponseFormat != nil {
+		switch r.ResponseFormat.Type {
+		case "json_object":
+			chat.Format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if r.ResponseFormat.JSONSchema != nil {
+				chat.Format = r.ResponseFormat.JSONSchema.Schema
+			}
+		}
+	}
+
+	return chat
+}
+
+// NewOpenAIChatResponse builds a non-streaming chat.completion object
+func NewOpenAIChatResponse(id, model string, created int64, message OpenAIResponseMessage, finishReason string) OpenAIChatResponse {
+	message.Role = "assistant"
+
+	return OpenAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []OpenAIChoice{{
+			Index:        0,
+			Message:      &message,
+			FinishReason: &finishReason,
+		}},
+	}
+}
+
+// NewOpenAIChatChunk builds a single chat.completion.chunk object for streaming.
+// An empty finishReason is encoded as null.
+func NewOpenAIChatChunk(id, model string, created int64, delta OpenAIDelta, finishReason string) OpenAIChatResponse {
+	choice := OpenAIChoice{
+		Index: 0,
+		Delta: &delta,
+	}
+	if finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+
+	return OpenAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []OpenAIChoice{choice},
+	}
+}
+
+// NewOpenAIUsageChunk builds the final chunk of a stream that asked for usage.
+// It has no choices, as in OpenAI's own streams.
+func NewOpenAIUsageChunk(id, model string, created int64, usage databinding.Usage) OpenAIChatResponse {
+	return OpenAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []OpenAIChoice{},
+		Usage:   ConvertUsageToOpenAI(usage),
+	}
+}
+
+// ConvertToolCallsToOpenAI converts tool calls from the stream protocol,
+// numbering them from offset so calls spread over several events stay distinct
+func ConvertToolCallsToOpenAI(completionID string, toolCalls []databinding.ToolCall, offset int) []OpenAIToolCall {
+	converted := make([]OpenAIToolCall, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		arguments, err := json.Marshal(toolCall.Function.Arguments)
+		if err != nil || toolCall.Function.Arguments == nil {
+			arguments = []byte("{}")
+		}
+
+		converted[i] = OpenAIToolCall{
+			Index: offset + i,
+			ID:    fmt.Sprintf("call_%s_%d", strings.TrimPrefix(completionID, "chatcmpl-"), offset+i),
+			Type:  "function",
+			Function: OpenAIToolCallFunction{
+				Name:      toolCall.Function.Name,
+				Arguments: string(arguments),
+			},
+		}
+	}
+	return converted
+}
+
+// OpenAIFinishReason maps the done reason of a stream to an OpenAI finish_reason
+func OpenAIFinishReason(doneReason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	if doneReason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// ConvertUsageToOpenAI converts Ollama's eval counts to OpenAI token usage
+func ConvertUsageToOpenAI(usage databinding.Usage) *OpenAIUsage {
+	return &OpenAIUsage{
+		PromptTokens:     usage.PromptEvalCount,
+		CompletionTokens: usage.EvalCount,
+		TotalTokens:      usage.PromptEvalCount + usage.EvalCount,
+	}
+}
+
+// ConvertLLModelsToOpenAI converts the registry models to an OpenAI model list
+func ConvertLLModelsToOpenAI(llModels []LLModel) OpenAIModelList {
+	data := make([]OpenAIModel, len(llModels))
+	for i, model := range llModels {
+		data[i] = OpenAIModel{
+			ID:      model.Modelinfo.Name,
+			Object:  "model",
+			OwnedBy: "deepgate",
+		}
+	}
+
+	return OpenAIModelList{
+		Object: "list",
+		Data:   data,
+	}
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	databinding "Pkgs/DataBinding"
+)
+
+// decodeRequest decodes an OpenAI chat request from its JSON body
+func decodeRequest(t *testing.T, body string) OpenAIChatRequest {
+	t.Helper()
+	var request OpenAIChatRequest
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	return request
+}
+
+func TestOpenAIStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    OpenAIStop
+		wantErr bool
+	}{
+		{name: "absent", body: `{}`, want: nil},
+		{name: "null", body: `{"stop":null}`, want: nil},
+		{name: "string", body: `{"stop":"\n\n"}`, want: OpenAIStop{"\n\n"}},
+		{name: "list", body: `{"stop":["END","###"]}`, want: OpenAIStop{"END", "###"}},
+		{name: "empty list", body: `{"stop":[]}`, want: OpenAIStop{}},
+		{name: "number", body: `{"stop":3}`, wantErr: true},
+		{name: "list of numbers", body: `{"stop":[1,2]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request OpenAIChatRequest
+			err := json.Unmarshal([]byte(tt.body), &request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(request.Stop, tt.want) {
+				t.Errorf("stop = %#v, want %#v", request.Stop, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    OpenAIContent
+		wantErr bool
+	}{
+		{name: "string", content: `"Hello"`, want: "Hello"},
+		{name: "null", content: `null`, want: ""},
+		{name: "text parts", content: `[{"type":"text","text":"Hello "},{"type":"text","text":"world"}]`, want: "Hello world"},
+		{name: "non-text parts are dropped", content: `[{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"Hi"}]`, want: "Hi"},
+		{name: "number", content: `42`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message OpenAIMessage
+			err := json.Unmarshal([]byte(`{"role":"user","content":`+tt.content+`}`), &message)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && message.Content != tt.want {
+				t.Errorf("content = %q, want %q", message.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestToChatCompletion(t *testing.T) {
+	messages := []databinding.Message{{Role: "user", Content: "Hi"}}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantOptions *databinding.ChatOptions
+		wantFormat  string
+	}{
+		{
+			name: "no options",
+			body: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:        "stop string",
+			body:        `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stop":"END"}`,
+			wantOptions: &databinding.ChatOptions{Stop: []string{"END"}},
+		},
+		{
+			name:        "stop list",
+			body:        `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stop":["END","###"]}`,
+			wantOptions: &databinding.ChatOptions{Stop: []string{"END", "###"}},
+		},
+		{
+			name:        "max_tokens",
+			body:        `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"max_tokens":64}`,
+			wantOptions: &databinding.ChatOptions{NumPredict: intPtr(64)},
+		},
+		{
+			name:        "max_completion_tokens wins over max_tokens",
+			body:        `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"max_tokens":64,"max_completion_tokens":128}`,
+			wantOptions: &databinding.ChatOptions{NumPredict: intPtr(128)},
+		},
+		{
+			name: "sampling options",
+			body: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"temperature":0.2,"top_p":0.9,"seed":7,"presence_penalty":1,"frequency_penalty":-1}`,
+			wantOptions: &databinding.ChatOptions{
+				Temperature:      floatPtr(0.2),
+				TopP:             floatPtr(0.9),
+				Seed:             intPtr(7),
+				PresencePenalty:  floatPtr(1),
+				FrequencyPenalty: floatPtr(-1),
+			},
+		},
+		{
+			name: "text response format",
+			body: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"text"}}`,
+		},
+		{
+			name:       "json_object response format",
+			body:       `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"json_object"}}`,
+			wantFormat: `"json"`,
+		},
+		{
+			name:       "json_schema response format",
+			body:       `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"json_schema","json_schema":{"name":"answer","schema":{"type":"object"}}}}`,
+			wantFormat: `{"type":"object"}`,
+		},
+		{
+			name: "json_schema without schema",
+			body: `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"json_schema"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := decodeRequest(t, tt.body).ToChatCompletion()
+
+			if chat.Model != "llama3" || !reflect.DeepEqual(chat.Messages, messages) {
+				t.Errorf("model and messages = %q %+v", chat.Model, chat.Messages)
+			}
+			if !reflect.DeepEqual(chat.Options, tt.wantOptions) {
+				t.Errorf("options = %+v, want %+v", chat.Options, tt.wantOptions)
+			}
+			if string(chat.Format) != tt.wantFormat {
+				t.Errorf("format = %s, want %s", chat.Format, tt.wantFormat)
+			}
+			if err := chat.Validate(); err != nil {
+				t.Errorf("translated request is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenAIFinishReason(t *testing.T) {
+	tests := []struct {
+		doneReason   string
+		hasToolCalls bool
+		want         string
+	}{
+		{"stop", false, "stop"},
+		{"", false, "stop"},
+		{"load", false, "stop"},
+		{"length", false, "length"},
+		{"stop", true, "tool_calls"},
+		{"length", true, "tool_calls"},
+	}
+
+	for _, tt := range tests {
+		if got := OpenAIFinishReason(tt.doneReason, tt.hasToolCalls); got != tt.want {
+			t.Errorf("OpenAIFinishReason(%q, %v) = %q, want %q", tt.doneReason, tt.hasToolCalls, got, tt.want)
+		}
+	}
+}
+
+func TestConvertToolCallsToOpenAI(t *testing.T) {
+	const completionID = "chatcmpl-abc"
+	first := []databinding.ToolCall{
+		{Function: databinding.ToolCallFunction{Name: "weather", Arguments: map[string]interface{}{"city": "Paris"}}},
+		{Function: databinding.ToolCallFunction{Name: "time"}},
+	}
+	second := []databinding.ToolCall{
+		{Function: databinding.ToolCallFunction{Name: "news", Arguments: map[string]interface{}{}}},
+	}
+
+	// Calls spread over two stream events keep counting up
+	got := append(ConvertToolCallsToOpenAI(completionID, first, 0), ConvertToolCallsToOpenAI(completionID, second, len(first))...)
+	want := []OpenAIToolCall{
+		{Index: 0, ID: "call_abc_0", Type: "function", Function: OpenAIToolCallFunction{Name: "weather", Arguments: `{"city":"Paris"}`}},
+		{Index: 1, ID: "call_abc_1", Type: "function", Function: OpenAIToolCallFunction{Name: "time", Arguments: `{}`}},
+		{Index: 2, ID: "call_abc_2", Type: "function", Function: OpenAIToolCallFunction{Name: "news", Arguments: `{}`}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tool calls = %+v, want %+v", got, want)
+	}
+
+	if converted := ConvertToolCallsToOpenAI(completionID, nil, 0); len(converted) != 0 {
+		t.Errorf("no tool calls converted to %+v", converted)
+	}
+}
+
+func TestOpenAIChunks(t *testing.T) {
+	chunk := NewOpenAIChatChunk("chatcmpl-abc", "llama3", 1700000000, OpenAIDelta{Content: "Hi"}, "")
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"finish_reason":null`) || chunk.Object != "chat.completion.chunk" {
+		t.Errorf("content chunk = %s", data)
+	}
+
+	chunk = NewOpenAIChatChunk("chatcmpl-abc", "llama3", 1700000000, OpenAIDelta{}, "length")
+	if reason := chunk.Choices[0].FinishReason; reason == nil || *reason != "length" {
+		t.Errorf("final chunk finish_reason = %v, want length", reason)
+	}
+
+	usage := databinding.Usage{PromptEvalCount: 12, EvalCount: 30}
+	chunk = NewOpenAIUsageChunk("chatcmpl-abc", "llama3", 1700000000, usage)
+	data, err = json.Marshal(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"chatcmpl-abc","object":"chat.completion.chunk","created":1700000000,"model":"llama3","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":30,"total_tokens":42}}`
+	if string(data) != want {
+		t.Errorf("usage chunk = %s, want %s", data, want)
+	}
+}
+
+func TestNewOpenAIChatResponse(t *testing.T) {
+	message := OpenAIResponseMessage{Content: "Hi", ReasoningContent: "greet"}
+	response := NewOpenAIChatResponse("chatcmpl-abc", "llama3", 1700000000, message, "stop")
+	response.Usage = ConvertUsageToOpenAI(databinding.Usage{PromptEvalCount: 5, EvalCount: 2})
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"chatcmpl-abc","object":"chat.completion","created":1700000000,"model":"llama3","choices":[{"index":0,"message":{"role":"assistant","content":"Hi","reasoning_content":"greet"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`
+	if string(data) != want {
+		t.Errorf("response = %s, want %s", data, want)
+	}
+}
+
+func TestConvertLLModelsToOpenAI(t *testing.T) {
+	list := ConvertLLModelsToOpenAI([]LLModel{{Modelinfo: HostModelInfo{Name: "llama3:8b"}}, {Modelinfo: HostModelInfo{Name: "qwen2:1.5b"}}})
+	want := OpenAIModelList{Object: "list", Data: []OpenAIModel{
+		{ID: "llama3:8b", Object: "model", OwnedBy: "deepgate"},
+		{ID: "qwen2:1.5b", Object: "model", OwnedBy: "deepgate"},
+	}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("model list = %+v, want %+v", list, want)
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+func floatPtr(v float64) *float64 { return &v }
@@ -0,0 +1,23 @@
+package models
+
+// Usage is accumulated along these dimensions
+const (
+	UsageByModel  = "model"
+	UsageByHost   = "host"
+	UsageByCaller = "caller"
+)
+
+// UsageDimensions lists every dimension usage is accumulated along
+var UsageDimensions = []string{UsageByModel, UsageByHost, UsageByCaller}
+
+// UsageStats are the accumulated token counts and timings of completed chats,
+// durations are in nanoseconds
+type UsageStats struct {
+	Requests           int64 `json:"requests"`
+	PromptTokens       int64 `json:"prompt_tokens"`
+	CompletionTokens   int64 `json:"completion_tokens"`
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
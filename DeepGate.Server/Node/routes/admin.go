@@ -0,0 +1,235 @@
+package routes
+
+import (
+	"log/slog"
+	"net/http"
+	"node/clients"
+	"node/logic"
+	"node/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the number of audit records returned by a query
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AdminHandler struct {
+	logger *slog.Logger
+	redis  *clients.RedisClient
+	mongo  *clients.MongoClient
+	auth   *Authenticator
+}
+
+func NewAdminHandler(logger *slog.Logger, redis *clients.RedisClient, mongo *clients.MongoClient, auth *Authenticator) *AdminHandler {
+	return &AdminHandler{
+		logger: logger,
+		redis:  redis,
+		mongo:  mongo,
+		auth:   auth,
+	}
+}
+
+// RegisterRoutes registers all admin routes
+func (a *AdminHandler) RegisterRoutes(router *gin.Engine) {
+	admin := router.Group("/admin", a.auth.RequireAdmin())
+	admin.GET("/tasks", a.handleTaskCounts)
+	admin.GET("/usage", a.handleUsage)
+	admin.GET("/keys", a.handleListKeys)
+	admin.POST("/keys", a.handleCreateKey)
+	admin.DELETE("/keys/:id", a.handleRevokeKey)
+	admin.GET("/audit", a.handleQueryAudit)
+	admin.POST("/audit/:id/redact", a.handleRedactAudit)
+}
+
+// handleTaskCounts returns the in-flight task count of every host
+func (a *AdminHandler) handleTaskCounts(c *gin.Context) {
+	counts, err := a.redis.GetHostTaskCounts(c.Request.Context())
+	if err != nil {
+		a.logger.ErrorContext(c.Request.Context(), "Failed to fetch task counts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch task counts"})
+		return
+	}
+
+	hosts, err := a.redis.GetAllLLMHosts(c.Request.Context())
+	if err != nil {
+		a.logger.ErrorContext(c.Request.Context(), "Failed to fetch hosts", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hosts"})
+		return
+	}
+
+	// Report idle hosts explicitly with a zero count
+	for _, host := range hosts {
+		if _, ok := counts[host.IPAdd]; !ok {
+			counts[host.IPAdd] = 0
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tasks": counts})
+}
+
+// handleUsage returns the accumulated usage per model, host and caller, or only
+// for the dimension given in the "by" query parameter
+func (a *AdminHandler) handleUsage(c *gin.Context) {
+	dimensions := models.UsageDimensions
+	if by := c.Query("by"); by != "" {
+		if by != models.UsageByModel && by != models.UsageByHost && by != models.UsageByCaller {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "by must be one of model, host or caller"})
+			return
+		}
+		dimensions = []string{by}
+	}
+
+	usage := make(map[string]map[string]models.UsageStats, len(dimensions))
+	for _, dimension := range dimensions {
+		stats, err := a.redis.GetUsage(c.Request.Context(), dimension)
+		if err != nil {
+			a.logger.ErrorContext(c.Request.Context(), "Failed to fetch usage", "dimension", dimension, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		usage[dimension] = stats
+	}
+
+	c.JSON(http.StatusOK, gin.H{"usage": usage})
+}
+
+// handleListKeys returns every API key, revoked ones included
+func (a *AdminHandler) handleListKeys(c *gin.Context) {
+	keys, err := a.redis.GetAllAPIKeys(c.Request.Context())
+	if err != nil {
+		a.logger.ErrorContext(c.Request.Context(), "Failed to fetch API keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// handleCreateKey creates an API key and returns its secret, which is only
+// ever shown in this response
+func (a *AdminHandler) handleCreateKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request struct {
+		Name              string   `json:"name" binding:"required"`
+		AllowedModels     []string `json:"allowed_models"`
+		RequestsPerMinute int      `json:"requests_per_minute" binding:"min=0"`
+		TokensPerMinute   int      `json:"tokens_per_minute" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		a.logger.WarnContext(ctx, "Invalid API key request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	secret, key, err := logic.CreateAPIKey(ctx, models.APIKey{
+		Name:              request.Name,
+		AllowedModels:     request.AllowedModels,
+		RequestsPerMinute: request.RequestsPerMinute,
+		TokensPerMinute:   request.TokensPerMinute,
+	}, a.redis)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "Failed to create API key", "name", request.Name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	a.logger.InfoContext(ctx, "Created API key", "api_key", key.ID, "name", key.Name)
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     secret,
+		"api_key": key,
+	})
+}
+
+// handleRevokeKey revokes an API key so that it no longer authenticates
+func (a *AdminHandler) handleRevokeKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	key, err := a.redis.RevokeAPIKey(ctx, id)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "Failed to revoke API key", "api_key", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	a.logger.InfoContext(ctx, "Revoked API key", "api_key", key.ID, "name", key.Name)
+	c.JSON(http.StatusOK, gin.H{"api_key": key})
+}
+
+// handleQueryAudit returns the audit records matching the from, to, model and
+// caller query parameters, newest first. Times are RFC 3339 or Unix seconds.
+func (a *AdminHandler) handleQueryAudit(c *gin.Context) {
+	ctx := c.Request.Context()
+	query := models.AuditQuery{
+		Model:  c.Query("model"),
+		Caller: c.Query("caller"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var err error
+	if query.From, err = parseAuditTime(c.Query("from")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 time or Unix seconds"})
+		return
+	}
+	if query.To, err = parseAuditTime(c.Query("to")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 time or Unix seconds"})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		query.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil || query.Limit < 1 || query.Limit > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxAuditLimit)})
+			return
+		}
+	}
+
+	records, err := a.mongo.QueryAuditRecords(ctx, query)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "Failed to query audit records", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit records"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"records": records})
+}
+
+// handleRedactAudit removes the prompt and response of an audit record
+func (a *AdminHandler) handleRedactAudit(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	found, err := a.mongo.RedactAuditRecord(ctx, id)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "Failed to redact audit record", "record", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redact audit record"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit record not found"})
+		return
+	}
+
+	a.logger.InfoContext(ctx, "Redacted audit record", "record", id)
+	c.JSON(http.StatusOK, gin.H{"status": "redacted"})
+}
+
+// parseAuditTime parses an RFC 3339 time or Unix seconds, "" is the zero time
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
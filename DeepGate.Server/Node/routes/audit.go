@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"time"
+
+	"node/logic"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditContextKey holds the audit record of a request in the gin context
+const auditContextKey = "deepgate.audit"
+
+// Audit writes an audit record once a request has been served. Handlers fill
+// in what they learn about the request through auditRecord.
+func Audit(auditor *logic.Auditor, route string) gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		start := time.Now()
+		record := &models.AuditRecord{
+			Time:      start.UTC(),
+			Route:     route,
+			RequestID: databinding.RequestID(gc.Request.Context()),
+			ClientIP:  gc.ClientIP(),
+		}
+		gc.Set(auditContextKey, record)
+
+		gc.Next()
+
+		// The API key is only known once the request has been authenticated
+		record.Caller = callerID(gc)
+		if key := apiKey(gc); key != nil {
+			record.APIKeyID = key.ID
+		}
+		record.Status = gc.Writer.Status()
+		record.LatencyMs = time.Since(start).Milliseconds()
+		auditor.Record(gc.Request.Context(), *record)
+	}
+}
+
+// auditRecord returns the audit record of a request, a throwaway record when
+// the route is not audited
+func auditRecord(gc *gin.Context) *models.AuditRecord {
+	if value, ok := gc.Get(auditContextKey); ok {
+		if record, ok := value.(*models.AuditRecord); ok {
+			return record
+		}
+	}
+	return &models.AuditRecord{}
+}
+
+// auditUsage adds the token counts of a completed chat to its audit record
+func auditUsage(gc *gin.Context, usage databinding.Usage) {
+	record := auditRecord(gc)
+	record.PromptTokens = usage.PromptEvalCount
+	record.CompletionTokens = usage.EvalCount
+}
@@ -0,0 +1,229 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"node/clients"
+	"node/logic"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyContextKey holds the authenticated API key in the gin context
+const apiKeyContextKey = "deepgate.api_key"
+
+// hostIPContextKey holds the address of the authenticated host in the gin context
+const hostIPContextKey = "deepgate.host_ip"
+
+// errorWriter responds with an error in the format of a route group
+type errorWriter func(gc *gin.Context, status int, errorType, message string)
+
+// writeNodeError responds with an error in the format of the /node routes
+func writeNodeError(gc *gin.Context, status int, _ string, message string) {
+	gc.JSON(status, gin.H{"error": message})
+}
+
+// Authenticator guards the client routes with API keys and the admin routes
+// with the admin token. In open access mode authentication is disabled.
+// Hosts enroll with the cluster secret and authenticate with host tokens,
+// unless no cluster secret is configured.
+type Authenticator struct {
+	logger        *slog.Logger
+	redis         *clients.RedisClient
+	adminToken    string
+	clusterSecret string
+	openAccess    bool
+}
+
+func NewAuthenticator(logger *slog.Logger, redis *clients.RedisClient, adminToken, clusterSecret string, openAccess bool) *Authenticator {
+	return &Authenticator{
+		logger:        logger,
+		redis:         redis,
+		adminToken:    adminToken,
+		clusterSecret: clusterSecret,
+		openAccess:    openAccess,
+	}
+}
+
+// Enabled reports whether requests have to authenticate
+func (a *Authenticator) Enabled() bool {
+	return !a.openAccess
+}
+
+// RequireAPIKey rejects requests without a valid API key with 401 and
+// requests over the key's rate limits with 429
+func (a *Authenticator) RequireAPIKey(writeError errorWriter) gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		if !a.Enabled() {
+			gc.Next()
+			return
+		}
+		ctx := gc.Request.Context()
+
+		secret := bearerToken(gc)
+		if secret == "" {
+			gc.Header("WWW-Authenticate", "Bearer")
+			writeError(gc, http.StatusUnauthorized, "authentication_error", "Missing API key")
+			gc.Abort()
+			return
+		}
+
+		key, err := logic.AuthenticateAPIKey(ctx, secret, a.redis)
+		if err != nil {
+			if errors.Is(err, logic.ErrInvalidAPIKey) {
+				a.logger.WarnContext(ctx, "Rejected invalid API key", "client_ip", gc.ClientIP())
+				gc.Header("WWW-Authenticate", "Bearer")
+				writeError(gc, http.StatusUnauthorized, "authentication_error", "Invalid API key")
+			} else {
+				a.logger.ErrorContext(ctx, "Failed to authenticate API key", "error", err)
+				writeError(gc, http.StatusInternalServerError, "server_error", "Failed to authenticate request")
+			}
+			gc.Abort()
+			return
+		}
+
+		if err := logic.AdmitRequest(ctx, key, a.redis); err != nil {
+			var limitErr *logic.RateLimitError
+			if errors.As(err, &limitErr) {
+				a.logger.InfoContext(ctx, "API key rate limited", "api_key", key.ID, "limit", limitErr.Limit)
+				gc.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+				writeError(gc, http.StatusTooManyRequests, "rate_limit_error", "Rate limit exceeded: "+limitErr.Error())
+			} else {
+				a.logger.ErrorContext(ctx, "Failed to check rate limits", "api_key", key.ID, "error", err)
+				writeError(gc, http.StatusInternalServerError, "server_error", "Failed to authenticate request")
+			}
+			gc.Abort()
+			return
+		}
+
+		gc.Set(apiKeyContextKey, key)
+		gc.Next()
+	}
+}
+
+// RequireAdmin rejects requests without the admin token with 401
+func (a *Authenticator) RequireAdmin() gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		if !a.Enabled() {
+			gc.Next()
+			return
+		}
+
+		// An empty admin token never matches, admin routes stay closed
+		token := bearerToken(gc)
+		if a.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			gc.Header("WWW-Authenticate", "Bearer")
+			gc.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		gc.Next()
+	}
+}
+
+// HostAuthEnabled reports whether hosts have to enroll and authenticate
+func (a *Authenticator) HostAuthEnabled() bool {
+	return a.clusterSecret != ""
+}
+
+// VerifyEnrollment checks that a registering host signed its package with
+// the cluster secret
+func (a *Authenticator) VerifyEnrollment(infoPackage databinding.InfoPackage) error {
+	if !a.HostAuthEnabled() {
+		return nil
+	}
+	return infoPackage.Verify(a.clusterSecret, time.Now())
+}
+
+// IssueHostToken signs a token for a host, "" when host authentication is
+// disabled
+func (a *Authenticator) IssueHostToken(hostIP string) (string, error) {
+	if !a.HostAuthEnabled() {
+		return "", nil
+	}
+	return databinding.IssueHostToken(a.clusterSecret, hostIP, time.Now())
+}
+
+// RequireHostToken rejects requests from hosts without a valid host token
+// with 401
+func (a *Authenticator) RequireHostToken() gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		if !a.HostAuthEnabled() {
+			gc.Next()
+			return
+		}
+
+		hostToken, err := databinding.VerifyHostToken(a.clusterSecret, bearerToken(gc), time.Now())
+		if err != nil {
+			a.logger.WarnContext(gc.Request.Context(), "Rejected host request", "client_ip", gc.ClientIP(), "error", err)
+			gc.Header("WWW-Authenticate", "Bearer")
+			gc.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid host token"})
+			return
+		}
+
+		gc.Set(hostIPContextKey, hostToken.HostIP)
+		gc.Next()
+	}
+}
+
+// hostAllowed reports whether the request's host token was issued to the
+// host at ipAddress
+func hostAllowed(gc *gin.Context, ipAddress string) bool {
+	hostIP, ok := gc.Get(hostIPContextKey)
+	return !ok || hostIP == ipAddress
+}
+
+// apiKey returns the API key the request authenticated with, nil when
+// authentication is disabled
+func apiKey(gc *gin.Context) *models.APIKey {
+	value, ok := gc.Get(apiKeyContextKey)
+	if !ok {
+		return nil
+	}
+	key, _ := value.(*models.APIKey)
+	return key
+}
+
+// modelAllowed reports whether the request's API key may use a model
+func modelAllowed(gc *gin.Context, model string) bool {
+	key := apiKey(gc)
+	return key == nil || key.AllowsModel(model)
+}
+
+// allowedModels returns the models of llModels the request's API key may use
+func allowedModels(gc *gin.Context, llModels []models.LLModel) []models.LLModel {
+	allowed := make([]models.LLModel, 0, len(llModels))
+	for _, model := range llModels {
+		if modelAllowed(gc, model.Modelinfo.Name) {
+			allowed = append(allowed, model)
+		}
+	}
+	return allowed
+}
+
+// conversationOwner returns the owner of the request's conversations, the ID
+// of its API key or "" when authentication is disabled
+func conversationOwner(gc *gin.Context) string {
+	if key := apiKey(gc); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// bearerToken returns the token of a bearer Authorization header
+func bearerToken(gc *gin.Context) string {
+	scheme, token, found := strings.Cut(gc.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
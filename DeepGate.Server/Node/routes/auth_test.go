@@ -0,0 +1,337 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"node/clients"
+	"node/logic"
+	"node/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const testAdminToken = "admin-secret"
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newTestRedis returns a client of a fresh in-memory Redis
+func newTestRedis(t *testing.T) *clients.RedisClient {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return clients.NewRedisClient(client, time.Minute)
+}
+
+// createKey stores an API key and returns its secret
+func createKey(t *testing.T, rc *clients.RedisClient, key models.APIKey) (string, *models.APIKey) {
+	t.Helper()
+	secret, created, err := logic.CreateAPIKey(context.Background(), key, rc)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return secret, created
+}
+
+// testRouter serves the client routes under test, every protected route
+// answers with the caller it was accounted to
+func testRouter(rc *clients.RedisClient, openAccess bool) *gin.Engine {
+	auth := NewAuthenticator(discardLogger, rc, testAdminToken, "", openAccess)
+	handler := &ClientHandler{logger: discardLogger, redis: rc, auth: auth}
+	whoami := func(gc *gin.Context) { gc.JSON(http.StatusOK, gin.H{"caller": callerID(gc)}) }
+
+	router := gin.New()
+	router.GET("/node/whoami", auth.RequireAPIKey(writeNodeError), whoami)
+	router.GET("/v1/whoami", auth.RequireAPIKey(handler.openAIError), whoami)
+	router.GET("/admin/whoami", auth.RequireAdmin(), whoami)
+	router.GET("/node/fetch-models", auth.RequireAPIKey(writeNodeError), handler.handleFetchModels)
+	router.POST("/node/load-model", auth.RequireAPIKey(writeNodeError), handler.handleClientLoadModel)
+	router.GET("/v1/models", auth.RequireAPIKey(handler.openAIError), handler.handleOpenAIModels)
+	router.POST("/v1/chat/completions", auth.RequireAPIKey(handler.openAIError), handler.handleOpenAIChatCompletions)
+	return router
+}
+
+// serve sends a request with an optional Authorization header
+func serve(router *gin.Engine, method, path, authorization, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.RemoteAddr = "192.0.2.10:40000"
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// nodeError returns the message of a /node error body
+func nodeError(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", recorder.Body, err)
+	}
+	return body.Error
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	rc := newTestRedis(t)
+	router := testRouter(rc, false)
+	secret, key := createKey(t, rc, models.APIKey{Name: "ci"})
+	revokedSecret, revoked := createKey(t, rc, models.APIKey{Name: "old"})
+	if _, err := rc.RevokeAPIKey(context.Background(), revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantError     string
+	}{
+		{"missing key", "", http.StatusUnauthorized, "Missing API key"},
+		{"not a bearer token", "Basic " + secret, http.StatusUnauthorized, "Missing API key"},
+		{"unknown key", "Bearer dg_unknown", http.StatusUnauthorized, "Invalid API key"},
+		{"revoked key", "Bearer " + revokedSecret, http.StatusUnauthorized, "Invalid API key"},
+		{"valid key", "Bearer " + secret, http.StatusOK, ""},
+		{"case insensitive scheme", "bearer " + secret, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(router, "GET", "/node/whoami", tt.authorization, "")
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				if recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Error("401 without WWW-Authenticate: Bearer")
+				}
+				if got := nodeError(t, recorder); got != tt.wantError {
+					t.Errorf("error = %q, want %q", got, tt.wantError)
+				}
+				return
+			}
+
+			// Requests are accounted to the key ID
+			var body struct {
+				Caller string `json:"caller"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if body.Caller != key.ID {
+				t.Errorf("caller = %q, want key ID %q", body.Caller, key.ID)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyOpenAIErrors(t *testing.T) {
+	router := testRouter(newTestRedis(t), false)
+
+	recorder := serve(router, "GET", "/v1/whoami", "Bearer dg_unknown", "")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", recorder.Code)
+	}
+	var body models.OpenAIErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Type != "authentication_error" || body.Error.Message != "Invalid API key" {
+		t.Errorf("error = %+v, want an OpenAI authentication_error", body.Error)
+	}
+}
+
+func TestRequireAPIKeyRateLimits(t *testing.T) {
+	rc := newTestRedis(t)
+	router := testRouter(rc, false)
+
+	t.Run("requests per minute", func(t *testing.T) {
+		secret, _ := createKey(t, rc, models.APIKey{Name: "rpm", RequestsPerMinute: 2})
+		var recorder *httptest.ResponseRecorder
+		for i := 0; i < 3; i++ {
+			recorder = serve(router, "GET", "/node/whoami", "Bearer "+secret, "")
+		}
+		// The window may turn between requests, then the third one passes
+		if recorder.Code == http.StatusOK {
+			t.Skip("rate window turned during the test")
+		}
+		checkRateLimited(t, recorder, "requests per minute limit exceeded")
+	})
+
+	t.Run("tokens per minute", func(t *testing.T) {
+		secret, key := createKey(t, rc, models.APIKey{Name: "tpm", TokensPerMinute: 100})
+		if recorder := serve(router, "GET", "/node/whoami", "Bearer "+secret, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("status before any tokens = %d", recorder.Code)
+		}
+		logic.ConsumeTokens(context.Background(), key, 100, rc, discardLogger)
+		recorder := serve(router, "GET", "/node/whoami", "Bearer "+secret, "")
+		if recorder.Code == http.StatusOK {
+			t.Skip("rate window turned during the test")
+		}
+		checkRateLimited(t, recorder, "tokens per minute limit exceeded")
+	})
+}
+
+// checkRateLimited checks a 429 response and its Retry-After header
+func checkRateLimited(t *testing.T, recorder *httptest.ResponseRecorder, wantError string) {
+	t.Helper()
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429: %s", recorder.Code, recorder.Body)
+	}
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", recorder.Header().Get("Retry-After"))
+	}
+	if got := nodeError(t, recorder); !strings.Contains(got, wantError) {
+		t.Errorf("error = %q, want it to mention %q", got, wantError)
+	}
+}
+
+func TestOpenAccess(t *testing.T) {
+	router := testRouter(newTestRedis(t), true)
+
+	for _, path := range []string{"/node/whoami", "/admin/whoami"} {
+		recorder := serve(router, "GET", path, "", "")
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s in open access = %d, want 200", path, recorder.Code)
+		}
+	}
+
+	// Without a key, requests are accounted to the client address and
+	// caller headers are ignored
+	request := httptest.NewRequest("GET", "/node/whoami", nil)
+	request.RemoteAddr = "192.0.2.10:40000"
+	request.Header.Set("X-Caller-ID", "someone-else")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), `"caller":"192.0.2.10"`) {
+		t.Errorf("open access caller = %s, want the client address", recorder.Body)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	rc := newTestRedis(t)
+	router := testRouter(rc, false)
+	secret, _ := createKey(t, rc, models.APIKey{Name: "ci"})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"API key", "Bearer " + secret, http.StatusUnauthorized},
+		{"admin token", "Bearer " + testAdminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(router, "GET", "/admin/whoami", tt.authorization, "")
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireAdminWithoutToken(t *testing.T) {
+	auth := NewAuthenticator(discardLogger, newTestRedis(t), "", "", false)
+	router := gin.New()
+	router.GET("/admin/whoami", auth.RequireAdmin(), func(gc *gin.Context) { gc.Status(http.StatusOK) })
+
+	// An unset admin token must not match an empty bearer token
+	for _, authorization := range []string{"", "Bearer ", "Bearer x"} {
+		if recorder := serve(router, "GET", "/admin/whoami", authorization, ""); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q = %d, want 401", authorization, recorder.Code)
+		}
+	}
+}
+
+func TestModelRestrictions(t *testing.T) {
+	ctx := context.Background()
+	rc := newTestRedis(t)
+	router := testRouter(rc, false)
+	for _, name := range []string{"llama3:8b", "mistral:7b", "qwen2:1.5b"} {
+		if err := rc.AddHostingServer(ctx, models.HostModelInfo{Name: name}, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restricted, _ := createKey(t, rc, models.APIKey{Name: "restricted", AllowedModels: []string{"llama3:8b", "qwen2:1.5b"}})
+	unrestricted, _ := createKey(t, rc, models.APIKey{Name: "any"})
+
+	t.Run("fetch-models", func(t *testing.T) {
+		for _, tt := range []struct {
+			secret string
+			want   []string
+		}{
+			{restricted, []string{"llama3:8b", "qwen2:1.5b"}},
+			{unrestricted, []string{"llama3:8b", "mistral:7b", "qwen2:1.5b"}},
+		} {
+			recorder := serve(router, "GET", "/node/fetch-models", "Bearer "+tt.secret, "")
+			var body struct {
+				Models []models.LLModel `json:"models"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %s: %v", recorder.Body, err)
+			}
+			var names []string
+			for _, model := range body.Models {
+				names = append(names, model.Modelinfo.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("models = %v, want %v", names, tt.want)
+			}
+		}
+	})
+
+	t.Run("v1/models", func(t *testing.T) {
+		recorder := serve(router, "GET", "/v1/models", "Bearer "+restricted, "")
+		var list models.OpenAIModelList
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+			t.Fatalf("body %s: %v", recorder.Body, err)
+		}
+		var ids []string
+		for _, model := range list.Data {
+			ids = append(ids, model.ID)
+		}
+		if strings.Join(ids, ",") != "llama3:8b,qwen2:1.5b" {
+			t.Errorf("models = %v, want llama3:8b and qwen2:1.5b", ids)
+		}
+	})
+
+	t.Run("forbidden model", func(t *testing.T) {
+		recorder := serve(router, "POST", "/node/load-model", "Bearer "+restricted, `{"model":"mistral:7b"}`)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("/node/load-model status = %d, want 403", recorder.Code)
+		}
+
+		recorder = serve(router, "POST", "/v1/chat/completions", "Bearer "+restricted, `{"model":"mistral:7b","messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("/v1/chat/completions status = %d, want 403", recorder.Code)
+		}
+		var body models.OpenAIErrorResponse
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if body.Error.Type != "permission_error" {
+			t.Errorf("error type = %q, want permission_error", body.Error.Type)
+		}
+	})
+}
@@ -10,160 +10,319 @@
 package routes
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"node/clients"
 	_ "node/docs"
 	"node/logic"
+	"time"
 
 	databinding "Pkgs/DataBinding"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ClientHandler struct {
-	logger *log.Logger
-	redis  *clients.RedisClient
+	logger     *slog.Logger
+	redis      *clients.RedisClient
+	mongo      *clients.MongoClient
+	schedulers *logic.SchedulerSet
+	loader     *logic.ModelLoader
+	auth       *Authenticator
+	auditor    *logic.Auditor
+
+	// HostCooldown is how long a host that failed a chat is tried last
+	HostCooldown time.Duration
+	// MaxChatAttempts bounds the hosts a chat is tried on
+	MaxChatAttempts int
 }
 
-func NewClientHandler(logger *log.Logger, redis *clients.RedisClient) *ClientHandler {
+func NewClientHandler(logger *slog.Logger, redis *clients.RedisClient, mongo *clients.MongoClient, schedulers *logic.SchedulerSet, loader *logic.ModelLoader, auth *Authenticator, auditor *logic.Auditor) *ClientHandler {
 	return &ClientHandler{
-		logger: logger,
-		redis:  redis,
+		logger:     logger,
+		redis:      redis,
+		mongo:      mongo,
+		schedulers: schedulers,
+		loader:     loader,
+		auth:       auth,
+		auditor:    auditor,
+
+		HostCooldown:    logic.DefaultHostCooldown,
+		MaxChatAttempts: logic.DefaultMaxChatAttempts,
 	}
 }
 
 // RegisterRoutes registers all client-related routes
 func (c *ClientHandler) RegisterRoutes(router *gin.Engine) {
+	// Audited routes run the audit before the API key check, so that
+	// rejected requests are recorded too
+	requireNodeKey := c.auth.RequireAPIKey(writeNodeError)
+	node := router.Group("/node")
+	node.POST("/load-model", Audit(c.auditor, "/node/load-model"), requireNodeKey, c.handleClientLoadModel)
+	node.POST("/unload-model", requireNodeKey, c.handleClientUnloadModel)
+	node.GET("/fetch-models", requireNodeKey, c.handleFetchModels)
+	node.POST("/chat", Audit(c.auditor, "/node/chat"), requireNodeKey, c.handleClientChat)
 
-	router.POST("/node/load-model", c.handleClientLoadModel)
-	router.GET("/node/fetch-models", c.handleFetchModels)
-	router.POST("/node/chat", c.handleClientChat)
+	// OpenAI compatible gateway
+	requireOpenAIKey := c.auth.RequireAPIKey(c.openAIError)
+	v1 := router.Group("/v1")
+	v1.GET("/models", requireOpenAIKey, c.handleOpenAIModels)
+	v1.POST("/chat/completions", Audit(c.auditor, "/v1/chat/completions"), requireOpenAIKey, c.handleOpenAIChatCompletions)
 }
 
-// handleFetchModels fetches available models from Redis
+// handleFetchModels fetches the models the caller may use from Redis
 func (c *ClientHandler) handleFetchModels(gc *gin.Context) {
 	ctx := gc.Request.Context()
 
 	// Fetch models from Redis
-	models, err := c.redis.GetAllLLModels(ctx)
+	llModels, err := c.redis.GetAllLLModels(ctx)
 	if err != nil {
-		c.logger.Println("Error fetching models from Redis:", err)
+		c.logger.ErrorContext(ctx, "Error fetching models from Redis", "error", err)
 		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch models"})
 		return
 	}
 
 	// Return models as JSON response
-	gc.JSON(http.StatusOK, gin.H{"models": models})
+	gc.JSON(http.StatusOK, gin.H{"models": allowedModels(gc, llModels)})
 }
 
 // handleClientLoadModel loads a model on an available host
 func (c *ClientHandler) handleClientLoadModel(gc *gin.Context) {
+	ctx := gc.Request.Context()
 	var request struct {
 		Model string `json:"model" binding:"required"`
 	}
 
 	if err := gc.ShouldBindJSON(&request); err != nil {
-		c.logger.Printf("Invalid request format: %v", err)
+		c.logger.WarnContext(ctx, "Invalid request format", "error", err)
 		gc.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
-	// Get inactive host and corresponding model
-	inactiveHost, selectedModel, err := logic.GetServerToLoad(request.Model, c.redis, c.logger)
-	if err != nil {
-		c.logger.Printf("Failed to find inactive host: %v", err)
-		gc.JSON(http.StatusInternalServerError, gin.H{"error": "No available host"})
+	auditRecord(gc).Model = request.Model
+	if !modelAllowed(gc, request.Model) {
+		gc.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to use this model"})
 		return
 	}
 
-	// Call the Host server to load model
-	apiClient := clients.MakeTemporaryAPIClient(inactiveHost.HostInfo.IPAddress, inactiveHost.HostInfo.HostPort)
-
-	resp, err := apiClient.MakeRequest("POST", "/host/load-model", request, nil)
+	// Load the model, sharing the load with any chat waiting on the same model
+	inactiveHost, resp, err := c.loader.LoadModel(ctx, request.Model)
 	if err != nil {
-		c.logger.Printf("Failed to load model: %v", err)
+		if errors.Is(err, logic.ErrModelNotFound) || errors.Is(err, logic.ErrNoInactiveServers) {
+			c.logger.WarnContext(ctx, "Failed to find inactive host", "model", request.Model, "error", err)
+			gc.JSON(http.StatusInternalServerError, gin.H{"error": "No available host"})
+			return
+		}
+		c.logger.ErrorContext(ctx, "Failed to load model", "model", request.Model, "error", err)
 		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load model"})
 		return
 	}
 
-	// Update HostingServer status to active
-	for i, server := range selectedModel.HostingServers {
-		if server.IPAdd == inactiveHost.HostInfo.IPAddress {
-			selectedModel.HostingServers[i].Status = true
-			c.logger.Printf("Updated server %s status to active", server.IPAdd)
-			break
-		}
+	c.logger.InfoContext(ctx, "Model successfully loaded", "model", request.Model, "host", inactiveHost.HostInfo.IPAddress)
+	auditRecord(gc).HostIP = inactiveHost.HostInfo.IPAddress
+
+	// Forward the response
+	gc.Data(http.StatusOK, "application/json", resp)
+}
+
+// handleClientUnloadModel unloads a model from its hosts, or from a single host
+func (c *ClientHandler) handleClientUnloadModel(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	var request struct {
+		Model  string `json:"model" binding:"required"`
+		HostIP string `json:"host_ip"`
 	}
 
-	// Save updated model back to Redis
-	err = c.redis.AddOrUpdateLLModel(context.Background(), *selectedModel)
-	if err != nil {
-		c.logger.Printf("Failed to update model in Redis: %v", err)
-		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model status"})
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		c.logger.WarnContext(ctx, "Invalid request format", "error", err)
+		gc.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
-	c.logger.Printf("Model %s successfully loaded on host %s", request.Model, inactiveHost.HostInfo.IPAddress)
+	if !modelAllowed(gc, request.Model) {
+		gc.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to use this model"})
+		return
+	}
 
-	// Forward the response
-	gc.JSON(http.StatusOK, resp)
+	unloaded, err := c.loader.UnloadModel(ctx, request.Model, request.HostIP)
+	if err != nil {
+		if errors.Is(err, logic.ErrModelNotFound) || errors.Is(err, logic.ErrNoAvailableHosts) {
+			c.logger.WarnContext(ctx, "No loaded host to unload", "model", request.Model, "error", err)
+			gc.JSON(http.StatusNotFound, gin.H{"error": "Model is not loaded on any matching host"})
+			return
+		}
+		c.logger.ErrorContext(ctx, "Failed to unload model", "model", request.Model, "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unload model", "unloaded_hosts": unloaded})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{
+		"message":        "Model unloaded successfully",
+		"model":          request.Model,
+		"unloaded_hosts": unloaded,
+	})
 }
 
 // handleClientChat handles chat requests with AI models
 func (c *ClientHandler) handleClientChat(gc *gin.Context) {
+	ctx, span := databinding.StartSpan(gc.Request.Context(), "handleClientChat")
+	defer span.End()
 	var chatRequest databinding.ChatCompletion
 
 	if err := gc.ShouldBindJSON(&chatRequest); err != nil {
-		c.logger.Printf("Invalid chat request format: %v", err)
+		c.logger.WarnContext(ctx, "Invalid chat request format", "error", err)
 		gc.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat request format"})
 		return
 	}
 
-	// Get best available host
-	bestHost, err := logic.GetBestHost(chatRequest.Model, c.redis, c.logger)
-	if err != nil {
-		c.logger.Printf("Failed to find best host: %v", err)
-		gc.JSON(http.StatusInternalServerError, gin.H{"error": "No available host"})
+	if err := chatRequest.Validate(); err != nil {
+		c.logger.WarnContext(ctx, "Invalid chat request", "error", err)
+		gc.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	audit := auditRecord(gc)
+	audit.Model = chatRequest.Model
+	audit.ConversationID = chatRequest.ConversationID
+	audit.Prompt = chatRequest.Messages
+
+	if !modelAllowed(gc, chatRequest.Model) {
+		gc.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to use this model"})
 		return
 	}
+	span.SetAttributes(
+		attribute.String("deepgate.model", chatRequest.Model),
+		attribute.Bool("deepgate.stream", chatRequest.IsStreaming()),
+	)
+
+	// Continue a stored conversation, its history is sent ahead of the new turn
+	var turn []databinding.Message
+	if chatRequest.ConversationID != "" {
+		if err := logic.ValidateMessages(chatRequest.Messages); err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var err error
+		turn, err = logic.ContinueConversation(ctx, conversationOwner(gc), &chatRequest, c.mongo)
+		if err != nil {
+			if errors.Is(err, logic.ErrConversationNotFound) {
+				gc.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+				return
+			}
+			c.logger.ErrorContext(ctx, "Failed to load conversation", "conversation", chatRequest.ConversationID, "error", err)
+			gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversation"})
+			return
+		}
+		span.SetAttributes(attribute.String("deepgate.conversation", chatRequest.ConversationID))
 
-	// Call Host server
-	apiClient := clients.MakeTemporaryAPIClient(bestHost.HostInfo.IPAddress, bestHost.HostInfo.HostPort)
+		if len(chatRequest.Messages) == 0 {
+			gc.JSON(http.StatusBadRequest, gin.H{"error": "messages are required, the conversation has no messages yet"})
+			return
+		}
+	}
 
-	// Open a streaming connection to the Host's /chat API
-	hostResp, err := apiClient.MakeStreamRequest("POST", "/host/chat", nil, chatRequest)
+	// Open a stream on the best available host, failing over to the next
+	// candidates if a host fails before streaming starts
+	stream, err := c.openHostStream(ctx, chatRequest)
 	if err != nil {
-		c.logger.Printf("Chat request failed: %v", err)
+		databinding.FailSpan(span, err)
+		if errors.Is(err, logic.ErrModelNotFound) || errors.Is(err, logic.ErrNoAvailableHosts) {
+			c.logger.WarnContext(ctx, "Failed to find best host", "model", chatRequest.Model, "error", err)
+			gc.JSON(http.StatusInternalServerError, gin.H{"error": "No available host"})
+			return
+		}
+		c.logger.ErrorContext(ctx, "Chat request failed", "model", chatRequest.Model, "error", err)
 		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Chat request failed"})
 		return
 	}
-	defer hostResp.Body.Close()
+	defer stream.Close()
+	span.SetAttributes(attribute.String("deepgate.host", stream.host.IPAdd))
+	audit.HostIP = stream.host.IPAdd
+
+	// Relay a non-streaming response as the host sent it
+	if !chatRequest.IsStreaming() {
+		body, err := io.ReadAll(stream.resp.Body)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "Error reading response from host", "host", stream.host.IPAdd, "error", err)
+			gc.JSON(http.StatusBadGateway, gin.H{"error": "Error reading host response"})
+			return
+		}
+
+		var response databinding.ChatResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			c.logger.ErrorContext(ctx, "Invalid response from host", "host", stream.host.IPAdd, "error", err)
+			gc.JSON(http.StatusBadGateway, gin.H{"error": "Invalid host response"})
+			return
+		}
+		c.recordUsage(ctx, gc, chatRequest.Model, stream.host.IPAdd, response.Usage)
+		audit.Response = response.Message.Content
+		if chatRequest.ConversationID != "" {
+			logic.SaveConversationTurn(ctx, conversationOwner(gc), chatRequest.ConversationID, chatRequest.Model, turn, response.Message.Content, c.mongo, c.logger)
+		}
+
+		gc.Data(http.StatusOK, "application/json", body)
+		return
+	}
 
 	// Set headers for SSE
 	gc.Header("Content-Type", "text/event-stream")
 	gc.Header("Cache-Control", "no-cache")
 	gc.Header("Connection", "keep-alive")
 	gc.Header("Transfer-Encoding", "chunked")
+	gc.Header(databinding.StreamVersionHeader, databinding.StreamProtocolVersion)
 
-	// Stream the response from Host to Client
+	// Relay the stream from Host to Client one whole event at a time, collecting
+	// the reply for the conversation
+	reply := databinding.NewChatResponse(chatRequest.Model)
 	gc.Stream(func(w io.Writer) bool {
-		buffer := make([]byte, 1024)
-		for {
-			n, err := hostResp.Body.Read(buffer)
-			if n > 0 {
-				gc.Writer.Write(buffer[:n])
-				gc.Writer.Flush()
-			}
-			if err != nil {
-				if err == io.EOF {
-					return false // End of stream
-				}
-				c.logger.Printf("Error reading stream: %v", err)
+		event, err := stream.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				c.logger.InfoContext(ctx, "Client disconnected")
+				audit.Error = "Client disconnected"
 				return false
 			}
+
+			// Too late to fail over, tell the client the answer is incomplete
+			if err == io.EOF {
+				c.logger.ErrorContext(ctx, "Host ended the stream before completion", "host", stream.host.IPAdd)
+			} else {
+				c.logger.ErrorContext(ctx, "Error reading stream from host", "host", stream.host.IPAdd, "error", err)
+			}
+			databinding.FailSpan(span, err)
+			audit.Error = "Host failed while streaming the response"
+			logic.MarkHostUnhealthy(ctx, stream.host.IPAdd, c.HostCooldown, c.redis, c.logger)
+			databinding.WriteStreamEvent(w, databinding.StreamEvent{
+				Type:  databinding.EventError,
+				Error: "Host failed while streaming the response",
+			})
+			return false
+		}
+
+		if event.Type == databinding.EventUsage && event.Usage != nil {
+			c.recordUsage(ctx, gc, chatRequest.Model, stream.host.IPAdd, *event.Usage)
+		}
+
+		// Only a completed reply is stored, the stream carries on regardless
+		reply.Add(*event)
+		if event.Type == databinding.EventError {
+			audit.Error = event.Error
+		}
+		if event.Type == databinding.EventDone && chatRequest.ConversationID != "" {
+			logic.SaveConversationTurn(ctx, conversationOwner(gc), chatRequest.ConversationID, chatRequest.Model, turn, reply.Message.Content, c.mongo, c.logger)
+		}
+
+		if err := databinding.WriteStreamEvent(w, *event); err != nil {
+			c.logger.InfoContext(ctx, "Client disconnected", "error", err)
+			audit.Error = "Client disconnected"
+			return false
 		}
+		return !event.IsTerminal()
 	})
+	audit.Response = reply.Message.Content
 }
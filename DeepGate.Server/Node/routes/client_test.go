@@ -0,0 +1,243 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"node/clients"
+	"node/logic"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var llama = models.HostModelInfo{Name: "llama3:8b", ParameterSize: "8B", Family: "llama"}
+
+// chatHost registers a host with llama active that streams events in reply
+// to every chat, and returns how many chats it received
+func chatHost(t *testing.T, rc *clients.RedisClient, events []databinding.StreamEvent) *atomic.Int32 {
+	t.Helper()
+	chats := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/host/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		chats.Add(1)
+		w.Header().Set(databinding.StreamVersionHeader, databinding.StreamProtocolVersion)
+		for _, event := range events {
+			databinding.WriteStreamEvent(w, event)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	ip, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	host := models.LLMHost{
+		IPAdd:     ip,
+		HostInfo:  databinding.InfoPackage{IPAddress: ip, HostPort: port},
+		ModelInfo: []models.HostModelInfo{llama},
+		Status:    true,
+	}
+	if err := rc.SaveLLMHost(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.UpsertHostingServer(ctx, llama, ip, true); err != nil {
+		t.Fatal(err)
+	}
+	return chats
+}
+
+// chatRouter serves /node/chat with conversations stored in mongo
+func chatRouter(t *testing.T, rc *clients.RedisClient, mongo *clients.MongoClient) *gin.Engine {
+	t.Helper()
+	schedulers, err := logic.NewSchedulerSet(logic.DefaultSchedulingConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(discardLogger, rc, testAdminToken, "", false)
+	handler := NewClientHandler(discardLogger, rc, mongo, schedulers, logic.NewModelLoader(rc, discardLogger), auth, nil)
+
+	router := gin.New()
+	router.POST("/node/chat", auth.RequireAPIKey(writeNodeError), handler.handleClientChat)
+	return router
+}
+
+// postChat sends a chat with an API key and returns the status and body of
+// the reply
+func postChat(mt *mtest.T, url, secret, body string) (int, string) {
+	mt.Helper()
+	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		mt.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+secret)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	return resp.StatusCode, string(reply)
+}
+
+// startedCommandNames returns the names of the commands sent to MongoDB
+func startedCommandNames(commands []*event.CommandStartedEvent) []string {
+	var names []string
+	for _, command := range commands {
+		names = append(names, command.CommandName)
+	}
+	return names
+}
+
+func TestClientChatConversation(t *testing.T) {
+	conversationsNS := "test." + clients.ConversationsCollection
+	storedConversation := bson.D{
+		{Key: "_id", Value: "c1"},
+		{Key: "messages", Value: bson.A{
+			bson.D{{Key: "role", Value: "user"}, {Key: "content", Value: "Hi"}},
+			bson.D{{Key: "role", Value: "assistant"}, {Key: "content", Value: "Hello"}},
+		}},
+	}
+	completed := []databinding.StreamEvent{
+		{Type: databinding.EventToken, Content: "Fine, "},
+		{Type: databinding.EventToken, Content: "thanks"},
+		{Type: databinding.EventDone, DoneReason: "stop"},
+	}
+
+	tests := []struct {
+		name         string
+		events       []databinding.StreamEvent
+		found        bool
+		wantStatus   int
+		wantChats    int32
+		wantCommands []string
+		wantBody     string
+	}{
+		{
+			name:         "completed reply is stored",
+			events:       completed,
+			found:        true,
+			wantStatus:   http.StatusOK,
+			wantChats:    1,
+			wantCommands: []string{"find", "findAndModify"},
+			wantBody:     "event: done",
+		},
+		{
+			name:         "interrupted reply is not stored",
+			events:       completed[:1],
+			found:        true,
+			wantStatus:   http.StatusOK,
+			wantChats:    1,
+			wantCommands: []string{"find"},
+			wantBody:     "event: error",
+		},
+		{
+			name:         "failed reply is not stored",
+			events:       []databinding.StreamEvent{completed[0], {Type: databinding.EventError, Error: "out of memory"}},
+			found:        true,
+			wantStatus:   http.StatusOK,
+			wantChats:    1,
+			wantCommands: []string{"find"},
+			wantBody:     "out of memory",
+		},
+		{
+			name:         "conversation of another owner",
+			events:       completed,
+			wantStatus:   http.StatusNotFound,
+			wantCommands: []string{"find"},
+			wantBody:     "Conversation not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newTestRedis(t)
+			secret, key := createKey(t, rc, models.APIKey{Name: "ci"})
+			chats := chatHost(t, rc, tt.events)
+
+			mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+			mt.Run("mongo", func(mt *mtest.T) {
+				if tt.found {
+					mt.AddMockResponses(
+						mtest.CreateCursorResponse(0, conversationsNS, mtest.FirstBatch, storedConversation),
+						mtest.CreateSuccessResponse(bson.E{Key: "value", Value: storedConversation}),
+					)
+				} else {
+					mt.AddMockResponses(mtest.CreateCursorResponse(0, conversationsNS, mtest.FirstBatch))
+				}
+				// Streams need a real connection, the recorder cannot notify of a close
+				node := httptest.NewServer(chatRouter(t, rc, clients.NewMongoClient(mt.DB)))
+				defer node.Close()
+
+				body := `{"model":"llama3:8b","conversation_id":"c1","messages":[{"role":"user","content":"How are you?"}]}`
+				status, reply := postChat(mt, node.URL+"/node/chat", secret, body)
+				if status != tt.wantStatus {
+					mt.Fatalf("status = %d, want %d: %s", status, tt.wantStatus, reply)
+				}
+				if !strings.Contains(reply, tt.wantBody) {
+					mt.Errorf("body = %s, want %q in it", reply, tt.wantBody)
+				}
+				if got := chats.Load(); got != tt.wantChats {
+					mt.Errorf("host received %d chats, want %d", got, tt.wantChats)
+				}
+
+				commands := mt.GetAllStartedEvents()
+				if got := startedCommandNames(commands); strings.Join(got, ",") != strings.Join(tt.wantCommands, ",") {
+					mt.Fatalf("commands = %v, want %v", got, tt.wantCommands)
+				}
+
+				// Conversations are looked up and stored within the key's own
+				var find struct {
+					Filter bson.M `bson:"filter"`
+				}
+				if err := bson.Unmarshal(commands[0].Command, &find); err != nil || find.Filter["owner"] != key.ID {
+					mt.Errorf("find filter = %v, %v, want owner %s", find.Filter, err, key.ID)
+				}
+				if len(commands) < 2 {
+					return
+				}
+
+				var update struct {
+					Query  bson.M `bson:"query"`
+					Update struct {
+						Push struct {
+							Messages struct {
+								Each []models.ConversationMessage `bson:"$each"`
+							} `bson:"messages"`
+						} `bson:"$push"`
+					} `bson:"update"`
+				}
+				if err := bson.Unmarshal(commands[1].Command, &update); err != nil {
+					mt.Fatal(err)
+				}
+				if update.Query["owner"] != key.ID {
+					mt.Errorf("update query = %v, want owner %s", update.Query, key.ID)
+				}
+				// Only the new turn is appended, not the history sent along
+				messages := update.Update.Push.Messages.Each
+				if len(messages) != 2 || messages[0].Content != "How are you?" ||
+					messages[1].Role != "assistant" || messages[1].Content != "Fine, thanks" {
+					mt.Errorf("stored turn = %+v", messages)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"node/clients"
+	"node/logic"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the page size when listing conversations
+const (
+	defaultConversationPage = 50
+	maxConversationPage     = 200
+)
+
+type ConversationHandler struct {
+	logger *slog.Logger
+	mongo  *clients.MongoClient
+	auth   *Authenticator
+}
+
+func NewConversationHandler(logger *slog.Logger, mongo *clients.MongoClient, auth *Authenticator) *ConversationHandler {
+	return &ConversationHandler{
+		logger: logger,
+		mongo:  mongo,
+		auth:   auth,
+	}
+}
+
+// RegisterRoutes registers the conversation routes. Conversations belong to
+// the API key that created them.
+func (h *ConversationHandler) RegisterRoutes(router *gin.Engine) {
+	conversations := router.Group("/node/conversations", h.auth.RequireAPIKey(writeNodeError))
+	conversations.POST("", h.handleCreateConversation)
+	conversations.GET("", h.handleListConversations)
+	conversations.GET("/:id", h.handleGetConversation)
+	conversations.POST("/:id/messages", h.handleAppendMessages)
+	conversations.DELETE("/:id", h.handleDeleteConversation)
+}
+
+// handleCreateConversation starts a conversation, optionally with messages
+func (h *ConversationHandler) handleCreateConversation(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	var request struct {
+		Title    string                `json:"title"`
+		Model    string                `json:"model"`
+		Messages []databinding.Message `json:"messages"`
+	}
+
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		h.logger.WarnContext(ctx, "Invalid request format", "error", err)
+		gc.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := logic.ValidateMessages(request.Messages); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Model != "" && !modelAllowed(gc, request.Model) {
+		gc.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to use this model"})
+		return
+	}
+
+	conversation, err := logic.CreateConversation(ctx, conversationOwner(gc), request.Title, request.Model, request.Messages, h.mongo)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to create conversation", "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Conversation created", "conversation", conversation.ID)
+	gc.JSON(http.StatusCreated, conversation)
+}
+
+// handleListConversations lists the caller's conversations without their
+// messages, most recently updated first
+func (h *ConversationHandler) handleListConversations(gc *gin.Context) {
+	ctx := gc.Request.Context()
+
+	limit, err := strconv.Atoi(gc.DefaultQuery("limit", strconv.Itoa(defaultConversationPage)))
+	if err != nil || limit < 1 || limit > maxConversationPage {
+		gc.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxConversationPage)})
+		return
+	}
+	offset, err := strconv.Atoi(gc.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		gc.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return
+	}
+
+	conversations, err := h.mongo.ListConversations(ctx, conversationOwner(gc), int64(limit), int64(offset))
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list conversations", "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list conversations"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, gin.H{"conversations": conversations})
+}
+
+// handleGetConversation returns a conversation with its messages
+func (h *ConversationHandler) handleGetConversation(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	id := gc.Param("id")
+
+	conversation, err := h.mongo.GetConversation(ctx, conversationOwner(gc), id)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to get conversation", "conversation", id, "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversation"})
+		return
+	}
+	if conversation == nil {
+		gc.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, conversation)
+}
+
+// handleAppendMessages adds messages to a conversation without running a chat
+func (h *ConversationHandler) handleAppendMessages(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	id := gc.Param("id")
+	var request struct {
+		Messages []databinding.Message `json:"messages" binding:"required,min=1"`
+	}
+
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		h.logger.WarnContext(ctx, "Invalid request format", "error", err)
+		gc.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := logic.ValidateMessages(request.Messages); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, err := logic.AppendToConversation(ctx, conversationOwner(gc), id, request.Messages, h.mongo)
+	if err != nil {
+		if errors.Is(err, logic.ErrConversationNotFound) {
+			gc.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		h.logger.ErrorContext(ctx, "Failed to append to conversation", "conversation", id, "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append to conversation"})
+		return
+	}
+
+	gc.JSON(http.StatusOK, conversation)
+}
+
+// handleDeleteConversation deletes a conversation
+func (h *ConversationHandler) handleDeleteConversation(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	id := gc.Param("id")
+
+	deleted, err := h.mongo.DeleteConversation(ctx, conversationOwner(gc), id)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to delete conversation", "conversation", id, "error", err)
+		gc.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
+		return
+	}
+	if !deleted {
+		gc.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Conversation deleted", "conversation", id)
+	gc.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -2,25 +2,28 @@ package routes
 
 import (
 	databinding "Pkgs/DataBinding"
-	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"node/clients"
 	_ "node/docs"
+	"node/logic"
 	"node/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type HostHandler struct {
-	logger *log.Logger
+	logger *slog.Logger
 	redis  *clients.RedisClient
+	auth   *Authenticator
 }
 
-func NewHostHandler(logger *log.Logger, redis *clients.RedisClient) *HostHandler {
+func NewHostHandler(logger *slog.Logger, redis *clients.RedisClient, auth *Authenticator) *HostHandler {
 	return &HostHandler{
 		logger: logger,
 		redis:  redis,
+		auth:   auth,
 	}
 }
 
@@ -32,110 +35,182 @@ func (h *HostHandler) RegisterRoutes(router *gin.Engine) {
 	// @Accept  json
 	// @Produce  json
 	// @Param infoPackage body databinding.InfoPackage true "Host information package"
-	// @Success 200 {object} map[string]string "status: received"
+	// @Success 200 {object} databinding.PingResponse
 	// @Failure 400 {object} map[string]string "error: Invalid request"
+	// @Failure 401 {object} map[string]string "error: Invalid signature"
 	// @Failure 500 {object} map[string]string "error: Failed to fetch model list"
 	// @Router /ping [post]
 	router.POST("/ping", h.handlePing)
+
+	// Registered hosts authenticate with the token issued on /ping
+	hosts := router.Group("", h.auth.RequireHostToken())
+	hosts.POST("/heartbeat", h.handleHeartbeat)
+	hosts.POST("/model-status", h.handleModelStatus)
+	hosts.POST("/deregister", h.handleDeregister)
 }
 
 // handlePing handles the ping request from hosts
 func (h *HostHandler) handlePing(c *gin.Context) {
+	ctx := c.Request.Context()
 	var infoPackage databinding.InfoPackage
 	if err := c.BindJSON(&infoPackage); err != nil {
-		h.logger.Printf("Invalid ping request: %v", err)
+		h.logger.WarnContext(ctx, "Invalid ping request", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if infoPackage.Scheme != "" && infoPackage.Scheme != "http" && infoPackage.Scheme != "https" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scheme must be http or https"})
+		return
+	}
 
-	// Make temporary API client to make a request
-	apiClient := clients.MakeTemporaryAPIClient(infoPackage.IPAddress, infoPackage.HostPort)
+	// Only hosts holding the cluster secret may register
+	if err := h.auth.VerifyEnrollment(infoPackage); err != nil {
+		h.logger.WarnContext(ctx, "Rejected host enrollment", "host", infoPackage.IPAddress, "client_ip", c.ClientIP(), "error", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
 
-	resp, err := apiClient.MakeRequest("GET", "/fetchlocalmodellist", nil, nil)
+	hostToken, err := h.auth.IssueHostToken(infoPackage.IPAddress)
 	if err != nil {
-		h.logger.Printf("Failed to fetch model list: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch model list"})
+		h.logger.ErrorContext(ctx, "Failed to issue host token", "host", infoPackage.IPAddress, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue host token"})
 		return
 	}
 
-	// Parse model response
-	modelResponse, err := models.ParseModelResponse(resp)
+	hostModels, err := logic.FetchHostModels(ctx, infoPackage, hostToken)
 	if err != nil {
-		h.logger.Printf("Failed to parse model response: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse model response"})
+		h.logger.ErrorContext(ctx, "Failed to fetch model list", "host", infoPackage.IPAddress, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch model list"})
 		return
 	}
 
-	// Convert to simplified host model info
-	hostModels := models.ConvertModelsToHostInfo(modelResponse.Models)
-
 	// Create LLMHost object to maintain host and model information
 	llmHost := models.LLMHost{
-		IPAdd:     infoPackage.IPAddress,
-		HostInfo:  infoPackage,
-		ModelInfo: hostModels,
+		IPAdd:         infoPackage.IPAddress,
+		HostInfo:      infoPackage,
+		ModelInfo:     hostModels,
+		Status:        true,
+		LastHeartbeat: time.Now().Unix(),
+		Token:         hostToken,
+	}
+
+	// Save the host and register it for each of its models. A host
+	// registering again may have removed models in the meantime.
+	register := func(*models.LLMHost) *models.LLMHost { return &llmHost }
+	if err := logic.SyncHostModels(ctx, infoPackage.IPAddress, register, h.redis, h.logger); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to save host to Redis", "host", infoPackage.IPAddress, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update host information"})
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Received ping", "host", infoPackage.IPAddress, "models", len(hostModels))
+	c.JSON(http.StatusOK, databinding.PingResponse{Status: "received", HostToken: hostToken})
+}
+
+// handleHeartbeat refreshes a host's liveness and restores it if it was offline
+func (h *HostHandler) handleHeartbeat(c *gin.Context) {
+	ctx := c.Request.Context()
+	var heartbeat databinding.Heartbeat
+	if err := c.BindJSON(&heartbeat); err != nil {
+		h.logger.WarnContext(ctx, "Invalid heartbeat request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !hostAllowed(c, heartbeat.IPAddress) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Host token was issued to another host"})
+		return
+	}
+
+	host, err := h.redis.GetLLMHost(ctx, heartbeat.IPAddress)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to get host from Redis", "host", heartbeat.IPAddress, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch host information"})
+		return
+	}
+
+	// Unknown host, ask it to register through /ping again
+	if host == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not registered"})
+		return
 	}
 
-	// Add or update the host in Redis
-	err = h.redis.SaveLLMHost(context.Background(), llmHost)
+	// Renew the host token so that it never expires while the host is alive
+	hostToken, err := h.auth.IssueHostToken(host.IPAdd)
 	if err != nil {
-		h.logger.Printf("Failed to update LLMHost in Redis: %v", err)
+		h.logger.ErrorContext(ctx, "Failed to issue host token", "host", host.IPAdd, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue host token"})
+		return
+	}
+
+	wasOffline := !host.Status
+	host.LastHeartbeat = time.Now().Unix()
+	host.Token = hostToken
+
+	if wasOffline {
+		err = logic.RestoreHost(ctx, *host, heartbeat.LoadedModels, h.redis, h.logger)
+	} else {
+		err = h.redis.SaveLLMHost(ctx, *host)
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to update host", "host", heartbeat.IPAddress, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update host information"})
 		return
 	}
 
-	// Get current list of LLModels
-	allModels, err := h.redis.GetAllLLModels(context.Background())
+	c.JSON(http.StatusOK, databinding.HeartbeatResponse{Status: "alive", HostToken: hostToken})
+}
+
+// handleModelStatus records a model being loaded or unloaded on a host
+func (h *HostHandler) handleModelStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	var status databinding.ModelStatus
+	if err := c.BindJSON(&status); err != nil {
+		h.logger.WarnContext(ctx, "Invalid model status request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !hostAllowed(c, status.IPAddress) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Host token was issued to another host"})
+		return
+	}
+
+	updated, err := h.redis.SetHostingServerStatus(ctx, status.Model, status.IPAddress, status.Loaded)
 	if err != nil {
-		h.logger.Printf("Failed to get LLModels from Redis: %v", err)
+		h.logger.ErrorContext(ctx, "Failed to update model status", "model", status.Model, "host", status.IPAddress, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model information"})
 		return
 	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host is not registered for this model"})
+		return
+	}
 
-	// Create a map for faster lookup of existing models
-	modelMap := make(map[string]*models.LLModel)
-	for i, model := range allModels {
-		modelMap[model.Modelinfo.Name] = &allModels[i]
-	}
-
-	// Process each model from the host
-	for _, hostModel := range hostModels {
-		if existingModel, exists := modelMap[hostModel.Name]; exists {
-			// Update existing model's host list
-			hostExists := false
-			for _, host := range existingModel.HostingServers {
-				if host.IPAdd == infoPackage.IPAddress {
-					hostExists = true
-					break
-				}
-			}
-			if !hostExists {
-				hostingServer := models.HostingServer{
-					IPAdd:  infoPackage.IPAddress,
-					Status: false,
-				}
-				existingModel.HostingServers = append(existingModel.HostingServers, hostingServer)
-			}
-		} else {
-			hostingServer := models.HostingServer{
-				IPAdd:  infoPackage.IPAddress,
-				Status: false,
-			}
-			newModel := models.LLModel{
-				Modelinfo:      hostModel,
-				HostingServers: []models.HostingServer{hostingServer},
-			}
-			allModels = append(allModels, newModel)
-		}
-	}
-
-	// Save updated LLModels to Redis
-	if err := h.redis.UpdateLLModelList(context.Background(), allModels); err != nil {
-		h.logger.Printf("Failed to save updated LLModels to Redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model information"})
+	h.logger.InfoContext(ctx, "Model status updated", "model", status.Model, "host", status.IPAddress, "loaded", status.Loaded)
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// handleDeregister removes a host that is shutting down from the registry
+func (h *HostHandler) handleDeregister(c *gin.Context) {
+	ctx := c.Request.Context()
+	var deregistration databinding.Deregistration
+	if err := c.BindJSON(&deregistration); err != nil {
+		h.logger.WarnContext(ctx, "Invalid deregister request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !hostAllowed(c, deregistration.IPAddress) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Host token was issued to another host"})
+		return
+	}
+
+	if err := logic.DeregisterHost(ctx, deregistration.IPAddress, h.redis, h.logger); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to deregister host", "host", deregistration.IPAddress, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deregister host"})
 		return
 	}
 
-	h.logger.Printf("Received ping from %s", infoPackage.IPAddress)
-	c.JSON(http.StatusOK, gin.H{"status": "received"})
+	c.JSON(http.StatusOK, gin.H{"status": "deregistered"})
 }
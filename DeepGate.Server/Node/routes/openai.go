@@ -0,0 +1,244 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"node/logic"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleOpenAIModels lists the registry models the caller may use in the
+// OpenAI format
+func (c *ClientHandler) handleOpenAIModels(gc *gin.Context) {
+	llModels, err := c.redis.GetAllLLModels(gc.Request.Context())
+	if err != nil {
+		c.logger.ErrorContext(gc.Request.Context(), "Error fetching models from Redis", "error", err)
+		c.openAIError(gc, http.StatusInternalServerError, "server_error", "Failed to fetch models")
+		return
+	}
+
+	gc.JSON(http.StatusOK, models.ConvertLLModelsToOpenAI(allowedModels(gc, llModels)))
+}
+
+// handleOpenAIChatCompletions serves OpenAI compatible chat completions by
+// translating them to a ChatCompletion and proxying to the best Host
+func (c *ClientHandler) handleOpenAIChatCompletions(gc *gin.Context) {
+	ctx := gc.Request.Context()
+	var request models.OpenAIChatRequest
+
+	if err := gc.ShouldBindJSON(&request); err != nil {
+		c.logger.WarnContext(ctx, "Invalid OpenAI chat request format", "error", err)
+		c.openAIError(gc, http.StatusBadRequest, "invalid_request_error", "Invalid chat request format")
+		return
+	}
+
+	if request.Model == "" || len(request.Messages) == 0 {
+		c.openAIError(gc, http.StatusBadRequest, "invalid_request_error", "'model' and 'messages' are required")
+		return
+	}
+
+	if request.ResponseFormat != nil {
+		switch request.ResponseFormat.Type {
+		case "", "text", "json_object":
+		case "json_schema":
+			if request.ResponseFormat.JSONSchema == nil || len(request.ResponseFormat.JSONSchema.Schema) == 0 {
+				c.openAIError(gc, http.StatusBadRequest, "invalid_request_error", "'response_format.json_schema.schema' is required")
+				return
+			}
+		default:
+			c.openAIError(gc, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Unsupported response_format type '%s'", request.ResponseFormat.Type))
+			return
+		}
+	}
+
+	chatRequest := request.ToChatCompletion()
+	if err := chatRequest.Validate(); err != nil {
+		c.openAIError(gc, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	audit := auditRecord(gc)
+	audit.Model = chatRequest.Model
+	audit.Prompt = chatRequest.Messages
+	if !modelAllowed(gc, chatRequest.Model) {
+		c.openAIError(gc, http.StatusForbidden, "permission_error", fmt.Sprintf("The API key is not allowed to use the model '%s'", chatRequest.Model))
+		return
+	}
+
+	// Open a stream on the best available host, failing over to the next
+	// candidates if a host fails before streaming starts
+	chatRequest.ConversationID = gc.GetHeader("X-Conversation-ID")
+	audit.ConversationID = chatRequest.ConversationID
+	stream, err := c.openHostStream(ctx, chatRequest)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "Chat request failed", "model", chatRequest.Model, "error", err)
+		if errors.Is(err, logic.ErrModelNotFound) {
+			c.openAIError(gc, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("The model '%s' does not exist", chatRequest.Model))
+			return
+		}
+		if errors.Is(err, logic.ErrNoAvailableHosts) {
+			c.openAIError(gc, http.StatusServiceUnavailable, "server_error", "No available host")
+			return
+		}
+		c.openAIError(gc, http.StatusBadGateway, "server_error", "Chat request failed")
+		return
+	}
+	defer stream.Close()
+	audit.HostIP = stream.host.IPAdd
+
+	completionID := newCompletionID()
+	created := time.Now().Unix()
+
+	// The reply is collected for the audit record in both modes
+	reply := databinding.NewChatResponse(chatRequest.Model)
+	defer func() { audit.Response = reply.Message.Content }()
+
+	// next reads the next host event, blaming the host for a failed read
+	// unless the client left
+	next := func() (*databinding.StreamEvent, error) {
+		event, err := stream.Next()
+		if err == io.EOF {
+			err = errors.New("host ended the stream before completion")
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				c.logger.InfoContext(ctx, "Client disconnected")
+				audit.Error = "Client disconnected"
+				return nil, err
+			}
+			c.logger.ErrorContext(ctx, "Error reading stream from host", "host", stream.host.IPAdd, "error", err)
+			audit.Error = "Host failed while streaming the response"
+			logic.MarkHostUnhealthy(ctx, stream.host.IPAdd, c.HostCooldown, c.redis, c.logger)
+			return nil, err
+		}
+		reply.Add(*event)
+		if event.Type == databinding.EventError {
+			c.logger.ErrorContext(ctx, "Host reported error", "host", stream.host.IPAdd, "error", event.Error)
+			audit.Error = event.Error
+		}
+		if event.Type == databinding.EventUsage && event.Usage != nil {
+			c.recordUsage(ctx, gc, chatRequest.Model, stream.host.IPAdd, *event.Usage)
+		}
+		return event, nil
+	}
+
+	if !request.Stream {
+		for {
+			event, err := next()
+			if err != nil {
+				c.openAIError(gc, http.StatusBadGateway, "server_error", "Error reading host stream")
+				return
+			}
+			if event.Type == databinding.EventError {
+				c.openAIError(gc, http.StatusBadGateway, "server_error", event.Error)
+				return
+			}
+			if event.Type == databinding.EventDone {
+				break
+			}
+		}
+
+		message := models.OpenAIResponseMessage{
+			Content:          reply.Message.Content,
+			ReasoningContent: reply.Message.Thinking,
+			ToolCalls:        models.ConvertToolCallsToOpenAI(completionID, reply.Message.ToolCalls, 0),
+		}
+		finishReason := models.OpenAIFinishReason(reply.DoneReason, len(message.ToolCalls) > 0)
+		chatResponse := models.NewOpenAIChatResponse(completionID, chatRequest.Model, created, message, finishReason)
+		chatResponse.Usage = models.ConvertUsageToOpenAI(reply.Usage)
+		gc.JSON(http.StatusOK, chatResponse)
+		return
+	}
+
+	// Set headers for SSE
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("Connection", "keep-alive")
+	gc.Header("Transfer-Encoding", "chunked")
+
+	c.writeOpenAIData(gc, models.NewOpenAIChatChunk(completionID, chatRequest.Model, created, models.OpenAIDelta{Role: "assistant"}, ""))
+
+	toolCallCount := 0
+	var usage *databinding.Usage
+	for {
+		event, err := next()
+		if err != nil {
+			c.writeOpenAIData(gc, models.OpenAIErrorResponse{Error: models.OpenAIError{Message: "Error reading host stream", Type: "server_error"}})
+			return
+		}
+
+		var delta models.OpenAIDelta
+		switch event.Type {
+		case databinding.EventToken:
+			delta.Content = event.Content
+		case databinding.EventThinking:
+			delta.ReasoningContent = event.Content
+		case databinding.EventToolCall:
+			delta.ToolCalls = models.ConvertToolCallsToOpenAI(completionID, event.ToolCalls, toolCallCount)
+			toolCallCount += len(delta.ToolCalls)
+		case databinding.EventError:
+			c.writeOpenAIData(gc, models.OpenAIErrorResponse{Error: models.OpenAIError{Message: event.Error, Type: "server_error"}})
+			return
+		case databinding.EventUsage:
+			usage = event.Usage
+			continue
+		case databinding.EventDone:
+			finishReason := models.OpenAIFinishReason(event.DoneReason, toolCallCount > 0)
+			c.writeOpenAIData(gc, models.NewOpenAIChatChunk(completionID, chatRequest.Model, created, models.OpenAIDelta{}, finishReason))
+
+			if request.StreamOptions != nil && request.StreamOptions.IncludeUsage && usage != nil {
+				c.writeOpenAIData(gc, models.NewOpenAIUsageChunk(completionID, chatRequest.Model, created, *usage))
+			}
+			fmt.Fprint(gc.Writer, "data: [DONE]\n\n")
+			gc.Writer.Flush()
+			return
+		default:
+			// Events without an OpenAI counterpart
+			continue
+		}
+
+		c.writeOpenAIData(gc, models.NewOpenAIChatChunk(completionID, chatRequest.Model, created, delta, ""))
+	}
+}
+
+// writeOpenAIData writes a JSON payload as an SSE data frame and flushes it
+func (c *ClientHandler) writeOpenAIData(gc *gin.Context, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.logger.ErrorContext(gc.Request.Context(), "Failed to marshal OpenAI payload", "error", err)
+		return
+	}
+
+	fmt.Fprintf(gc.Writer, "data: %s\n\n", data)
+	gc.Writer.Flush()
+}
+
+// openAIError responds with an error body in the OpenAI format
+func (c *ClientHandler) openAIError(gc *gin.Context, status int, errorType, message string) {
+	gc.JSON(status, models.OpenAIErrorResponse{
+		Error: models.OpenAIError{
+			Message: message,
+			Type:    errorType,
+		},
+	})
+}
+
+// newCompletionID generates an OpenAI style completion id
+func newCompletionID() string {
+	buffer := make([]byte, 12)
+	if _, err := rand.Read(buffer); err != nil {
+		return fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	}
+	return "chatcmpl-" + hex.EncodeToString(buffer)
+}
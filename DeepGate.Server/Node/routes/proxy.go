@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"node/clients"
+	"node/logic"
+	"node/metrics"
+	"node/models"
+
+	databinding "Pkgs/DataBinding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hostStream is an open chat stream to the host that accepted a request
+type hostStream struct {
+	host    *models.LLMHost
+	resp    *http.Response
+	reader  *databinding.StreamReader
+	first   *databinding.StreamEvent
+	release func()
+}
+
+// Next returns the next event of the stream, starting with the event that was
+// read while the stream was being opened
+func (s *hostStream) Next() (*databinding.StreamEvent, error) {
+	if s.first != nil {
+		event := s.first
+		s.first = nil
+		return event, nil
+	}
+	return s.reader.Next()
+}
+
+// Close closes the host response and releases the host's task slot
+func (s *hostStream) Close() {
+	s.resp.Body.Close()
+	s.release()
+}
+
+// callerID identifies who a request is accounted and audited to, the ID of
+// its API key or else the client address. Key names are not unique and
+// client supplied headers are not trusted.
+func callerID(gc *gin.Context) string {
+	if key := apiKey(gc); key != nil {
+		return key.ID
+	}
+	return gc.ClientIP()
+}
+
+// recordUsage accounts a completed chat to its model, host and caller, and
+// counts its tokens against the caller's API key
+func (c *ClientHandler) recordUsage(ctx context.Context, gc *gin.Context, model, hostIP string, usage databinding.Usage) {
+	logic.RecordUsage(ctx, model, hostIP, callerID(gc), usage, c.redis, c.logger)
+	auditUsage(gc, usage)
+	if key := apiKey(gc); key != nil {
+		logic.ConsumeTokens(ctx, key, usage.PromptEvalCount+usage.EvalCount, c.redis, c.logger)
+	}
+}
+
+// openHostStream sends a chat to the scheduled hosts in order until one of
+// them starts streaming. A host that fails before sending its first event is
+// put in cooldown and the next candidate is tried.
+func (c *ClientHandler) openHostStream(ctx context.Context, chatRequest databinding.ChatCompletion) (*hostStream, error) {
+	scheduleRequest := logic.ScheduleRequest{
+		Model:          chatRequest.Model,
+		ConversationID: chatRequest.ConversationID,
+	}
+	candidates, err := logic.GetCandidateHosts(ctx, scheduleRequest, c.redis, c.schedulers, c.logger)
+
+	// No host has the model in memory yet, hold the chat until one has loaded it
+	if errors.Is(err, logic.ErrNoAvailableHosts) && c.loader.AutoLoad {
+		c.logger.InfoContext(ctx, "No active host for model, loading it on demand", "model", chatRequest.Model)
+		if _, _, loadErr := c.loader.LoadModel(ctx, chatRequest.Model); loadErr != nil {
+			c.logger.ErrorContext(ctx, "On demand load of model failed", "model", chatRequest.Model, "error", loadErr)
+			return nil, err
+		}
+		candidates, err = logic.GetCandidateHosts(ctx, scheduleRequest, c.redis, c.schedulers, c.logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) > c.MaxChatAttempts {
+		candidates = candidates[:c.MaxChatAttempts]
+	}
+
+	lastErr := logic.ErrNoAvailableHosts
+	for attempt, host := range candidates {
+		if attempt > 0 {
+			c.logger.InfoContext(ctx, "Retrying chat on next host", "host", host.IPAdd, "attempt", attempt+1)
+		}
+
+		stream, err := c.tryHostStream(ctx, host, chatRequest)
+		if err == nil {
+			return stream, nil
+		}
+
+		// The client went away, the host is not to blame
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		c.logger.WarnContext(ctx, "Chat request to host failed", "host", host.IPAdd, "error", err)
+		logic.MarkHostUnhealthy(ctx, host.IPAdd, c.HostCooldown, c.redis, c.logger)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// tryHostStream opens a chat stream on a single host and waits for its first event
+func (c *ClientHandler) tryHostStream(ctx context.Context, host *models.LLMHost, chatRequest databinding.ChatCompletion) (*hostStream, error) {
+	// Track the in-flight task until the stream ends, whatever the outcome
+	release := logic.AcquireHostTask(ctx, host.IPAdd, c.redis, c.logger)
+
+	apiClient := clients.MakeTemporaryAPIClient(host.HostInfo, host.Token)
+
+	start := time.Now()
+	resp, err := apiClient.MakeStreamRequest(ctx, "POST", "/host/chat", nil, chatRequest)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// A non-streaming response arrives whole, there is no first event to wait for
+	if !chatRequest.IsStreaming() {
+		return &hostStream{
+			host:    host,
+			resp:    resp,
+			release: release,
+		}, nil
+	}
+
+	if err := databinding.CheckStreamVersion(resp.Header); err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+
+	// Nothing has been sent to the client yet, so a host that fails before its
+	// first event can still be replaced
+	reader := databinding.NewStreamReader(resp.Body)
+	first, err := reader.Next()
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, fmt.Errorf("host closed the stream before the first event: %v", err)
+	}
+	if first.Type == databinding.EventError {
+		resp.Body.Close()
+		release()
+		return nil, fmt.Errorf("host reported error: %s", first.Error)
+	}
+	timeToFirstEvent := time.Since(start)
+	c.schedulers.Latency().Observe(host.IPAdd, timeToFirstEvent)
+	metrics.ObserveTimeToFirstToken(chatRequest.Model, host.IPAdd, timeToFirstEvent)
+
+	return &hostStream{
+		host:    host,
+		resp:    resp,
+		reader:  reader,
+		first:   first,
+		release: release,
+	}, nil
+}
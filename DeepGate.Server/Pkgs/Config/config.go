@@ -0,0 +1,391 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of a Node or Host. Settings are read from the
+// defaults, then a YAML file, then DEEPGATE_* environment variables, then
+// command line flags, each overriding the ones before.
+type Config struct {
+	LogLevel          string        `yaml:"log_level"`          // debug, info, warn or error
+	ClusterID         string        `yaml:"cluster_id"`         // Hosts only follow beacons of their cluster
+	ClusterSecret     string        `yaml:"cluster_secret"`     // Shared by a Node and its Hosts, enrollment is open when empty
+	RequestTimeout    time.Duration `yaml:"request_timeout"`    // Timeout of non-streaming calls between Node, Host and Ollama
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`   // How long in-flight requests may take to finish on shutdown
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"` // How often Hosts send heartbeats, and Nodes expect them
+	MDNS              bool          `yaml:"mdns"`               // Nodes advertise themselves over mDNS and Hosts browse for them
+	Beacon            BeaconConfig  `yaml:"beacon"`
+	Tracing           TracingConfig `yaml:"tracing"`
+	TLS               TLSConfig     `yaml:"tls"`
+	Redis             RedisConfig   `yaml:"redis"`
+	Mongo             MongoConfig   `yaml:"mongo"`
+	Node              NodeConfig    `yaml:"node"`
+	Host              HostConfig    `yaml:"host"`
+}
+
+// BeaconConfig sets up the signed UDP beacon Nodes broadcast and Hosts
+// listen for
+type BeaconConfig struct {
+	Enabled     bool          `yaml:"enabled"` // Only takes effect with a cluster secret
+	Address     string        `yaml:"address"` // IP beacons are sent to, a broadcast or unicast address
+	Port        int           `yaml:"port"`    // UDP port beacons are sent to and Hosts listen on
+	Interval    time.Duration `yaml:"interval"`
+	NodeAddress string        `yaml:"node_address"` // IP Hosts reach the Node at, the beacon's sender address when empty
+}
+
+// TracingConfig selects where spans are exported to
+type TracingConfig struct {
+	Exporter     string `yaml:"exporter"`      // otlp, stdout or none
+	OTLPEndpoint string `yaml:"otlp_endpoint"` // Standard OTEL_EXPORTER_OTLP_* variables apply when empty
+}
+
+// TLSConfig holds the certificate paths of a Node or Host
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`      // Served over https when set together with Key
+	Key      string `yaml:"key"`       // Private key of Cert
+	ClientCA string `yaml:"client_ca"` // Client certificates must chain to this bundle when set
+	CA       string `yaml:"ca"`        // Trusted for outgoing connections in addition to the system roots
+}
+
+// RedisConfig locates the Node's Redis
+type RedisConfig struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// MongoConfig locates the Node's MongoDB
+type MongoConfig struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+}
+
+// NodeConfig holds the settings of a Node. Hosts reach their Node on Port.
+type NodeConfig struct {
+	Port                int              `yaml:"port"`
+	AdminToken          string           `yaml:"admin_token"` // Required unless OpenAccess is set
+	OpenAccess          bool             `yaml:"open_access"` // Client and admin routes accept any caller
+	Scheduling          SchedulingConfig `yaml:"scheduling"`
+	AutoLoad            bool             `yaml:"auto_load"` // Load a model for chats without an active host
+	LoadTimeout         time.Duration    `yaml:"load_timeout"`
+	HostTTL             time.Duration    `yaml:"host_ttl"`              // How long a host record is kept in Redis
+	InventoryRefresh    time.Duration    `yaml:"inventory_refresh"`     // How often the model lists of hosts are pulled, 0 disables it
+	AuditBodies         bool             `yaml:"audit_bodies"`          // Store prompts and responses in the audit log
+	AuditRetention      time.Duration    `yaml:"audit_retention"`       // How long audit records are kept
+	HostCooldown        time.Duration    `yaml:"host_cooldown"`         // How long a failing host is avoided
+	MaxChatAttempts     int              `yaml:"max_chat_attempts"`     // Hosts tried for a chat before giving up
+	MaxMissedHeartbeats int              `yaml:"max_missed_heartbeats"` // Heartbeats a host may miss before it is evicted
+}
+
+// SchedulingConfig selects the scheduling strategy of each model: round-robin,
+// least-connections, weighted, latency or consistent-hash
+type SchedulingConfig struct {
+	Default string            `yaml:"default"` // Strategy of models without their own
+	Models  map[string]string `yaml:"models"`  // Strategy by model name
+}
+
+// HostConfig holds the settings of a Host
+type HostConfig struct {
+	Port        int           `yaml:"port"`
+	Capacity    int           `yaml:"capacity"`     // Scheduling weight of the host
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // Unused models are unloaded after it, 0 disables unloading
+	OllamaPort  int           `yaml:"ollama_port"`
+	NodeScheme  string        `yaml:"node_scheme"`  // http or https, for Nodes found by ARP scanning
+	NodeURL     string        `yaml:"node_url"`     // Base URL of the Node, skips discovery when set
+	ARPFallback bool          `yaml:"arp_fallback"` // Ping the ARP table neighbours once when no Node was found otherwise
+}
+
+// Default returns the settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		LogLevel:          "info",
+		ClusterID:         "deepgate",
+		RequestTimeout:    10 * time.Second,
+		ShutdownTimeout:   30 * time.Second,
+		HeartbeatInterval: 10 * time.Second,
+		MDNS:              true,
+		Beacon: BeaconConfig{
+			Enabled:  true,
+			Address:  "255.255.255.255",
+			Port:     9999,
+			Interval: 5 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+		Redis: RedisConfig{
+			Address: "localhost:6379",
+		},
+		Mongo: MongoConfig{
+			URI:      "mongodb://localhost:27017",
+			Database: "network_discovery",
+		},
+		Node: NodeConfig{
+			Port:                8080,
+			AutoLoad:            true,
+			LoadTimeout:         5 * time.Minute,
+			HostTTL:             24 * time.Hour,
+			InventoryRefresh:    time.Minute,
+			AuditRetention:      90 * 24 * time.Hour,
+			HostCooldown:        30 * time.Second,
+			MaxChatAttempts:     3,
+			MaxMissedHeartbeats: 3,
+			Scheduling: SchedulingConfig{
+				Default: "least-connections",
+			},
+		},
+		Host: HostConfig{
+			Port:        9090,
+			Capacity:    1,
+			IdleTimeout: 15 * time.Minute,
+			OllamaPort:  11434,
+			NodeScheme:  "http",
+		},
+	}
+}
+
+// setting is a value that can be overridden by an environment variable and a
+// flag named after its path in the YAML file
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	target interface{} // *string, *int, *bool or *time.Duration
+}
+
+// settings lists the overridable values of c
+func (c *Config) settings() []setting {
+	return []setting{
+		{"log_level", "DEEPGATE_LOG_LEVEL", "log level: debug, info, warn or error", &c.LogLevel},
+		{"cluster_id", "DEEPGATE_CLUSTER_ID", "cluster hosts follow beacons of", &c.ClusterID},
+		{"cluster_secret", "DEEPGATE_CLUSTER_SECRET", "secret shared by a node and its hosts", &c.ClusterSecret},
+		{"request_timeout", "DEEPGATE_REQUEST_TIMEOUT", "timeout of non-streaming calls between node, host and Ollama", &c.RequestTimeout},
+		{"shutdown_timeout", "DEEPGATE_SHUTDOWN_TIMEOUT", "how long in-flight requests may take to finish on shutdown", &c.ShutdownTimeout},
+		{"heartbeat_interval", "DEEPGATE_HEARTBEAT_INTERVAL", "how often hosts send heartbeats and nodes expect them", &c.HeartbeatInterval},
+		{"mdns", "DEEPGATE_MDNS", "advertise and discover nodes over mDNS", &c.MDNS},
+		{"beacon.enabled", "DEEPGATE_BEACON", "send and listen for UDP node beacons", &c.Beacon.Enabled},
+		{"beacon.address", "DEEPGATE_BEACON_ADDRESS", "IP node beacons are sent to", &c.Beacon.Address},
+		{"beacon.port", "DEEPGATE_BEACON_PORT", "UDP port of node beacons", &c.Beacon.Port},
+		{"beacon.interval", "DEEPGATE_BEACON_INTERVAL", "how often the node sends a beacon", &c.Beacon.Interval},
+		{"beacon.node_address", "DEEPGATE_BEACON_NODE_ADDRESS", "IP hosts reach the node at, the beacon's sender address when empty", &c.Beacon.NodeAddress},
+		{"tracing.exporter", "DEEPGATE_TRACE_EXPORTER", "trace exporter: otlp, stdout or none", &c.Tracing.Exporter},
+		{"tracing.otlp_endpoint", "DEEPGATE_OTLP_ENDPOINT", "OTLP/HTTP endpoint URL", &c.Tracing.OTLPEndpoint},
+		{"tls.cert", "DEEPGATE_TLS_CERT", "TLS certificate file", &c.TLS.Cert},
+		{"tls.key", "DEEPGATE_TLS_KEY", "TLS private key file", &c.TLS.Key},
+		{"tls.client_ca", "DEEPGATE_TLS_CLIENT_CA", "CA bundle client certificates must chain to", &c.TLS.ClientCA},
+		{"tls.ca", "DEEPGATE_TLS_CA", "CA bundle trusted for outgoing connections", &c.TLS.CA},
+		{"redis.address", "DEEPGATE_REDIS_ADDRESS", "Redis address", &c.Redis.Address},
+		{"redis.password", "DEEPGATE_REDIS_PASSWORD", "Redis password", &c.Redis.Password},
+		{"redis.db", "DEEPGATE_REDIS_DB", "Redis database number", &c.Redis.DB},
+		{"mongo.uri", "DEEPGATE_MONGO_URI", "MongoDB connection URI", &c.Mongo.URI},
+		{"mongo.database", "DEEPGATE_MONGO_DATABASE", "MongoDB database", &c.Mongo.Database},
+		{"node.port", "DEEPGATE_NODE_PORT", "port the node listens on", &c.Node.Port},
+		{"node.admin_token", "DEEPGATE_ADMIN_TOKEN", "admin token, required unless open access is enabled", &c.Node.AdminToken},
+		{"node.open_access", "DEEPGATE_OPEN_ACCESS", "let any caller use the client and admin routes", &c.Node.OpenAccess},
+		{"node.scheduling.default", "DEEPGATE_SCHEDULING_DEFAULT", "scheduling strategy of models without their own", &c.Node.Scheduling.Default},
+		{"node.auto_load", "DEEPGATE_AUTO_LOAD", "load models for chats without an active host", &c.Node.AutoLoad},
+		{"node.load_timeout", "DEEPGATE_LOAD_TIMEOUT", "how long a model load may take", &c.Node.LoadTimeout},
+		{"node.host_ttl", "DEEPGATE_HOST_TTL", "how long host records are kept", &c.Node.HostTTL},
+		{"node.inventory_refresh", "DEEPGATE_INVENTORY_REFRESH", "how often host model lists are pulled, 0 disables it", &c.Node.InventoryRefresh},
+		{"node.audit_bodies", "DEEPGATE_AUDIT_BODIES", "store prompts and responses in the audit log", &c.Node.AuditBodies},
+		{"node.audit_retention", "DEEPGATE_AUDIT_RETENTION", "how long audit records are kept", &c.Node.AuditRetention},
+		{"node.host_cooldown", "DEEPGATE_HOST_COOLDOWN", "how long a failing host is avoided", &c.Node.HostCooldown},
+		{"node.max_chat_attempts", "DEEPGATE_MAX_CHAT_ATTEMPTS", "hosts tried for a chat before giving up", &c.Node.MaxChatAttempts},
+		{"node.max_missed_heartbeats", "DEEPGATE_MAX_MISSED_HEARTBEATS", "heartbeats a host may miss before it is evicted", &c.Node.MaxMissedHeartbeats},
+		{"host.port", "DEEPGATE_HOST_PORT", "port the host listens on", &c.Host.Port},
+		{"host.capacity", "DEEPGATE_HOST_CAPACITY", "scheduling weight of the host", &c.Host.Capacity},
+		{"host.idle_timeout", "DEEPGATE_IDLE_TIMEOUT", "unload models unused for this long, 0 disables it", &c.Host.IdleTimeout},
+		{"host.ollama_port", "DEEPGATE_OLLAMA_PORT", "port of the local Ollama", &c.Host.OllamaPort},
+		{"host.node_scheme", "DEEPGATE_NODE_SCHEME", "scheme nodes found by ARP scanning are reached with: http or https", &c.Host.NodeScheme},
+		{"host.node_url", "DEEPGATE_NODE_URL", "base URL of the node, skips discovery", &c.Host.NodeURL},
+		{"host.arp_fallback", "DEEPGATE_ARP_FALLBACK", "ping ARP table neighbours once when no node was found otherwise", &c.Host.ARPFallback},
+	}
+}
+
+// flagAliases are shorter flag names of settings
+var flagAliases = map[string]string{
+	"node-url": "host.node_url",
+}
+
+// Load reads the configuration from the file named by -config or
+// DEEPGATE_CONFIG, the environment and args, and validates it
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("deepgate", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("DEEPGATE_CONFIG"), "YAML configuration file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.name] = fs.String(s.name, "", fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+	for alias, name := range flagAliases {
+		flagValues[alias] = fs.String(alias, "", "shorthand for -"+name)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		// A variable set to "" still overrides, clearing the file's value
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", s.env, err)
+			}
+		}
+	}
+
+	// Only flags given on the command line override the file and environment
+	passed := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if name, ok := flagAliases[f.Name]; ok {
+			passed[name] = f.Name
+		} else {
+			passed[f.Name] = f.Name
+		}
+	})
+	for _, s := range settings {
+		if flagName, ok := passed[s.name]; ok {
+			if err := s.set(*flagValues[flagName]); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %v", flagName, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overrides the settings present in a YAML file
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// set parses value into the setting's target
+func (s setting) set(value string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = value
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	default:
+		return fmt.Errorf("unsupported setting type %T", s.target)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(oneOf(c.LogLevel, "debug", "info", "warn", "error"), "log_level must be debug, info, warn or error, got %q", c.LogLevel)
+	check(oneOf(c.Tracing.Exporter, "otlp", "stdout", "none", ""), "tracing.exporter must be otlp, stdout or none, got %q", c.Tracing.Exporter)
+	check(c.RequestTimeout > 0, "request_timeout must be positive")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.HeartbeatInterval > 0, "heartbeat_interval must be positive")
+	check(c.ClusterID != "", "cluster_id is required")
+	check(net.ParseIP(c.Beacon.Address) != nil, "beacon.address must be an IP address, got %q", c.Beacon.Address)
+	check(validPort(c.Beacon.Port), "beacon.port must be between 1 and 65535, got %d", c.Beacon.Port)
+	check(c.Beacon.Interval > 0, "beacon.interval must be positive")
+	check(c.Beacon.NodeAddress == "" || net.ParseIP(c.Beacon.NodeAddress) != nil, "beacon.node_address must be an IP address, got %q", c.Beacon.NodeAddress)
+	check((c.TLS.Cert == "") == (c.TLS.Key == ""), "tls.cert and tls.key must be set together")
+
+	check(c.Redis.Address != "", "redis.address is required")
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+	check(c.Mongo.URI != "", "mongo.uri is required")
+	check(c.Mongo.Database != "", "mongo.database is required")
+
+	check(validPort(c.Node.Port), "node.port must be between 1 and 65535, got %d", c.Node.Port)
+	check(c.Node.LoadTimeout > 0, "node.load_timeout must be positive")
+	check(c.Node.HostTTL > 0, "node.host_ttl must be positive")
+	check(c.Node.InventoryRefresh >= 0, "node.inventory_refresh must not be negative")
+	check(c.Node.AuditRetention >= time.Second, "node.audit_retention must be at least 1s")
+	check(c.Node.HostCooldown >= 0, "node.host_cooldown must not be negative")
+	check(c.Node.MaxChatAttempts >= 1, "node.max_chat_attempts must be at least 1, got %d", c.Node.MaxChatAttempts)
+	check(c.Node.MaxMissedHeartbeats >= 1, "node.max_missed_heartbeats must be at least 1, got %d", c.Node.MaxMissedHeartbeats)
+	check(c.Node.Scheduling.Default != "", "node.scheduling.default is required")
+	for model, strategy := range c.Node.Scheduling.Models {
+		check(model != "" && strategy != "", "node.scheduling.models needs a model name and a strategy, got %q: %q", model, strategy)
+	}
+
+	check(validPort(c.Host.Port), "host.port must be between 1 and 65535, got %d", c.Host.Port)
+	check(c.Host.Capacity >= 1, "host.capacity must be at least 1, got %d", c.Host.Capacity)
+	check(c.Host.IdleTimeout >= 0, "host.idle_timeout must not be negative")
+	check(validPort(c.Host.OllamaPort), "host.ollama_port must be between 1 and 65535, got %d", c.Host.OllamaPort)
+	check(oneOf(c.Host.NodeScheme, "http", "https"), "host.node_scheme must be http or https, got %q", c.Host.NodeScheme)
+	if c.Host.NodeURL != "" {
+		nodeURL, err := url.Parse(c.Host.NodeURL)
+		check(err == nil && (nodeURL.Scheme == "http" || nodeURL.Scheme == "https") && nodeURL.Host != "",
+			"host.node_url must be an http or https URL, got %q", c.Host.NodeURL)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// oneOf reports whether value is one of options, ignoring case
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if strings.EqualFold(value, option) {
+			return true
+		}
+	}
+	return false
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
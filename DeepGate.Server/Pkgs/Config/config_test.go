@@ -0,0 +1,241 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a YAML config file to a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "deepgate.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", "")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Node.Port != 8080 || cfg.Host.Port != 9090 || cfg.RequestTimeout != 10*time.Second {
+		t.Errorf("unexpected defaults: node.port=%d host.port=%d request_timeout=%v", cfg.Node.Port, cfg.Host.Port, cfg.RequestTimeout)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+log_level: debug
+request_timeout: 20s
+node:
+  port: 8001
+  load_timeout: 1m
+host:
+  capacity: 2
+`)
+
+	t.Setenv("DEEPGATE_CONFIG", path)
+	t.Setenv("DEEPGATE_NODE_PORT", "8002")
+	t.Setenv("DEEPGATE_HOST_CAPACITY", "3")
+
+	cfg, err := Load([]string{"-host.capacity", "4"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"default", cfg.Host.Port, 9090},
+		{"file over default", cfg.LogLevel, "debug"},
+		{"file over default", cfg.RequestTimeout, 20 * time.Second},
+		{"file over default", cfg.Node.LoadTimeout, time.Minute},
+		{"env over file", cfg.Node.Port, 8002},
+		{"flag over env", cfg.Host.Capacity, 4},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadEmptyEnvClearsFile(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", writeConfig(t, "tls:\n  cert: server.pem\n  key: server.key\n"))
+	t.Setenv("DEEPGATE_TLS_CERT", "")
+	t.Setenv("DEEPGATE_TLS_KEY", "")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.TLS.Cert != "" || cfg.TLS.Key != "" {
+		t.Errorf("tls = %q %q, want both cleared by the environment", cfg.TLS.Cert, cfg.TLS.Key)
+	}
+}
+
+func TestLoadScheduling(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", writeConfig(t, `
+heartbeat_interval: 5s
+node:
+  host_cooldown: 1m
+  max_chat_attempts: 2
+  max_missed_heartbeats: 4
+  scheduling:
+    default: round-robin
+    models:
+      llama3:8b: consistent-hash
+      qwen2:1.5b: latency
+`))
+	t.Setenv("DEEPGATE_SCHEDULING_DEFAULT", "weighted")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	scheduling := cfg.Node.Scheduling
+	if scheduling.Default != "weighted" || len(scheduling.Models) != 2 ||
+		scheduling.Models["llama3:8b"] != "consistent-hash" || scheduling.Models["qwen2:1.5b"] != "latency" {
+		t.Errorf("scheduling = %+v", scheduling)
+	}
+	if cfg.HeartbeatInterval != 5*time.Second || cfg.Node.HostCooldown != time.Minute ||
+		cfg.Node.MaxChatAttempts != 2 || cfg.Node.MaxMissedHeartbeats != 4 {
+		t.Errorf("heartbeat_interval=%v host_cooldown=%v max_chat_attempts=%d max_missed_heartbeats=%d",
+			cfg.HeartbeatInterval, cfg.Node.HostCooldown, cfg.Node.MaxChatAttempts, cfg.Node.MaxMissedHeartbeats)
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", "deepgate.example.yaml")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// The example lists every default
+	cfg.Node.Scheduling.Models = nil
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("example config = %+v, want the defaults %+v", cfg, Default())
+	}
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", writeConfig(t, "log_level: warn\n"))
+	path := writeConfig(t, "log_level: error\n")
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.LogLevel != "error" {
+		t.Errorf("log_level = %q, want the file passed with -config", cfg.LogLevel)
+	}
+}
+
+func TestLoadNodeURLAlias(t *testing.T) {
+	t.Setenv("DEEPGATE_CONFIG", "")
+	t.Setenv("DEEPGATE_NODE_URL", "http://10.0.0.1:8080")
+
+	cfg, err := Load([]string{"--node-url", "https://10.0.0.5:8080"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Host.NodeURL != "https://10.0.0.5:8080" {
+		t.Errorf("host.node_url = %q, want the --node-url flag", cfg.Host.NodeURL)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr []string
+	}{
+		{
+			name:    "unknown file key",
+			file:    "node:\n  prot: 8080\n",
+			wantErr: []string{"field prot not found"},
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"DEEPGATE_NODE_PORT": "eighty"},
+			wantErr: []string{"invalid DEEPGATE_NODE_PORT"},
+		},
+		{
+			name:    "invalid flag value",
+			args:    []string{"-request_timeout", "soon"},
+			wantErr: []string{"invalid -request_timeout"},
+		},
+		{
+			name: "every invalid setting is reported",
+			args: []string{"-log_level", "loud", "-node.port", "0", "-host.capacity", "0", "-tls.cert", "server.pem"},
+			wantErr: []string{
+				`log_level must be debug, info, warn or error, got "loud"`,
+				"node.port must be between 1 and 65535, got 0",
+				"host.capacity must be at least 1, got 0",
+				"tls.cert and tls.key must be set together",
+			},
+		},
+		{
+			name:    "invalid node url",
+			args:    []string{"--node-url", "10.0.0.5:8080"},
+			wantErr: []string{"host.node_url must be an http or https URL"},
+		},
+		{
+			name:    "invalid beacon node address",
+			env:     map[string]string{"DEEPGATE_BEACON_NODE_ADDRESS": "node.local"},
+			wantErr: []string{`beacon.node_address must be an IP address, got "node.local"`},
+		},
+		{
+			name:    "empty env value of a number",
+			env:     map[string]string{"DEEPGATE_NODE_PORT": ""},
+			wantErr: []string{"invalid DEEPGATE_NODE_PORT"},
+		},
+		{
+			name: "invalid node tuning",
+			file: "heartbeat_interval: 0s\nnode:\n  max_chat_attempts: 0\n  max_missed_heartbeats: 0\n  scheduling:\n    default: \"\"\n",
+			wantErr: []string{
+				"heartbeat_interval must be positive",
+				"node.max_chat_attempts must be at least 1, got 0",
+				"node.max_missed_heartbeats must be at least 1, got 0",
+				"node.scheduling.default is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = writeConfig(t, tt.file)
+			}
+			t.Setenv("DEEPGATE_CONFIG", path)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := Load(tt.args)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
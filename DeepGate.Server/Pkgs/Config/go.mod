@@ -0,0 +1,5 @@
+module Pkgs/Config
+
+go 1.23.3
+
+require gopkg.in/yaml.v3 v3.0.1
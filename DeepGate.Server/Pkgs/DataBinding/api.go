@@ -1,5 +1,14 @@
 package databinding
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
 // Message represents a single message in a chat
 type Message struct {
 	Role    string `json:"role"`
@@ -8,17 +17,156 @@ type Message struct {
 
 // ChatCompletion represents a chat completion request
 type ChatCompletion struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	ConversationID string          `json:"conversation_id,omitempty"` // Stored conversation to continue on /node/chat, also used for host affinity
+	Stream         *bool           `json:"stream,omitempty"`          // Defaults to true
+	Options        *ChatOptions    `json:"options,omitempty"`
+	Format         json.RawMessage `json:"format,omitempty"`     // "json" or a JSON schema object
+	KeepAlive      string          `json:"keep_alive,omitempty"` // Duration such as "5m", or seconds
+}
+
+// IsStreaming reports whether the response should be streamed as events
+func (c ChatCompletion) IsStreaming() bool {
+	return c.Stream == nil || *c.Stream
+}
+
+// ChatOptions are the Ollama model options of a chat, unset options keep the
+// model's defaults
+type ChatOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	NumCtx           *int     `json:"num_ctx,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"` // -1 is unlimited, -2 fills the context
+	Seed             *int     `json:"seed,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	RepeatPenalty    *float64 `json:"repeat_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+// Validate checks a chat request before it is sent to a model
+func (c ChatCompletion) Validate() error {
+	if c.Model == "" {
+		return errors.New("model is required")
+	}
+
+	// Without messages Ollama only loads the model, a conversation supplies
+	// the earlier messages
+	if len(c.Messages) == 0 && c.ConversationID == "" {
+		return errors.New("messages are required unless a conversation_id is continued")
+	}
+
+	if len(c.Format) > 0 {
+		var format string
+		trimmed := bytes.TrimSpace(c.Format)
+		if json.Unmarshal(trimmed, &format) == nil {
+			if format != "json" {
+				return fmt.Errorf("format must be \"json\" or a JSON schema object, got %q", format)
+			}
+		} else if len(trimmed) == 0 || trimmed[0] != '{' || !json.Valid(trimmed) {
+			return errors.New("format must be \"json\" or a JSON schema object")
+		}
+	}
+
+	if c.KeepAlive != "" {
+		if _, err := time.ParseDuration(c.KeepAlive); err != nil {
+			if _, err := strconv.Atoi(c.KeepAlive); err != nil {
+				return fmt.Errorf("invalid keep_alive %q", c.KeepAlive)
+			}
+		}
+	}
+
+	if c.Options != nil {
+		return c.Options.Validate()
+	}
+	return nil
 }
 
-// StreamResponse represents the structure of each streaming response chunk
+// Validate checks that every set option is within the range Ollama accepts
+func (o ChatOptions) Validate() error {
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return errors.New("temperature must be between 0 and 2")
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		return errors.New("top_p must be between 0 and 1")
+	}
+	if o.TopK != nil && *o.TopK < 0 {
+		return errors.New("top_k must not be negative")
+	}
+	if o.NumCtx != nil && *o.NumCtx <= 0 {
+		return errors.New("num_ctx must be positive")
+	}
+	if o.NumPredict != nil && *o.NumPredict < -2 {
+		return errors.New("num_predict must be -1, -2 or not negative")
+	}
+	if o.RepeatPenalty != nil && *o.RepeatPenalty < 0 {
+		return errors.New("repeat_penalty must not be negative")
+	}
+	if o.PresencePenalty != nil && (*o.PresencePenalty < -2 || *o.PresencePenalty > 2) {
+		return errors.New("presence_penalty must be between -2 and 2")
+	}
+	if o.FrequencyPenalty != nil && (*o.FrequencyPenalty < -2 || *o.FrequencyPenalty > 2) {
+		return errors.New("frequency_penalty must be between -2 and 2")
+	}
+	for _, stop := range o.Stop {
+		if stop == "" {
+			return errors.New("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
+
+// ResponseMessage is the message generated by the model
+type ResponseMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// StreamResponse represents the structure of each streaming response chunk,
+// the usage is only set on the final chunk
 type StreamResponse struct {
-	Model     string `json:"model"`
-	CreatedAt string `json:"created_at"`
-	Message   struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-	} `json:"message"`
-	Done bool `json:"done"`
+	Model      string          `json:"model"`
+	CreatedAt  string          `json:"created_at"`
+	Message    ResponseMessage `json:"message"`
+	Done       bool            `json:"done"`
+	DoneReason string          `json:"done_reason,omitempty"`
+	Usage
+}
+
+// ChatResponse is the aggregated result of a non-streaming chat
+type ChatResponse struct {
+	Model      string          `json:"model"`
+	Message    ResponseMessage `json:"message"`
+	DoneReason string          `json:"done_reason"`
+	Usage
+}
+
+// NewChatResponse creates an empty response to collect stream events into
+func NewChatResponse(model string) *ChatResponse {
+	return &ChatResponse{
+		Model:   model,
+		Message: ResponseMessage{Role: "assistant"},
+	}
+}
+
+// Add folds a stream event into the response
+func (r *ChatResponse) Add(event StreamEvent) {
+	switch event.Type {
+	case EventToken:
+		r.Message.Content += event.Content
+	case EventThinking:
+		r.Message.Thinking += event.Content
+	case EventToolCall:
+		r.Message.ToolCalls = append(r.Message.ToolCalls, event.ToolCalls...)
+	case EventUsage:
+		if event.Usage != nil {
+			r.Usage = *event.Usage
+		}
+	case EventDone:
+		r.DoneReason = event.DoneReason
+	}
 }
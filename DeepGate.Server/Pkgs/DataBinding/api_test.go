@@ -0,0 +1,147 @@
+package databinding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func intOption(v int) *int { return &v }
+
+func floatOption(v float64) *float64 { return &v }
+
+func TestChatCompletionValidate(t *testing.T) {
+	hello := []Message{{Role: "user", Content: "Hello"}}
+
+	tests := []struct {
+		name    string
+		chat    ChatCompletion
+		wantErr string
+	}{
+		{name: "minimal", chat: ChatCompletion{Model: "llama3", Messages: hello}},
+		{name: "no model", chat: ChatCompletion{Messages: hello}, wantErr: "model is required"},
+		{name: "no messages", chat: ChatCompletion{Model: "llama3"}, wantErr: "messages are required"},
+		{name: "empty messages", chat: ChatCompletion{Model: "llama3", Messages: []Message{}}, wantErr: "messages are required"},
+		{name: "conversation without new messages", chat: ChatCompletion{Model: "llama3", ConversationID: "c1"}},
+
+		{name: "json format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(`"json"`)}},
+		{name: "schema format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(` {"type":"object"} `)}},
+		{name: "other string format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(`"yaml"`)}, wantErr: `got "yaml"`},
+		{name: "array format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(`["json"]`)}, wantErr: "JSON schema object"},
+		{name: "number format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(`1`)}, wantErr: "JSON schema object"},
+		{name: "invalid JSON format", chat: ChatCompletion{Model: "llama3", Messages: hello, Format: json.RawMessage(`{"type":`)}, wantErr: "JSON schema object"},
+
+		{name: "keep_alive duration", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "5m"}},
+		{name: "keep_alive seconds", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "300"}},
+		{name: "keep_alive forever", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "-1"}},
+		{name: "keep_alive unload", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "0"}},
+		{name: "keep_alive without unit", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "5 minutes"}, wantErr: "invalid keep_alive"},
+		{name: "keep_alive fraction", chat: ChatCompletion{Model: "llama3", Messages: hello, KeepAlive: "1.5"}, wantErr: "invalid keep_alive"},
+
+		{name: "invalid options", chat: ChatCompletion{Model: "llama3", Messages: hello, Options: &ChatOptions{TopK: intOption(-1)}}, wantErr: "top_k"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateErr(t, tt.chat.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestChatOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ChatOptions
+		wantErr string
+	}{
+		{name: "unset", options: ChatOptions{}},
+		{name: "temperature min", options: ChatOptions{Temperature: floatOption(0)}},
+		{name: "temperature max", options: ChatOptions{Temperature: floatOption(2)}},
+		{name: "temperature too high", options: ChatOptions{Temperature: floatOption(2.1)}, wantErr: "temperature"},
+		{name: "temperature negative", options: ChatOptions{Temperature: floatOption(-0.1)}, wantErr: "temperature"},
+		{name: "top_p", options: ChatOptions{TopP: floatOption(0.9)}},
+		{name: "top_p too high", options: ChatOptions{TopP: floatOption(1.5)}, wantErr: "top_p"},
+		{name: "top_k zero", options: ChatOptions{TopK: intOption(0)}},
+		{name: "top_k negative", options: ChatOptions{TopK: intOption(-1)}, wantErr: "top_k"},
+		{name: "num_ctx", options: ChatOptions{NumCtx: intOption(4096)}},
+		{name: "num_ctx zero", options: ChatOptions{NumCtx: intOption(0)}, wantErr: "num_ctx"},
+		{name: "num_predict unlimited", options: ChatOptions{NumPredict: intOption(-1)}},
+		{name: "num_predict fill context", options: ChatOptions{NumPredict: intOption(-2)}},
+		{name: "num_predict invalid", options: ChatOptions{NumPredict: intOption(-3)}, wantErr: "num_predict"},
+		{name: "repeat_penalty negative", options: ChatOptions{RepeatPenalty: floatOption(-1)}, wantErr: "repeat_penalty"},
+		{name: "presence_penalty", options: ChatOptions{PresencePenalty: floatOption(-2)}},
+		{name: "presence_penalty too low", options: ChatOptions{PresencePenalty: floatOption(-2.5)}, wantErr: "presence_penalty"},
+		{name: "frequency_penalty too high", options: ChatOptions{FrequencyPenalty: floatOption(2.5)}, wantErr: "frequency_penalty"},
+		{name: "stop sequences", options: ChatOptions{Stop: []string{"END", "\n\n"}}},
+		{name: "empty stop sequence", options: ChatOptions{Stop: []string{"END", ""}}, wantErr: "stop sequences"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateErr(t, tt.options.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestChatCompletionDecode(t *testing.T) {
+	var chat ChatCompletion
+	body := `{"model":"llama3","messages":[{"role":"user","content":"Hi"}],"stream":false,"format":{"type":"object"},"keep_alive":"10m","options":{"temperature":0.5}}`
+	if err := json.Unmarshal([]byte(body), &chat); err != nil {
+		t.Fatal(err)
+	}
+
+	if chat.IsStreaming() {
+		t.Error("stream false decoded as streaming")
+	}
+	if string(chat.Format) != `{"type":"object"}` || chat.KeepAlive != "10m" || *chat.Options.Temperature != 0.5 {
+		t.Errorf("decoded chat = %+v", chat)
+	}
+	if err := chat.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	// Streaming is the default
+	if !(ChatCompletion{}).IsStreaming() {
+		t.Error("chat without stream is not streamed")
+	}
+}
+
+func TestChatResponseAdd(t *testing.T) {
+	response := NewChatResponse("llama3")
+	for _, event := range []StreamEvent{
+		{Type: EventThinking, Content: "Let me "},
+		{Type: EventThinking, Content: "think"},
+		{Type: EventToken, Content: "Hello"},
+		{Type: EventToken, Content: " world"},
+		{Type: EventToolCall, ToolCalls: []ToolCall{{Function: ToolCallFunction{Name: "a"}}}},
+		{Type: EventToolCall, ToolCalls: []ToolCall{{Function: ToolCallFunction{Name: "b"}}}},
+		{Type: EventUsage, Usage: &Usage{PromptEvalCount: 3, EvalCount: 5}},
+		{Type: "progress", Content: "ignored"},
+		{Type: EventDone, DoneReason: "stop"},
+	} {
+		response.Add(event)
+	}
+
+	message := response.Message
+	if message.Role != "assistant" || message.Content != "Hello world" || message.Thinking != "Let me think" {
+		t.Errorf("message = %+v", message)
+	}
+	if len(message.ToolCalls) != 2 || message.ToolCalls[1].Function.Name != "b" {
+		t.Errorf("tool calls = %+v", message.ToolCalls)
+	}
+	if response.EvalCount != 5 || response.DoneReason != "stop" || response.Model != "llama3" {
+		t.Errorf("response = %+v", response)
+	}
+}
+
+// checkValidateErr fails the test unless err contains want, or is nil when
+// want is empty
+func checkValidateErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+		t.Fatalf("error = %v, want one containing %q", err, want)
+	}
+}
@@ -0,0 +1,169 @@
+package databinding
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nodes broadcast a Beacon over UDP every few seconds so that Hosts find them
+// on networks where the ARP table is still empty. Beacons are signed with the
+// cluster secret, Hosts ignore beacons of other clusters and forged ones.
+// Beacons are never sent or accepted without a cluster secret.
+
+// maxBeaconSize bounds the datagrams read by ListenBeacons
+const maxBeaconSize = 2048
+
+// ErrNoClusterSecret is returned for beacons without a secret to sign them with
+var ErrNoClusterSecret = errors.New("beacons require a cluster secret")
+
+// Beacon announces a Node
+type Beacon struct {
+	ClusterID string `json:"cluster_id"`
+	Address   string `json:"address,omitempty"` // IP of the Node, the sender's address when empty
+	Port      int    `json:"port"`
+	Scheme    string `json:"scheme,omitempty"` // http or https, http when empty
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"` // HMAC of the other fields with the cluster secret
+}
+
+// Sign signs the beacon with the cluster secret
+func (b *Beacon) Sign(secret string) {
+	b.Signature = hex.EncodeToString(b.mac(secret))
+}
+
+// Verify checks the signature of the beacon and that it was sent recently
+func (b Beacon) Verify(secret string, now time.Time) error {
+	signature, err := hex.DecodeString(b.Signature)
+	if err != nil || !hmac.Equal(signature, b.mac(secret)) {
+		return ErrInvalidSignature
+	}
+
+	skew := now.Sub(time.Unix(b.Timestamp, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("beacon is %v off the host clock", skew.Round(time.Second))
+	}
+	return nil
+}
+
+// NodeURL returns the base URL of the Node, reached at sender unless the
+// beacon carries an address
+func (b Beacon) NodeURL(sender net.IP) string {
+	address := b.Address
+	if address == "" {
+		address = sender.String()
+	}
+	scheme := "http"
+	if b.Scheme == "https" {
+		scheme = b.Scheme
+	}
+	return scheme + "://" + net.JoinHostPort(address, strconv.Itoa(b.Port))
+}
+
+// mac authenticates every field of the beacon but the signature
+func (b Beacon) mac(secret string) []byte {
+	fields := []string{
+		"deepgate-beacon",
+		b.ClusterID,
+		b.Address,
+		strconv.Itoa(b.Port),
+		b.Scheme,
+		strconv.FormatInt(b.Timestamp, 10),
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	return mac.Sum(nil)
+}
+
+// BeaconSender sends beacons to a broadcast or unicast address
+type BeaconSender struct {
+	conn    net.PacketConn
+	address *net.UDPAddr
+}
+
+// NewBeaconSender opens a UDP socket sending to ip:port
+func NewBeaconSender(ip string, port int) (*BeaconSender, error) {
+	address, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid beacon address: %v", err)
+	}
+	// Go enables broadcasting on UDP sockets
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open beacon socket: %v", err)
+	}
+	return &BeaconSender{conn: conn, address: address}, nil
+}
+
+// Send stamps, signs and sends a beacon
+func (s *BeaconSender) Send(beacon Beacon, secret string) error {
+	if secret == "" {
+		return ErrNoClusterSecret
+	}
+	beacon.Timestamp = time.Now().Unix()
+	beacon.Sign(secret)
+
+	data, err := json.Marshal(beacon)
+	if err != nil {
+		return fmt.Errorf("failed to marshal beacon: %v", err)
+	}
+	if _, err := s.conn.WriteTo(data, s.address); err != nil {
+		return fmt.Errorf("failed to send beacon: %v", err)
+	}
+	return nil
+}
+
+func (s *BeaconSender) Close() error {
+	return s.conn.Close()
+}
+
+// ListenBeacons calls found with the base URL of every Node announcing
+// clusterID with a valid signature, until ctx is done. Other datagrams are
+// ignored.
+func ListenBeacons(ctx context.Context, port int, clusterID, secret string, found func(nodeURL string)) error {
+	if secret == "" {
+		return ErrNoClusterSecret
+	}
+	conn, err := net.ListenPacket("udp4", ":"+strconv.Itoa(port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for beacons: %v", err)
+	}
+
+	// Unblock ReadFrom once ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	buffer := make([]byte, maxBeaconSize)
+	for {
+		n, sender, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read beacon: %v", err)
+		}
+
+		var beacon Beacon
+		if json.Unmarshal(buffer[:n], &beacon) != nil || beacon.ClusterID != clusterID {
+			continue
+		}
+		if beacon.Verify(secret, time.Now()) != nil {
+			continue
+		}
+		udpSender, ok := sender.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		found(beacon.NodeURL(udpSender.IP))
+	}
+}
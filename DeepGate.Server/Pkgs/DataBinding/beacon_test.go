@@ -0,0 +1,122 @@
+package databinding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// freeUDPPort returns a UDP port that is free on 127.0.0.1
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// sendRaw sends a beacon as is, without stamping or signing it
+func sendRaw(t *testing.T, sender *BeaconSender, beacon Beacon) {
+	t.Helper()
+	data, err := json.Marshal(beacon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.conn.WriteTo(data, sender.address); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeaconLoopback(t *testing.T) {
+	const clusterID = "lab"
+	port := freeUDPPort(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	found := make(chan string, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- ListenBeacons(ctx, port, clusterID, testSecret, func(nodeURL string) { found <- nodeURL })
+	}()
+
+	sender, err := NewBeaconSender("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("NewBeaconSender: %v", err)
+	}
+	defer sender.Close()
+
+	// Send until the listener is up and the first beacon arrives
+	valid := Beacon{ClusterID: clusterID, Port: 8080}
+	deadline := time.After(5 * time.Second)
+	for received := false; !received; {
+		if err := sender.Send(valid, testSecret); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		select {
+		case nodeURL := <-found:
+			if nodeURL != "http://127.0.0.1:8080" {
+				t.Fatalf("found %q, want http://127.0.0.1:8080", nodeURL)
+			}
+			received = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no beacon received")
+		}
+	}
+	for len(found) > 0 {
+		<-found
+	}
+
+	// None of these may reach found
+	if err := sender.Send(Beacon{ClusterID: "other", Port: 8081}, testSecret); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := sender.Send(Beacon{ClusterID: clusterID, Port: 8082}, "wrong-secret"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	forged := Beacon{ClusterID: clusterID, Port: 8083, Timestamp: time.Now().Unix()}
+	forged.Sign(testSecret)
+	forged.Port = 8084
+	sendRaw(t, sender, forged)
+	stale := Beacon{ClusterID: clusterID, Port: 8085, Timestamp: time.Now().Add(-MaxClockSkew - time.Minute).Unix()}
+	stale.Sign(testSecret)
+	sendRaw(t, sender, stale)
+
+	// Loopback delivers in order, so the next URL found must be this one
+	if err := sender.Send(Beacon{ClusterID: clusterID, Address: "10.0.0.5", Port: 8443, Scheme: "https"}, testSecret); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case nodeURL := <-found:
+		if nodeURL != "https://10.0.0.5:8443" {
+			t.Fatalf("found %q, an invalid beacon was accepted", nodeURL)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("valid beacon not received")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("ListenBeacons: %v", err)
+	}
+}
+
+func TestBeaconRequiresSecret(t *testing.T) {
+	sender, err := NewBeaconSender("127.0.0.1", freeUDPPort(t))
+	if err != nil {
+		t.Fatalf("NewBeaconSender: %v", err)
+	}
+	defer sender.Close()
+
+	if err := sender.Send(Beacon{ClusterID: "lab", Port: 8080}, ""); !errors.Is(err, ErrNoClusterSecret) {
+		t.Errorf("Send without secret: %v, want ErrNoClusterSecret", err)
+	}
+	err = ListenBeacons(context.Background(), freeUDPPort(t), "lab", "", func(string) {})
+	if !errors.Is(err, ErrNoClusterSecret) {
+		t.Errorf("ListenBeacons without secret: %v, want ErrNoClusterSecret", err)
+	}
+}
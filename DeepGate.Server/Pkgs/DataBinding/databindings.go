@@ -2,8 +2,7 @@ package databinding
 
 import (
 	"context"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -18,6 +17,43 @@ type InfoPackage struct {
 	HostName   string `json:"host_name"`
 	Timestamp  int64  `json:"timestamp"`
 	HostPort   string `json:"host_port"`
+	Scheme     string `json:"scheme,omitempty"`    // http or https, http when empty
+	Capacity   int    `json:"capacity"`            // Relative weight used by the weighted scheduler
+	Signature  string `json:"signature,omitempty"` // HMAC of the other fields with the cluster secret
+}
+
+// PingResponse is the Node's answer to a host registering through /ping
+type PingResponse struct {
+	Status    string `json:"status"`
+	HostToken string `json:"host_token,omitempty"` // Set when the cluster secret is configured
+}
+
+// HeartbeatResponse is the Node's answer to a heartbeat
+type HeartbeatResponse struct {
+	Status    string `json:"status"`
+	HostToken string `json:"host_token,omitempty"` // Renewed host token
+}
+
+// Heartbeat is sent periodically by a host to keep its registration alive
+type Heartbeat struct {
+	IPAddress    string   `json:"ip_address"`
+	HostPort     string   `json:"host_port"`
+	Timestamp    int64    `json:"timestamp"`
+	LoadedModels []string `json:"loaded_models"` // Models currently loaded in memory
+}
+
+// Deregistration is sent by a host that is shutting down, so that the Node
+// stops scheduling to it
+type Deregistration struct {
+	IPAddress string `json:"ip_address"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ModelStatus reports a change of a model's loaded state on a host
+type ModelStatus struct {
+	IPAddress string `json:"ip_address"`
+	Model     string `json:"model"`
+	Loaded    bool   `json:"loaded"`
 }
 
 // DatabaseConnections holds connections for MongoDB and Redis
@@ -27,33 +63,43 @@ type DatabaseConnections struct {
 	MongoDB     *mongo.Database
 }
 
+// DatabaseConfig locates the MongoDB and Redis of a Node
+type DatabaseConfig struct {
+	MongoURI      string
+	MongoDatabase string
+	RedisAddress  string
+	RedisPassword string
+	RedisDB       int
+}
+
 // InitializeDatabases sets up MongoDB and Redis connections
-func InitializeDatabases() (*DatabaseConnections, error) {
+func InitializeDatabases(config DatabaseConfig) (*DatabaseConnections, error) {
 	// MongoDB Connection
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	mongoURI := "mongodb://localhost:27017"
-	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
-		log.Printf("Error connecting to MongoDB: %v", err)
+		slog.Error("Error connecting to MongoDB", "error", err)
 		return nil, err
 	}
 
 	// Redis Connection
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+		Addr:     config.RedisAddress,
+		Password: config.RedisPassword,
+		DB:       config.RedisDB,
 	})
 
 	// Test Redis connection
 	_, err = redisClient.Ping(ctx).Result()
 	if err != nil {
-		log.Printf("Error connecting to Redis: %v", err)
+		slog.Error("Error connecting to Redis", "error", err)
 		return nil, err
 	}
 
 	// Initialize database
-	database := mongoClient.Database("network_discovery")
+	database := mongoClient.Database(config.MongoDatabase)
 
 	return &DatabaseConnections{
 		MongoClient: mongoClient,
@@ -61,8 +107,3 @@ func InitializeDatabases() (*DatabaseConnections, error) {
 		MongoDB:     database,
 	}, nil
 }
-
-// ConfigureLogger sets up a custom logger with timestamps
-func ConfigureLogger() *log.Logger {
-	return log.New(os.Stdout, "[DEEPGATE] ", log.Ldate|log.Ltime|log.Lshortfile)
-}
@@ -0,0 +1,81 @@
+package databinding
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// Nodes advertise themselves over mDNS/DNS-SD so that Hosts on the same
+// network find them without scanning. The TXT record carries the scheme the
+// Node is served with:
+//
+//	_deepgate._tcp.local.  "scheme=https" "version=1"
+
+// DiscoveryService is the DNS-SD service type Nodes advertise
+const DiscoveryService = "_deepgate._tcp"
+
+// discoveryDomain is the mDNS domain
+const discoveryDomain = "local."
+
+// AdvertiseNode announces a Node listening on port until the returned
+// function is called
+func AdvertiseNode(port int, scheme string) (func(), error) {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "deepgate-node"
+	}
+
+	text := []string{"scheme=" + scheme, "version=" + StreamProtocolVersion}
+	server, err := zeroconf.Register(instance, DiscoveryService, discoveryDomain, port, text, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to advertise %s: %v", DiscoveryService, err)
+	}
+	return server.Shutdown, nil
+}
+
+// BrowseNodes returns the base URLs of the Nodes advertised on the network
+// until ctx is done
+func BrowseNodes(ctx context.Context) ([]string, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mDNS resolver: %v", err)
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, DiscoveryService, discoveryDomain, entries); err != nil {
+		return nil, fmt.Errorf("failed to browse %s: %v", DiscoveryService, err)
+	}
+
+	// The resolver closes entries once ctx is done
+	seen := make(map[string]bool)
+	var urls []string
+	for entry := range entries {
+		if len(entry.AddrIPv4) == 0 {
+			continue
+		}
+		url := nodeURL(entry.AddrIPv4[0], entry.Port, entry.Text)
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls, nil
+}
+
+// nodeURL builds the base URL of an advertised Node, http unless its TXT
+// record says otherwise
+func nodeURL(ip net.IP, port int, text []string) string {
+	scheme := "http"
+	for _, record := range text {
+		if value, found := strings.CutPrefix(record, "scheme="); found && value == "https" {
+			scheme = value
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
@@ -0,0 +1,125 @@
+package databinding
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hosts enroll with a Node through a secret shared by the whole cluster. A
+// Host signs the InfoPackage it registers with, and the Node answers with a
+// host token bound to the Host's address. The token authenticates the Node's
+// calls to the Host and the Host's heartbeats to the Node.
+
+// HostTokenTTL is how long a host token stays valid. Nodes renew the token
+// with every heartbeat.
+const HostTokenTTL = 24 * time.Hour
+
+// MaxClockSkew is how far the timestamp of a signed InfoPackage may be off
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidHostToken = errors.New("invalid host token")
+	ErrExpiredHostToken = errors.New("expired host token")
+)
+
+// HostToken is the payload of a host token
+type HostToken struct {
+	HostIP    string `json:"sub"`
+	ExpiresAt int64  `json:"exp"` // Unix seconds
+}
+
+// Sign signs the package with the cluster secret
+func (p *InfoPackage) Sign(secret string) {
+	p.Signature = hex.EncodeToString(p.mac(secret))
+}
+
+// Verify checks the signature of the package and that it was signed recently
+func (p InfoPackage) Verify(secret string, now time.Time) error {
+	signature, err := hex.DecodeString(p.Signature)
+	if err != nil || !hmac.Equal(signature, p.mac(secret)) {
+		return ErrInvalidSignature
+	}
+
+	skew := now.Sub(time.Unix(p.Timestamp, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("signed package is %v off the node clock", skew.Round(time.Second))
+	}
+	return nil
+}
+
+// mac authenticates every field of the package but the signature
+func (p InfoPackage) mac(secret string) []byte {
+	fields := []string{
+		"deepgate-enroll",
+		p.IPAddress,
+		strconv.Itoa(p.Identifier),
+		p.HostName,
+		strconv.FormatInt(p.Timestamp, 10),
+		p.HostPort,
+		p.Scheme,
+		strconv.Itoa(p.Capacity),
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	return mac.Sum(nil)
+}
+
+// IssueHostToken signs a token for the host at hostIP
+func IssueHostToken(secret, hostIP string, now time.Time) (string, error) {
+	payload, err := json.Marshal(HostToken{
+		HostIP:    hostIP,
+		ExpiresAt: now.Add(HostTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal host token: %v", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(hostTokenMAC(secret, encoded))
+	return encoded + "." + signature, nil
+}
+
+// VerifyHostToken checks the signature and expiry of a host token and
+// returns its payload
+func VerifyHostToken(secret, token string, now time.Time) (*HostToken, error) {
+	encoded, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidHostToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, hostTokenMAC(secret, encoded)) {
+		return nil, ErrInvalidHostToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidHostToken
+	}
+	var hostToken HostToken
+	if err := json.Unmarshal(payload, &hostToken); err != nil {
+		return nil, ErrInvalidHostToken
+	}
+
+	if now.Unix() >= hostToken.ExpiresAt {
+		return nil, ErrExpiredHostToken
+	}
+	return &hostToken, nil
+}
+
+// hostTokenMAC signs the encoded payload of a host token
+func hostTokenMAC(secret, encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("deepgate-host-token\n" + encoded))
+	return mac.Sum(nil)
+}
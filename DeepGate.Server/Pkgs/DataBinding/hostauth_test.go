@@ -0,0 +1,130 @@
+package databinding
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "cluster-secret"
+
+func signedPackage(now time.Time) InfoPackage {
+	infoPackage := InfoPackage{
+		IPAddress:  "10.0.0.7",
+		Identifier: 0,
+		HostName:   "gpu-1",
+		Timestamp:  now.Unix(),
+		HostPort:   "9090",
+		Scheme:     "https",
+		Capacity:   2,
+	}
+	infoPackage.Sign(testSecret)
+	return infoPackage
+}
+
+func TestInfoPackageVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name    string
+		tamper  func(p *InfoPackage)
+		secret  string
+		now     time.Time
+		wantErr error // nil for success, ErrInvalidSignature or errAny
+	}{
+		{name: "valid", secret: testSecret, now: now},
+		{name: "wrong secret", secret: "other-secret", now: now, wantErr: ErrInvalidSignature},
+		{name: "tampered address", tamper: func(p *InfoPackage) { p.IPAddress = "10.0.0.8" }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "tampered port", tamper: func(p *InfoPackage) { p.HostPort = "9091" }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "tampered scheme", tamper: func(p *InfoPackage) { p.Scheme = "http" }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "tampered capacity", tamper: func(p *InfoPackage) { p.Capacity = 100 }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "tampered timestamp", tamper: func(p *InfoPackage) { p.Timestamp++ }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "malformed signature", tamper: func(p *InfoPackage) { p.Signature = "not-hex" }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "missing signature", tamper: func(p *InfoPackage) { p.Signature = "" }, secret: testSecret, now: now, wantErr: ErrInvalidSignature},
+		{name: "within skew", secret: testSecret, now: now.Add(MaxClockSkew - time.Second)},
+		{name: "signed too long ago", secret: testSecret, now: now.Add(MaxClockSkew + time.Second), wantErr: errAny},
+		{name: "signed in the future", secret: testSecret, now: now.Add(-MaxClockSkew - time.Second), wantErr: errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infoPackage := signedPackage(now)
+			if tt.tamper != nil {
+				tt.tamper(&infoPackage)
+			}
+			checkErr(t, infoPackage.Verify(tt.secret, tt.now), tt.wantErr)
+		})
+	}
+}
+
+func TestVerifyHostToken(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	token, err := IssueHostToken(testSecret, "10.0.0.7", now)
+	if err != nil {
+		t.Fatalf("IssueHostToken: %v", err)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"10.0.0.8","exp":9999999999}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  string
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", token: token, secret: testSecret, now: now},
+		{name: "valid until expiry", token: token, secret: testSecret, now: now.Add(HostTokenTTL - time.Second)},
+		{name: "expired", token: token, secret: testSecret, now: now.Add(HostTokenTTL), wantErr: ErrExpiredHostToken},
+		{name: "wrong secret", token: token, secret: "other-secret", now: now, wantErr: ErrInvalidHostToken},
+		{name: "tampered payload", token: forged + "." + signature, secret: testSecret, now: now, wantErr: ErrInvalidHostToken},
+		{name: "tampered signature", token: encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), secret: testSecret, now: now, wantErr: ErrInvalidHostToken},
+		{name: "missing signature", token: encoded, secret: testSecret, now: now, wantErr: ErrInvalidHostToken},
+		{name: "empty", token: "", secret: testSecret, now: now, wantErr: ErrInvalidHostToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostToken, err := VerifyHostToken(tt.secret, tt.token, tt.now)
+			checkErr(t, err, tt.wantErr)
+			if err == nil && hostToken.HostIP != "10.0.0.7" {
+				t.Errorf("HostIP = %q, want 10.0.0.7", hostToken.HostIP)
+			}
+		})
+	}
+}
+
+func TestIssueHostTokenExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	token, err := IssueHostToken(testSecret, "10.0.0.7", now)
+	if err != nil {
+		t.Fatalf("IssueHostToken: %v", err)
+	}
+	hostToken, err := VerifyHostToken(testSecret, token, now)
+	if err != nil {
+		t.Fatalf("VerifyHostToken: %v", err)
+	}
+	if want := now.Add(HostTokenTTL).Unix(); hostToken.ExpiresAt != want {
+		t.Errorf("ExpiresAt = %d, want %d", hostToken.ExpiresAt, want)
+	}
+}
+
+// errAny matches any non-nil error in checkErr
+var errAny = errors.New("any error")
+
+func checkErr(t *testing.T, err, want error) {
+	t.Helper()
+	switch {
+	case want == nil && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want == errAny && err == nil:
+		t.Fatal("expected an error")
+	case want != nil && want != errAny && !errors.Is(err, want):
+		t.Fatalf("error = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,159 @@
+package databinding
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Chat streams between Host, Node and clients are server-sent events. Every
+// frame names its type in the event field and carries a StreamEvent as a
+// single line of JSON:
+//
+//	event: token
+//	data: {"type":"token","content":"Hello"}
+//
+// A stream always ends with either a done or an error event.
+
+// StreamProtocolVersion is the version of the chat streaming protocol
+const StreamProtocolVersion = "1"
+
+// StreamVersionHeader advertises the protocol version on streaming responses
+const StreamVersionHeader = "X-DeepGate-Stream-Version"
+
+// CheckStreamVersion rejects a streaming response that speaks another version
+// of the protocol. Responses without the version header predate it and are
+// read as version 1.
+func CheckStreamVersion(header http.Header) error {
+	version := header.Get(StreamVersionHeader)
+	if version != "" && version != StreamProtocolVersion {
+		return fmt.Errorf("unsupported stream protocol version %q, want %s", version, StreamProtocolVersion)
+	}
+	return nil
+}
+
+// Stream event types
+const (
+	EventToken    = "token"     // A piece of the answer
+	EventThinking = "thinking"  // A piece of the model's reasoning
+	EventToolCall = "tool_call" // Tool calls requested by the model
+	EventUsage    = "usage"     // Token counts and timings of the completion
+	EventError    = "error"     // The stream failed, no more events follow
+	EventDone     = "done"      // The stream completed, no more events follow
+)
+
+// StreamEvent is a single frame of a chat stream
+type StreamEvent struct {
+	Type       string     `json:"type"`
+	Content    string     `json:"content,omitempty"`     // token, thinking
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`  // tool_call
+	Usage      *Usage     `json:"usage,omitempty"`       // usage
+	Error      string     `json:"error,omitempty"`       // error
+	DoneReason string     `json:"done_reason,omitempty"` // done
+}
+
+// ToolCall represents a tool invocation requested by the model
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+// Usage holds Ollama's token counts and timings, durations are in nanoseconds
+type Usage struct {
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	EvalCount          int   `json:"eval_count"`
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
+
+// IsTerminal reports whether no more events follow this one
+func (e StreamEvent) IsTerminal() bool {
+	return e.Type == EventDone || e.Type == EventError
+}
+
+// WriteStreamEvent writes a single event frame
+func WriteStreamEvent(w io.Writer, event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream event: %v", err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// StreamReader reads whole event frames from a chat stream
+type StreamReader struct {
+	reader *bufio.Reader
+}
+
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// Next blocks until a complete event has been read. It returns io.EOF once
+// the stream ends without a pending event.
+func (s *StreamReader) Next() (*StreamEvent, error) {
+	var eventType string
+	var dataLines []string
+	hasFields := false
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && hasFields {
+				return parseStreamEvent(eventType, dataLines)
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// A blank line terminates the current event
+		if line == "" {
+			if !hasFields {
+				continue
+			}
+			return parseStreamEvent(eventType, dataLines)
+		}
+
+		// Comment line
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+			hasFields = true
+		case "data":
+			dataLines = append(dataLines, value)
+			hasFields = true
+		}
+	}
+}
+
+// parseStreamEvent decodes the data of a frame, the event field wins over the
+// type in the payload
+func parseStreamEvent(eventType string, dataLines []string) (*StreamEvent, error) {
+	var event StreamEvent
+	if err := json.Unmarshal([]byte(strings.Join(dataLines, "\n")), &event); err != nil {
+		return nil, fmt.Errorf("invalid stream event: %v", err)
+	}
+	if eventType != "" {
+		event.Type = eventType
+	}
+	return &event, nil
+}
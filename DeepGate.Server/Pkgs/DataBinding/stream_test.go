@@ -0,0 +1,181 @@
+package databinding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	events := []StreamEvent{
+		{Type: EventThinking, Content: "Let me think"},
+		{Type: EventToken, Content: "line one\nline two\n\n"},
+		{Type: EventToolCall, ToolCalls: []ToolCall{{Function: ToolCallFunction{Name: "weather", Arguments: map[string]interface{}{"city": "Paris"}}}}},
+		{Type: EventUsage, Usage: &Usage{PromptEvalCount: 12, EvalCount: 34, TotalDuration: 5_000_000}},
+		{Type: EventDone, DoneReason: "stop"},
+	}
+
+	var buffer bytes.Buffer
+	for _, event := range events {
+		if err := WriteStreamEvent(&buffer, event); err != nil {
+			t.Fatalf("WriteStreamEvent: %v", err)
+		}
+	}
+
+	reader := NewStreamReader(&buffer)
+	for i, want := range events {
+		got, err := reader.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("event %d = %+v, want %+v", i, *got, want)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("after the last event: %v, want io.EOF", err)
+	}
+}
+
+func TestWriteStreamEventFraming(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteStreamEvent(&buffer, StreamEvent{Type: EventToken, Content: "a\nb"}); err != nil {
+		t.Fatalf("WriteStreamEvent: %v", err)
+	}
+
+	// Newlines in the content are escaped, the data stays on one line
+	want := "event: token\ndata: {\"type\":\"token\",\"content\":\"a\\nb\"}\n\n"
+	if buffer.String() != want {
+		t.Errorf("frame = %q, want %q", buffer.String(), want)
+	}
+}
+
+func TestStreamReaderFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    []StreamEvent
+		wantErr bool // The read after the expected events fails with something else than io.EOF
+	}{
+		{
+			name:   "multi-line data",
+			stream: "event: token\ndata: {\"type\":\"token\",\ndata: \"content\":\"hi\"}\n\n",
+			want:   []StreamEvent{{Type: EventToken, Content: "hi"}},
+		},
+		{
+			name:   "CRLF line endings",
+			stream: "event: token\r\ndata: {\"content\":\"hi\"}\r\n\r\n",
+			want:   []StreamEvent{{Type: EventToken, Content: "hi"}},
+		},
+		{
+			name:   "comments and blank lines are skipped",
+			stream: ": keep-alive\n\n\nevent: done\ndata: {}\n\n",
+			want:   []StreamEvent{{Type: EventDone}},
+		},
+		{
+			name:   "event field wins over the payload type",
+			stream: "event: thinking\ndata: {\"type\":\"token\",\"content\":\"hmm\"}\n\n",
+			want:   []StreamEvent{{Type: EventThinking, Content: "hmm"}},
+		},
+		{
+			name:   "payload type without event field",
+			stream: "data: {\"type\":\"token\",\"content\":\"hi\"}\n\n",
+			want:   []StreamEvent{{Type: EventToken, Content: "hi"}},
+		},
+		{
+			name:   "unknown event type is passed on",
+			stream: "event: progress\ndata: {\"content\":\"50%\"}\n\nevent: done\ndata: {}\n\n",
+			want:   []StreamEvent{{Type: "progress", Content: "50%"}, {Type: EventDone}},
+		},
+		{
+			name:   "unknown fields are ignored",
+			stream: "id: 7\nretry: 100\nevent: token\ndata: {\"content\":\"hi\",\"extra\":1}\n\n",
+			want:   []StreamEvent{{Type: EventToken, Content: "hi"}},
+		},
+		{
+			name:   "last frame without blank line",
+			stream: "event: done\ndata: {\"done_reason\":\"stop\"}\n",
+			want:   []StreamEvent{{Type: EventDone, DoneReason: "stop"}},
+		},
+		{
+			name:    "truncated frame",
+			stream:  "event: token\ndata: {\"content\":\"hi\"}\n\nevent: token\ndata: {\"cont",
+			want:    []StreamEvent{{Type: EventToken, Content: "hi"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			stream:  "event: token\ndata: not json\n\n",
+			wantErr: true,
+		},
+		{
+			name:   "empty stream",
+			stream: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewStreamReader(strings.NewReader(tt.stream))
+			for i, want := range tt.want {
+				got, err := reader.Next()
+				if err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(*got, want) {
+					t.Errorf("event %d = %+v, want %+v", i, *got, want)
+				}
+			}
+
+			_, err := reader.Next()
+			if tt.wantErr {
+				if err == nil || errors.Is(err, io.EOF) {
+					t.Errorf("final read: %v, want a decoding error", err)
+				}
+			} else if err != io.EOF {
+				t.Errorf("final read: %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestCheckStreamVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		wantErr bool
+	}{
+		{"current version", StreamProtocolVersion, false},
+		{"no header", "", false},
+		{"other version", "2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.version != "" {
+				header.Set(StreamVersionHeader, tt.version)
+			}
+			if err := CheckStreamVersion(header); (err != nil) != tt.wantErr {
+				t.Errorf("CheckStreamVersion(%q) = %v, want error %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	for _, eventType := range []string{EventToken, EventThinking, EventToolCall, EventUsage} {
+		if (StreamEvent{Type: eventType}).IsTerminal() {
+			t.Errorf("%s is terminal", eventType)
+		}
+	}
+	for _, eventType := range []string{EventDone, EventError} {
+		if !(StreamEvent{Type: eventType}).IsTerminal() {
+			t.Errorf("%s is not terminal", eventType)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package databinding
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSConfig holds the certificate paths of a Node or Host
+type TLSConfig struct {
+	CertFile     string // Certificate served, and presented to servers requiring client certificates
+	KeyFile      string // Private key of CertFile
+	ClientCAFile string // CA bundle client certificates must chain to, no client certificates are required when empty
+	CAFile       string // CA bundle trusted for outgoing connections in addition to the system roots
+}
+
+// Enabled reports whether the server is served over TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Scheme returns the URL scheme the server is reachable with
+func (c TLSConfig) Scheme() string {
+	if c.Enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// ServerConfig builds the TLS configuration of the HTTP server
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("both a TLS certificate and key are required")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile, x509.NewCertPool())
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// ClientConfig builds the TLS configuration of outgoing connections. The
+// server certificate is presented as client certificate when there is one.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if config.RootCAs, err = loadCertPool(c.CAFile, roots); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Enabled() {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// NewServer creates the HTTP server of a Node or Host, serving TLS when a
+// certificate is configured
+func (c TLSConfig) NewServer(addr string, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		config, err := c.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = config
+	}
+
+	return server, nil
+}
+
+// ListenAndServe serves a server created by NewServer. It returns nil once
+// the server is shut down.
+func ListenAndServe(server *http.Server) error {
+	listener, err := Listen(server)
+	if err != nil {
+		return err
+	}
+	return Serve(server, listener)
+}
+
+// Listen binds the address of a server created by NewServer, so that it
+// accepts connections before Serve is called
+func Listen(server *http.Server) (net.Listener, error) {
+	return net.Listen("tcp", server.Addr)
+}
+
+// Serve serves a server created by NewServer on a listener from Listen. It
+// returns nil once the server is shut down.
+func Serve(server *http.Server, listener net.Listener) error {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops a server from accepting connections and waits up to timeout
+// for active requests to finish. Requests still running after the timeout are
+// cut off.
+func Shutdown(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests did not finish in %v: %v", timeout, err)
+	}
+	return nil
+}
+
+// loadCertPool adds the PEM certificates of a bundle to pool
+func loadCertPool(path string, pool *x509.CertPool) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,96 @@
+package databinding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of DeepGate's own spans
+const tracerName = "deepgate"
+
+// ConfigureTracing sets up the global tracer provider for a service and W3C
+// trace context propagation. The exporter "otlp" sends spans over OTLP/HTTP
+// to endpoint (or the standard OTEL_EXPORTER_OTLP_* variables when empty),
+// "stdout" prints them for local testing, and anything else disables export.
+// The returned function flushes and stops the provider.
+func ConfigureTracing(ctx context.Context, service, exporterName, endpoint string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporterName) {
+	case "otlp":
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		// Without an exporter an incoming trace context is still forwarded
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("deepgate-"+service),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a span as a child of the span carried by ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends a span, marking it failed when err is set
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		FailSpan(span, err)
+	}
+	span.End()
+}
+
+// FailSpan records err on a span and marks it failed. Cancellations by the
+// client are recorded but do not fail the span.
+func FailSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	if !errors.Is(err, context.Canceled) {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// InjectTraceContext adds the traceparent of the span carried by ctx to the
+// headers of an outgoing request
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}